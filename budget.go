@@ -0,0 +1,50 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"sync/atomic"
+)
+
+// errBudgetExceeded fails reads of part content once a fetch has downloaded
+// more than its Options.BytesBudget
+var errBudgetExceeded = errors.New("Byte budget exceeded")
+
+// overBudget reports whether the session has downloaded more bytes than its
+// BytesBudget
+func (s *fetchSession) overBudget() bool {
+	return s.opts.BytesBudget > 0 && atomic.LoadInt64(&s.downloadedBytes) > s.opts.BytesBudget
+}
+
+// budgetError returns the PkgBudgetExceededError of a fetch aborted for
+// downloading more than its BytesBudget
+func (s *fetchSession) budgetError() error {
+	downloaded := atomic.LoadInt64(&s.downloadedBytes)
+	return fetcherrors.PkgBudgetExceededError{fmt.Sprintf("Downloaded %v bytes, more than the budget of %v bytes", downloaded, s.opts.BytesBudget), errBudgetExceeded, downloaded}
+}
+
+// checkBudget returns a PkgBudgetExceededError if the parts of groups, each
+// group downloaded once, that aren't already in destinationDir total more
+// than the session's BytesBudget
+func (s *fetchSession) checkBudget(groups [][]string, parts horizonpkg.DockerImageParts, destinationDir string) error {
+	if s.opts.BytesBudget <= 0 {
+		return nil
+	}
+
+	var estimate int64
+	for _, names := range groups {
+		part := parts[names[0]]
+		if size, err := s.partSize(s.partPath(destinationDir, names[0])); err == nil && size == part.Bytes {
+			continue
+		}
+		estimate += part.Bytes
+	}
+
+	if estimate > s.opts.BytesBudget {
+		return fetcherrors.PkgBudgetExceededError{fmt.Sprintf("Parts to download total %v bytes, more than the budget of %v bytes", estimate, s.opts.BytesBudget), fmt.Errorf("Refused to fetch parts into %v", destinationDir), 0}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func Test_BytesBudget_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-budget-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := []byte("0123456789")
+
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.URL.Path)
+		lock.Unlock()
+
+		// the bad source serves more than the part, without a Content-Length
+		if r.URL.Path == "/bad" {
+			w.Write(content)
+			w.(http.Flusher).Flush()
+			w.Write(content)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	requested := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		r := requests
+		requests = nil
+		return r
+	}
+
+	part := func(id string, sources ...string) horizonpkg.DockerImagePart {
+		var partSources []horizonpkg.PartSource
+		for _, source := range sources {
+			partSources = append(partSources, horizonpkg.PartSource{URL: source})
+		}
+		return horizonpkg.DockerImagePart{
+			ID:        id,
+			Bytes:     int64(len(content)),
+			Sha256sum: fmt.Sprintf("%x", sha256.Sum256(append([]byte(id), content...))),
+			Sources:   partSources,
+		}
+	}
+
+	fetch := func(name string, budget int64, parts horizonpkg.DockerImageParts) (*fetchSession, error) {
+		pkgDir := path.Join(tmpDir, name)
+		assert.Nil(suite, os.MkdirAll(pkgDir, 0700))
+
+		session := newFetchSession(Options{BytesBudget: budget, InsecureSkipSignatureVerification: true})
+		_, err := fetchAndVerify(context.Background(), &http.Client{}, nil, server.URL, parts, pkgDir, "", "", session)
+		return session, err
+	}
+
+	suite.Run("fetch of more than the budget is refused before downloading", func(t *testing.T) {
+		_, err := fetch("refused", 15, horizonpkg.DockerImageParts{"a": part("a", "/a"), "b": part("b", "/b")})
+		assert.IsType(t, fetcherrors.PkgBudgetExceededError{}, err)
+		assert.Empty(t, requested())
+	})
+
+	suite.Run("parts already present don't count against the budget", func(t *testing.T) {
+		pkgDir := path.Join(tmpDir, "present")
+		assert.Nil(t, os.MkdirAll(pkgDir, 0700))
+		assert.Nil(t, ioutil.WriteFile(path.Join(pkgDir, "a"), content, 0600))
+
+		parts := horizonpkg.DockerImageParts{"a": part("a", "/a"), "b": part("b", "/b")}
+		session := newFetchSession(Options{BytesBudget: 15})
+		assert.Nil(t, session.checkBudget(session.partGroups(parts), parts, pkgDir))
+		assert.IsType(t, fetcherrors.PkgBudgetExceededError{}, session.checkBudget(session.partGroups(parts), parts, path.Join(tmpDir, "absent")))
+	})
+
+	suite.Run("fetch is aborted once sources serve more than the budget", func(t *testing.T) {
+		_, err := fetch("aborted", 10, horizonpkg.DockerImageParts{"a": part("a", "/bad", "/a")})
+		assert.IsType(t, fetcherrors.PkgBudgetExceededError{}, err)
+		assert.True(t, err.(fetcherrors.PkgBudgetExceededError).BytesDownloaded > 10)
+
+		// the next source isn't downloaded from
+		assert.Equal(t, []string{"/bad"}, requested())
+	})
+}
@@ -0,0 +1,150 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// errRangesUnsupported indicates that a source doesn't advertise support for
+// range requests so a part can't be downloaded from it in chunks
+var errRangesUnsupported = errors.New("Source doesn't support range requests")
+
+// chunked reports whether the part downloaded by download, of expectedBytes
+// with the given encoding, is downloaded in concurrent chunks: it must be
+// larger than the session's ChunkBytes, unencoded and written to a new file
+// that can be written at offsets
+func (s *fetchSession) chunked(expectedBytes int64, encoding horizonpkg.PartEncoding, download *partDownload) bool {
+	if s.opts.ParallelChunks < 2 || s.opts.ChunkBytes <= 0 || expectedBytes <= s.opts.ChunkBytes {
+		return false
+	}
+
+	if encoding != "" || download.resumable || download.offset != 0 || s.opts.Destination != nil {
+		return false
+	}
+
+	_, ok := download.file.(io.WriterAt)
+	return ok
+}
+
+// fetchChunks downloads the part of expectedBytes from pURL into file in
+// chunks of the session's ChunkBytes, up to ParallelChunks of them at once,
+// each written at its offset. errRangesUnsupported is returned, and nothing
+// is written, if a HEAD request to pURL doesn't report Accept-Ranges: bytes
+// and the part's size. The HEAD response is returned for its validators; its
+// body is closed.
+func fetchChunks(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, partID string, pURL string, expectedBytes int64, file io.WriterAt, session *fetchSession) (*http.Response, error) {
+	req, err := authenticatedRequest(ctx, pURL, authCreds, session)
+	if err != nil {
+		return nil, err
+	}
+	req.Method = http.MethodHead
+
+	head, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	head.Body.Close()
+
+	if head.StatusCode != http.StatusOK || !strings.Contains(head.Header.Get("Accept-Ranges"), "bytes") || head.ContentLength != expectedBytes {
+		return nil, errRangesUnsupported
+	}
+
+	// a failed chunk cancels the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lock sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	slots := make(chan struct{}, session.opts.ParallelChunks)
+	var group sync.WaitGroup
+
+	for start := int64(0); start < expectedBytes; start += session.opts.ChunkBytes {
+		end := start + session.opts.ChunkBytes - 1
+		if end >= expectedBytes {
+			end = expectedBytes - 1
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		group.Add(1)
+		go func(start int64, end int64) {
+			defer group.Done()
+			defer func() { <-slots }()
+
+			if err := fetchChunk(ctx, client, authCreds, partID, pURL, start, end, file, session); err != nil {
+				fail(err)
+			}
+		}(start, end)
+	}
+
+	group.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+
+	return head, firstErr
+}
+
+// fetchChunk downloads bytes start through end of the part from pURL into
+// file at their offset
+func fetchChunk(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, partID string, pURL string, start int64, end int64, file io.WriterAt, session *fetchSession) error {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	response, err := requestWithRetries(ctx, client, authCreds, partID, pURL, 0, header, session)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		return statusError{response.StatusCode, fmt.Errorf("Source %v didn't serve bytes %v-%v of part", pURL, start, end), session.statusClass(response.StatusCode)}
+	}
+
+	if rangeStart, ok := contentRangeStart(response); !ok || rangeStart != start {
+		return fmt.Errorf("Content-Range of response from %v is %v and chunk should start at byte %v", pURL, response.Header.Get("Content-Range"), start)
+	}
+
+	var body io.Reader = response.Body
+	if session.opts.StallTimeout > 0 {
+		stallReader := newStallReader(response.Body, session.opts.StallTimeout)
+		defer stallReader.Stop()
+		body = stallReader
+	}
+	body = &countingReader{&contextReader{ctx, body}, session}
+
+	size := end - start + 1
+	written, err := io.Copy(io.NewOffsetWriter(file, start), io.LimitReader(session.throttle(ctx, body), size+1))
+	if err != nil {
+		return fmt.Errorf("IO copy of bytes %v-%v of part from %v failed. Error: %v", start, end, pURL, err)
+	}
+
+	if written != size {
+		return fmt.Errorf("Downloaded %v bytes of the %v bytes from byte %v of part from %v", written, size, start, pURL)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ChunkedDownload_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-chunked-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := make([]byte, 10*1024+17)
+	rand.New(rand.NewSource(1)).Read(content)
+	sources := []horizonpkg.PartSource{{URL: "/part"}}
+
+	var lock sync.Mutex
+	var ranges []string
+	requested := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		served := ranges
+		ranges = nil
+		return served
+	}
+
+	// serves ranges of the content except those starting at a failing offset
+	failing := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		if r.Method == http.MethodGet {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		fail := failing != "" && strings.HasPrefix(r.Header.Get("Range"), failing)
+		lock.Unlock()
+
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "part", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	noRanges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer noRanges.Close()
+
+	fetch := func(serverURL string, partPath string, opts Options) []byte {
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, serverURL, "part", partPath, int64(len(content)), "", sources, newFetchSession(opts))
+		assert.Nil(suite, err)
+
+		written, err := ioutil.ReadFile(partPath)
+		assert.Nil(suite, err)
+		return written
+	}
+
+	suite.Run("chunks reassemble the part byte for byte", func(t *testing.T) {
+		single := fetch(server.URL, path.Join(tmpDir, "single"), Options{})
+		assert.Equal(t, []string{""}, requested())
+
+		chunked := fetch(server.URL, path.Join(tmpDir, "chunked"), Options{ParallelChunks: 4, ChunkBytes: 1024})
+		assert.Equal(t, single, chunked)
+		assert.Equal(t, content, chunked)
+
+		served := requested()
+		assert.Equal(t, 11, len(served))
+		assert.Contains(t, served, "bytes=0-1023")
+		assert.Contains(t, served, "bytes=10240-10256")
+	})
+
+	suite.Run("part no larger than a chunk is downloaded in a single stream", func(t *testing.T) {
+		fetch(server.URL, path.Join(tmpDir, "small"), Options{ParallelChunks: 4, ChunkBytes: int64(len(content))})
+		assert.Equal(t, []string{""}, requested())
+	})
+
+	suite.Run("source without range support is downloaded in a single stream", func(t *testing.T) {
+		assert.Equal(t, content, fetch(noRanges.URL, path.Join(tmpDir, "unranged"), Options{ParallelChunks: 4, ChunkBytes: 1024}))
+	})
+
+	suite.Run("failed chunk falls back to a single stream", func(t *testing.T) {
+		lock.Lock()
+		failing = "bytes=5120-"
+		lock.Unlock()
+
+		assert.Equal(t, content, fetch(server.URL, path.Join(tmpDir, "fallback"), Options{ParallelChunks: 4, ChunkBytes: 1024}))
+
+		// requests of the canceled chunks may arrive after the whole part's
+		assert.Contains(t, requested(), "")
+	})
+}
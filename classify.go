@@ -0,0 +1,188 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// StatusClass is a faux-enum classifying the failed responses of part
+// sources by their HTTP status; see Options.StatusClasses
+type StatusClass string
+
+const (
+	// AUTH responses are authentication or authorization failures: the part
+	// fails with a PkgSourceFetchAuthError, which cancels the fetches of the
+	// Pkg's other parts. 401 and 403 are by default.
+	AUTH StatusClass = "AUTH"
+
+	// TRANSIENT responses may succeed if retried: Retriers provided by the
+	// package retry them and IsTransient reports the part's failure as
+	// transient. 408, 429, 500, 502, 503 and 504 are by default.
+	TRANSIENT StatusClass = "TRANSIENT"
+
+	// PERMANENT responses won't succeed if retried. All other failures are
+	// by default.
+	PERMANENT StatusClass = "PERMANENT"
+)
+
+// statusClassesKey marks the contexts of requests for part content with the
+// session's StatusClasses so that Retriers provided by the package classify
+// their responses with them
+type statusClassesKey struct{}
+
+// withStatusClasses returns ctx marked with the session's StatusClasses, if
+// it has any
+func (s *fetchSession) withStatusClasses(ctx context.Context) context.Context {
+	if len(s.opts.StatusClasses) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, statusClassesKey{}, s.opts.StatusClasses)
+}
+
+// defaultStatusClass returns the class of a failed response with the given
+// HTTP status unless it's overridden
+func defaultStatusClass(statusCode int) StatusClass {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return AUTH
+	case transientStatus(statusCode):
+		return TRANSIENT
+	default:
+		return PERMANENT
+	}
+}
+
+// statusClass returns the class of a failed response with the given HTTP
+// status: its class in the session's StatusClasses or else its default
+func (s *fetchSession) statusClass(statusCode int) StatusClass {
+	if class, ok := s.opts.StatusClasses[statusCode]; ok {
+		return class
+	}
+
+	return defaultStatusClass(statusCode)
+}
+
+// responseStatusClass returns the class of a failed response by the
+// StatusClasses marking its request's context, if any, or else its default
+func responseStatusClass(response *http.Response) StatusClass {
+	if response.Request != nil {
+		if classes, ok := response.Request.Context().Value(statusClassesKey{}).(map[int]StatusClass); ok {
+			if class, ok := classes[response.StatusCode]; ok {
+				return class
+			}
+		}
+	}
+
+	return defaultStatusClass(response.StatusCode)
+}
+
+// statusError records the HTTP status of a source's failed response so the
+// failure can be classified; class overrides the default class of the
+// status if set
+type statusError struct {
+	statusCode int
+	err        error
+	class      StatusClass
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("%v (HTTP status code: %v)", e.err, e.statusCode)
+}
+
+// transientStatus reports whether a source responding with the given HTTP
+// status may succeed if retried: 408, 429, 500, 502, 503 and 504 are
+// transient, all others (notably 400, 401, 403, 404 and 410) are permanent
+func transientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// transientResponse reports whether a request that failed with the given
+// response (nil if none was received) and error may succeed if retried.
+// Network errors are transient, as are responses classified TRANSIENT; the
+// caller must check whether the request was canceled.
+func transientResponse(response *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return response != nil && responseStatusClass(response) == TRANSIENT
+}
+
+// interruptedCopy reports whether a copy from a source's response failed with
+// err because the response was cut short: the connection was reset or closed,
+// or timed out, before all of its bytes were received. The caller must check
+// whether the fetch was canceled.
+func interruptedCopy(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	// syscall.Errno implements net.Error too, so of those only timeouts count
+	// lest a failed write of the part file be taken for an interruption
+	var opErr *net.OpError
+	var netErr net.Error
+	return errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// IsTransient reports whether an error returned by a Pkg fetch is transient,
+// so fetching again may succeed. Network errors, stalled, interrupted or short
+// downloads and transient HTTP statuses are; auth failures and other HTTP
+// statuses are not. The errors err wraps are inspected too; a PkgPartsError
+// is transient if the error of any of its parts is.
+func IsTransient(err error) bool {
+	var partsErr fetcherrors.PkgPartsError
+	if errors.As(err, &partsErr) {
+		for _, partErr := range partsErr.Unwrap() {
+			if IsTransient(partErr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var authErr fetcherrors.PkgSourceFetchAuthError
+	if errors.As(err, &authErr) {
+		return false
+	}
+
+	var fetchErr fetcherrors.PkgSourceFetchError
+	if errors.As(err, &fetchErr) {
+		return IsTransient(fetchErr.InternalError)
+	}
+
+	var stalledErr fetcherrors.PkgSourceStalledError
+	var interruptedErr fetcherrors.PkgSourceInterruptedError
+	var sizeErr fetcherrors.PkgSourceSizeError
+	var contentLengthErr fetcherrors.PkgSourceContentLengthError
+	if errors.As(err, &stalledErr) || errors.As(err, &interruptedErr) || errors.As(err, &sizeErr) || errors.As(err, &contentLengthErr) {
+		return true
+	}
+
+	var statusErr statusError
+	if errors.As(err, &statusErr) {
+		if statusErr.class != "" {
+			return statusErr.class == TRANSIENT
+		}
+		return transientStatus(statusErr.statusCode)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
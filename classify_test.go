@@ -0,0 +1,139 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_Classify_Suite(suite *testing.T) {
+	suite.Run("HTTP statuses are classified", func(t *testing.T) {
+		for _, c := range []struct {
+			statusCode int
+			transient  bool
+		}{
+			{http.StatusRequestTimeout, true},
+			{http.StatusTooManyRequests, true},
+			{http.StatusInternalServerError, true},
+			{http.StatusBadGateway, true},
+			{http.StatusServiceUnavailable, true},
+			{http.StatusGatewayTimeout, true},
+			{http.StatusBadRequest, false},
+			{http.StatusUnauthorized, false},
+			{http.StatusForbidden, false},
+			{http.StatusNotFound, false},
+			{http.StatusGone, false},
+		} {
+			assert.Equal(t, c.transient, transientStatus(c.statusCode), "status %v", c.statusCode)
+			assert.Equal(t, c.transient, transientResponse(&http.Response{StatusCode: c.statusCode}, nil), "status %v", c.statusCode)
+		}
+	})
+
+	suite.Run("network errors are transient", func(t *testing.T) {
+		assert.True(t, transientResponse(nil, errors.New("connection reset")))
+	})
+
+	suite.Run("interrupted copies are classified", func(t *testing.T) {
+		assert.True(t, interruptedCopy(io.ErrUnexpectedEOF))
+		assert.True(t, interruptedCopy(fmt.Errorf("read: %w", syscall.ECONNRESET)))
+		assert.True(t, interruptedCopy(&net.OpError{Op: "read", Err: errors.New("broken")}))
+		assert.False(t, interruptedCopy(&os.PathError{Op: "write", Path: "part", Err: syscall.ENOSPC}))
+		assert.False(t, interruptedCopy(nil))
+	})
+
+	suite.Run("fetch errors are classified", func(t *testing.T) {
+		for _, c := range []struct {
+			err       error
+			transient bool
+		}{
+			{fetcherrors.PkgSourceFetchError{"", statusError{http.StatusServiceUnavailable, errors.New(""), ""}, nil}, true},
+			{fetcherrors.PkgSourceFetchError{"", statusError{http.StatusNotFound, errors.New(""), ""}, nil}, false},
+			{fetcherrors.PkgSourceFetchError{"", &net.OpError{Op: "dial", Err: errors.New("refused")}, nil}, true},
+			{fetcherrors.PkgSourceFetchError{"", fetcherrors.PkgSourceStalledError{"", nil}, nil}, true},
+			{fetcherrors.PkgSourceFetchError{"", fetcherrors.PkgSourceInterruptedError{"", nil}, nil}, true},
+			{fetcherrors.PkgSourceFetchAuthError{"", nil, nil}, false},
+			{fetcherrors.PkgSignatureVerificationError{"", nil}, false},
+			{fmt.Errorf("fetching: %w", fetcherrors.PkgSourceFetchError{"", statusError{http.StatusServiceUnavailable, errors.New(""), ""}, nil}), true},
+			{fmt.Errorf("fetching: %w", fetcherrors.PkgSourceFetchAuthError{"", nil, nil}), false},
+			{fetcherrors.PkgPartsError{"", errors.Join(fmt.Errorf("Part a: %w", fetcherrors.PkgSourceFetchAuthError{"", nil, nil}), fmt.Errorf("Part b: %w", fetcherrors.PkgSourceStalledError{"", nil}))}, true},
+			{fetcherrors.PkgPartsError{"", errors.Join(fmt.Errorf("Part a: %w", fetcherrors.PkgSourceFetchError{"", statusError{http.StatusNotFound, errors.New(""), ""}, nil}))}, false},
+			{fmt.Errorf("fetching: %w", fetcherrors.PkgPartsError{"", fetcherrors.PkgSourceInterruptedError{"", nil}}), true},
+		} {
+			assert.Equal(t, c.transient, IsTransient(c.err), "error %v", c.err)
+		}
+	})
+}
+
+func Test_StatusClasses_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-status-classes-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	// each path fails with its status once, then serves the part
+	var lock sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		first := requests[r.URL.Path] == 1
+		lock.Unlock()
+
+		if first {
+			var status int
+			fmt.Sscanf(r.URL.Path, "/%d", &status)
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	fetch := func(status int, classes map[int]StatusClass) error {
+		session := newFetchSession(Options{StatusClasses: classes, Retry: RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}})
+		name := fmt.Sprintf("%v-%v", status, len(classes))
+		sources := []horizonpkg.PartSource{{URL: fmt.Sprintf("/%v/%v", status, name)}}
+		return fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, name, path.Join(tmpDir, name), 7, "", sources, session)
+	}
+
+	suite.Run("statuses keep their default classes", func(t *testing.T) {
+		assert.Equal(t, AUTH, newFetchSession(Options{}).statusClass(http.StatusForbidden))
+		assert.Equal(t, TRANSIENT, newFetchSession(Options{}).statusClass(http.StatusServiceUnavailable))
+		assert.Equal(t, PERMANENT, newFetchSession(Options{}).statusClass(http.StatusTeapot))
+
+		assert.Nil(t, fetch(http.StatusServiceUnavailable, nil))
+		assert.NotNil(t, fetch(http.StatusTeapot, nil))
+	})
+
+	suite.Run("overridden statuses are classified by their overrides", func(t *testing.T) {
+		classes := map[int]StatusClass{
+			http.StatusTeapot:                     TRANSIENT,
+			http.StatusUnavailableForLegalReasons: AUTH,
+			http.StatusServiceUnavailable:         PERMANENT,
+		}
+
+		assert.Nil(t, fetch(http.StatusTeapot, classes))
+
+		err := fetch(http.StatusUnavailableForLegalReasons, classes)
+		assert.IsType(t, fetcherrors.PkgSourceFetchAuthError{}, err)
+
+		err = fetch(http.StatusServiceUnavailable, classes)
+		assert.IsType(t, fetcherrors.PkgSourceFetchError{}, err)
+		assert.False(t, IsTransient(err))
+	})
+}
@@ -0,0 +1,165 @@
+package fetch
+
+import (
+	"errors"
+	"net/http"
+)
+
+// configureClient applies the session's client-level options and the TLS
+// client certificates in authCreds to a client produced by the caller's
+// factory; the session's OAuth2 tokens are requested with it. The given client
+// is not modified.
+func (s *fetchSession) configureClient(client *http.Client, authCreds map[string]map[string]string) (*http.Client, error) {
+	configured, err := withClientCertificates(withConnectionPool(withUnixSockets(withResolver(withProxy(client, s), s), s), s), authCreds, s)
+	if err != nil {
+		return nil, err
+	}
+	s.tokens.client = configured
+
+	return withTokenRefresh(withFileScheme(withRedirectCredentials(withHostPolicy(configured, s), authCreds, s)), authCreds, s), nil
+}
+
+// withConnectionPool returns client with its own transport that keeps up to
+// the session's MaxIdleConnsPerHost idle connections so that they're reused by
+// part fetches to the same host. Only *http.Transport transports (including
+// the default transport used if client.Transport is nil) can be configured.
+func withConnectionPool(client *http.Client, session *fetchSession) *http.Client {
+	roundTripper := client.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		return client
+	}
+
+	pooled := transport.Clone()
+	pooled.MaxIdleConnsPerHost = session.opts.MaxIdleConnsPerHost
+	if pooled.MaxIdleConnsPerHost == 0 {
+		pooled.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	session.trackTransport(pooled)
+
+	configured := *client
+	configured.Transport = pooled
+	return &configured
+}
+
+// trackTransport records a transport created for the session's client
+func (s *fetchSession) trackTransport(transport *http.Transport) {
+	s.transportsLock.Lock()
+	defer s.transportsLock.Unlock()
+
+	s.transports = append(s.transports, transport)
+}
+
+// closeIdleConnections closes the idle connections of the transports created
+// for the session's client, which aren't reused by other sessions
+func (s *fetchSession) closeIdleConnections() {
+	s.transportsLock.Lock()
+	defer s.transportsLock.Unlock()
+
+	for _, transport := range s.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// withoutTimeout returns client with no overall request timeout; requests
+// made with it are expected to carry a context deadline instead
+func withoutTimeout(client *http.Client) *http.Client {
+	configured := *client
+	configured.Timeout = 0
+	return &configured
+}
+
+// fileSchemeTransport serves requests for file:// URLs from the local
+// filesystem and passes all others to the wrapped transport. This allows part
+// sources staged on local or mounted storage to be mixed with HTTP sources.
+// Only requests made for file:// URLs are served, never redirects to them, so
+// that a remote source can't have local files read.
+type fileSchemeTransport struct {
+	file http.RoundTripper
+	next http.RoundTripper
+}
+
+func (t *fileSchemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "file" {
+		if req.Response != nil {
+			return nil, errFileRedirect
+		}
+		return t.file.RoundTrip(req)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// errFileRedirect is the error of a redirect to a file:// URL
+var errFileRedirect = errors.New("Refused redirect to a file:// URL")
+
+// withFileScheme returns client with a transport that can also serve file://
+// URLs, which are never redirected to
+func withFileScheme(client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	checkRedirect := client.CheckRedirect
+
+	configured := *client
+	configured.Transport = &fileSchemeTransport{
+		file: http.NewFileTransport(http.Dir("/")),
+		next: next,
+	}
+	configured.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme == "file" {
+			return errFileRedirect
+		}
+
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		} else if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &configured
+}
+
+// withProxy returns client with its transport's proxy set to the session's
+// ProxyURL or, if none is configured and the transport has no proxy, to the
+// proxy named by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables. Only *http.Transport transports (including the default transport
+// used if client.Transport is nil) can be configured.
+func withProxy(client *http.Client, session *fetchSession) *http.Client {
+	roundTripper := client.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		if session.opts.ProxyURL != nil {
+			session.log.Errorf("Unable to configure proxy %v on HTTP client transport of type %T, requests will not use it", session.opts.ProxyURL, roundTripper)
+		}
+		return client
+	}
+
+	if session.opts.ProxyURL == nil && transport.Proxy != nil {
+		return client
+	}
+
+	proxied := transport.Clone()
+	if session.opts.ProxyURL != nil {
+		session.log.Infof(4, "Using configured proxy %v", session.opts.ProxyURL)
+		proxied.Proxy = http.ProxyURL(session.opts.ProxyURL)
+	} else {
+		proxied.Proxy = http.ProxyFromEnvironment
+	}
+
+	configured := *client
+	configured.Transport = proxied
+	return &configured
+}
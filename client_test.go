@@ -0,0 +1,102 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ConfigureClient_Suite(suite *testing.T) {
+	suite.Run("requests are sent through configured proxy", func(t *testing.T) {
+		var proxied []string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = append(proxied, r.URL.String())
+		}))
+		defer proxy.Close()
+
+		proxyURL, err := url.Parse(proxy.URL)
+		assert.Nil(t, err)
+
+		session := newFetchSession(Options{ProxyURL: proxyURL})
+		client, err := session.configureClient(&http.Client{}, nil)
+		assert.Nil(t, err)
+
+		req, err := authenticatedRequest(context.Background(), "http://pkgs.example.com/pkg/part.tgz", nil, session)
+		assert.Nil(t, err)
+
+		response, err := client.Do(req)
+		assert.Nil(t, err)
+		response.Body.Close()
+
+		assert.Equal(t, []string{"http://pkgs.example.com/pkg/part.tgz"}, proxied)
+	})
+
+	suite.Run("environment proxy is used by default", func(t *testing.T) {
+		client := withProxy(&http.Client{Transport: &http.Transport{}}, newFetchSession(Options{}))
+
+		transport, ok := client.Transport.(*http.Transport)
+		assert.True(t, ok)
+		assert.NotNil(t, transport.Proxy)
+	})
+}
+
+func Test_FetcherClose_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-close-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	var requests, closed int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	pkgURL, err := url.Parse(server.URL + "/pkg.json")
+	assert.Nil(suite, err)
+
+	fetcher := NewFetcher(WithOptions(Options{InsecureSkipSignatureVerification: true}))
+
+	suite.Run("idle connections of a fetch are closed once it's done", func(t *testing.T) {
+		_, err := fetcher.Fetch(context.Background(), *pkgURL, "", tmpDir)
+		assert.NotNil(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&closed) == 1 }, 5*time.Second, 10*time.Millisecond)
+		assert.Empty(t, fetcher.sessions)
+	})
+
+	suite.Run("closed fetcher makes no requests", func(t *testing.T) {
+		assert.Nil(t, fetcher.Close())
+
+		_, err := fetcher.Fetch(context.Background(), *pkgURL, "", tmpDir)
+		if assert.IsType(t, fetcherrors.PkgSourceError{}, err) {
+			assert.Equal(t, errFetcherClosed, err.(fetcherrors.PkgSourceError).InternalError)
+		}
+
+		responses := fetcher.FetchMany(context.Background(), []PkgRequest{{PkgURL: *pkgURL, DestinationDir: tmpDir}})
+		assert.NotNil(t, responses[0].Err)
+
+		_, err = fetcher.FetchStream(context.Background(), *pkgURL, "", tmpDir).Result()
+		assert.NotNil(t, err)
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+}
@@ -0,0 +1,161 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"net/http"
+	"os"
+)
+
+// fetchManifestSuffix is the suffix of the manifest kept alongside a part
+// file fetched with Options.ConditionalRequests
+const fetchManifestSuffix = ".fetch"
+
+// fetchManifest records the source a part file was fetched from and the
+// validators of its response so the part can be revalidated with a
+// conditional request
+type fetchManifest struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// header returns the conditional request headers of the manifest's validators
+func (m fetchManifest) header() http.Header {
+	header := http.Header{}
+	if m.ETag != "" {
+		header.Set("If-None-Match", m.ETag)
+	}
+	if m.LastModified != "" {
+		header.Set("If-Modified-Since", m.LastModified)
+	}
+	return header
+}
+
+// conditionalRequests reports whether the session revalidates part files with
+// conditional requests; parts in a Destination never are
+func (s *fetchSession) conditionalRequests() bool {
+	return s.opts.ConditionalRequests && s.opts.Destination == nil
+}
+
+// readFetchManifest reads the manifest of the part file at partPath
+func readFetchManifest(partPath string, session *fetchSession) (*fetchManifest, error) {
+	content, err := readAll(session.fs, partPath+fetchManifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest fetchManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// writeFetchManifest records the validators of the response from pURL with
+// which the part file at partPath was fetched, removing any earlier manifest
+// if the response has none
+func writeFetchManifest(partPath string, pURL string, response *http.Response, session *fetchSession) error {
+	if !session.conditionalRequests() {
+		return nil
+	}
+
+	manifest := fetchManifest{
+		URL:          pURL,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}
+
+	if manifest.ETag == "" && manifest.LastModified == "" {
+		if err := session.fs.Remove(partPath + fetchManifestSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return session.fs.WriteFile(partPath+fetchManifestSuffix, content, session.fileMode())
+}
+
+// revalidation is the outcome of revalidating a part file with its source.
+// If the file wasn't reused, response, if not nil, is the source's response
+// with the part's new content.
+type revalidation struct {
+	reused   bool
+	source   horizonpkg.PartSource
+	pURL     string
+	response *http.Response
+}
+
+// revalidatePart sends a conditional request with the validators in the
+// manifest of the existing part file at partPath to the source it was
+// fetched from. The file is reused if the source responds 304 Not Modified
+// and its content matches the Pkg meta. nil is returned if the part can't be
+// revalidated, e.g. it has no manifest or its source doesn't respond, in
+// which case the file is reused as it would be without conditional requests.
+// The caller must close the body of a returned response.
+func revalidatePart(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, pkgURLBase string, partID string, partPath string, sources []horizonpkg.PartSource, session *fetchSession) *revalidation {
+	if !session.conditionalRequests() {
+		return nil
+	}
+
+	// the part's hashes are needed to check the file
+	part, known := session.parts[partID]
+	if !known {
+		return nil
+	}
+
+	manifest, err := readFetchManifest(partPath, session)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			session.log.Errorf("Unable to read fetch manifest of part %v, not revalidating it. Error: %v", partPath, err)
+		}
+		return nil
+	}
+
+	for _, source := range sources {
+		pURL := partSourceURL(pkgURLBase, source, session)
+		if pURL != manifest.URL {
+			continue
+		}
+
+		response, err := requestWithRetries(ctx, client, authCreds, partID, pURL, 0, manifest.header(), session)
+		if err != nil {
+			session.log.Errorf("Failed to revalidate part %v with source %v, reusing it. Error: %v", partPath, pURL, err)
+			return nil
+		}
+
+		switch response.StatusCode {
+		case http.StatusNotModified:
+			response.Body.Close()
+
+			hashers, err := hashPart(ctx, partPath, part.Digests, session)
+			if err == nil && digestMatches(part.Sha256sum, part.Digests, hashers) {
+				session.log.Infof(3, "Source %v reports part %v is unchanged, reusing it", pURL, partPath)
+				session.recordHashes(partPath, hashers)
+				return &revalidation{reused: true}
+			}
+
+			session.log.Errorf("Source %v reports part %v is unchanged but it doesn't match the Pkg meta, fetching it again", pURL, partPath)
+			return &revalidation{}
+
+		case http.StatusOK:
+			session.log.Infof(3, "Source %v of part %v has changed, fetching it again", pURL, partPath)
+			return &revalidation{source: source, pURL: pURL, response: response}
+
+		default:
+			response.Body.Close()
+			session.log.Errorf("Source %v responded to revalidation of part %v with status %v, reusing it", pURL, partPath, response.StatusCode)
+			return nil
+		}
+	}
+
+	session.log.Infof(3, "Part %v was fetched from %v, which is no longer one of its sources, not revalidating it", partPath, manifest.URL)
+	return nil
+}
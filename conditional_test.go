@@ -0,0 +1,144 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func Test_ConditionalRequests_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-conditional-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	sources := []horizonpkg.PartSource{{URL: "/part"}}
+
+	// serves content with an ETag, honoring If-None-Match
+	var lock sync.Mutex
+	var content []byte
+	var etag string
+	var statuses []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			statuses = append(statuses, http.StatusNotModified)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		statuses = append(statuses, http.StatusOK)
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	serve := func(newContent []byte, newETag string) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		content = newContent
+		etag = newETag
+		statuses = nil
+	}
+
+	served := func() []int {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return statuses
+	}
+
+	sessionFor := func(content []byte) *fetchSession {
+		session := newFetchSession(Options{ConditionalRequests: true})
+		session.parts = horizonpkg.DockerImageParts{
+			"part": {ID: "part", Sha256sum: fmt.Sprintf("%x", sha256.Sum256(content)), Bytes: int64(len(content))},
+		}
+		return session
+	}
+
+	fetch := func(partPath string, content []byte, session *fetchSession) error {
+		return fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", partPath, int64(len(content)), "", sources, session)
+	}
+
+	v1 := bytes.Repeat([]byte("1"), 100)
+	v2 := bytes.Repeat([]byte("2"), 100)
+
+	suite.Run("validators are stored in the fetch manifest", func(t *testing.T) {
+		serve(v1, `"v1"`)
+		partPath := path.Join(tmpDir, "stored")
+
+		assert.Nil(t, fetch(partPath, v1, sessionFor(v1)))
+
+		manifest, err := readFetchManifest(partPath, sessionFor(v1))
+		assert.Nil(t, err)
+		assert.Equal(t, fetchManifest{URL: server.URL + "/part", ETag: `"v1"`}, *manifest)
+	})
+
+	suite.Run("unchanged part is revalidated and reused", func(t *testing.T) {
+		serve(v1, `"v1"`)
+		partPath := path.Join(tmpDir, "unchanged")
+		assert.Nil(t, fetch(partPath, v1, sessionFor(v1)))
+
+		session := sessionFor(v1)
+		assert.Nil(t, fetch(partPath, v1, session))
+		assert.Equal(t, []int{http.StatusOK, http.StatusNotModified}, served())
+
+		// the hash checked during revalidation is used to verify the part
+		assert.NotNil(t, session.takeHashes(partPath)[horizonpkg.SHA256])
+	})
+
+	suite.Run("changed part is fetched with the revalidation response", func(t *testing.T) {
+		serve(v1, `"v1"`)
+		partPath := path.Join(tmpDir, "changed")
+		assert.Nil(t, fetch(partPath, v1, sessionFor(v1)))
+
+		serve(v2, `"v2"`)
+		assert.Nil(t, fetch(partPath, v2, sessionFor(v2)))
+		assert.Equal(t, []int{http.StatusOK}, served())
+
+		written, err := ioutil.ReadFile(partPath)
+		assert.Nil(t, err)
+		assert.Equal(t, v2, written)
+
+		manifest, err := readFetchManifest(partPath, sessionFor(v2))
+		assert.Nil(t, err)
+		assert.Equal(t, `"v2"`, manifest.ETag)
+	})
+
+	suite.Run("unchanged part not matching the meta is fetched again", func(t *testing.T) {
+		serve(v1, `"v1"`)
+		partPath := path.Join(tmpDir, "corrupt")
+		assert.Nil(t, fetch(partPath, v1, sessionFor(v1)))
+		assert.Nil(t, ioutil.WriteFile(partPath, v2, 0600))
+
+		assert.Nil(t, fetch(partPath, v1, sessionFor(v1)))
+		assert.Equal(t, []int{http.StatusOK, http.StatusNotModified, http.StatusOK}, served())
+
+		written, err := ioutil.ReadFile(partPath)
+		assert.Nil(t, err)
+		assert.Equal(t, v1, written)
+	})
+
+	suite.Run("part without a manifest is reused for its size", func(t *testing.T) {
+		serve(v1, `"v1"`)
+		partPath := path.Join(tmpDir, "unrecorded")
+		assert.Nil(t, ioutil.WriteFile(partPath, v1, 0600))
+
+		assert.Nil(t, fetch(partPath, v1, sessionFor(v1)))
+		assert.Empty(t, served())
+	})
+}
@@ -0,0 +1,63 @@
+package fetch
+
+import (
+	"sync"
+)
+
+// sharedContent is a part download that parts with the same content, possibly
+// in other Pkgs fetched concurrently, may reuse
+type sharedContent struct {
+	done     chan struct{}
+	partPath string
+	err      error
+}
+
+// contentRegistry tracks part downloads by sha256sum so that each distinct
+// content is downloaded only once by the fetches sharing the registry
+type contentRegistry struct {
+	lock    sync.Mutex
+	entries map[string]*sharedContent
+}
+
+func newContentRegistry() *contentRegistry {
+	return &contentRegistry{
+		entries: make(map[string]*sharedContent),
+	}
+}
+
+// fetch runs download to fetch the content with the given sha256sum into
+// partPath unless another fetch has downloaded or is downloading the same
+// content, in which case its file is linked to partPath once complete. If the
+// other download fails or its file can't be linked, as parts in a Destination
+// can't be, download is run.
+func (r *contentRegistry) fetch(sha256sum string, partPath string, bytes int64, download func() error, session *fetchSession) error {
+	if sha256sum == "" {
+		return download()
+	}
+
+	r.lock.Lock()
+	entry, exists := r.entries[sha256sum]
+	if !exists {
+		entry = &sharedContent{done: make(chan struct{}), partPath: partPath}
+		r.entries[sha256sum] = entry
+	}
+	r.lock.Unlock()
+
+	if !exists {
+		entry.err = download()
+		close(entry.done)
+		return entry.err
+	}
+
+	// parts in a Destination can't be linked
+	<-entry.done
+	if entry.err == nil && entry.partPath != partPath && session.opts.Destination == nil {
+		if err := linkOrCopy(session.fs, entry.partPath, partPath, session.fileMode()); err == nil {
+			session.log.Infof(3, "Reused content of %v downloaded by another fetch for %v", entry.partPath, partPath)
+			session.addReused(bytes)
+			return nil
+		}
+	}
+
+	return download()
+}
@@ -0,0 +1,80 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ContentRegistry_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-content-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	session := newFetchSession(Options{})
+
+	suite.Run("concurrent fetches of the same content download it once", func(t *testing.T) {
+		registry := newContentRegistry()
+		var downloads int32
+
+		var group sync.WaitGroup
+		for _, name := range []string{"a", "b", "c"} {
+			group.Add(1)
+			go func(partPath string) {
+				defer group.Done()
+
+				err := registry.fetch("sum", partPath, 7, func() error {
+					atomic.AddInt32(&downloads, 1)
+					time.Sleep(50 * time.Millisecond)
+					return ioutil.WriteFile(partPath, []byte("content"), 0600)
+				}, session)
+				assert.Nil(t, err)
+
+				content, err := ioutil.ReadFile(partPath)
+				assert.Nil(t, err)
+				assert.Equal(t, "content", string(content))
+			}(path.Join(tmpDir, name))
+		}
+		group.Wait()
+
+		assert.EqualValues(t, 1, atomic.LoadInt32(&downloads))
+	})
+
+	suite.Run("failed download is retried by waiting fetches", func(t *testing.T) {
+		registry := newContentRegistry()
+
+		err := registry.fetch("sum", path.Join(tmpDir, "failed"), 7, func() error {
+			return errors.New("failed")
+		}, session)
+		assert.NotNil(t, err)
+
+		downloaded := false
+		err = registry.fetch("sum", path.Join(tmpDir, "retried"), 7, func() error {
+			downloaded = true
+			return nil
+		}, session)
+		assert.Nil(t, err)
+		assert.True(t, downloaded)
+	})
+
+	suite.Run("part downloads are limited by MaxConcurrentParts", func(t *testing.T) {
+		limited := newFetchSession(Options{MaxConcurrentParts: 1})
+		assert.Nil(t, limited.acquirePart(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.NotNil(t, limited.forPkg().acquirePart(ctx))
+
+		limited.releasePart()
+		assert.Nil(t, limited.forPkg().acquirePart(context.Background()))
+	})
+}
@@ -0,0 +1,22 @@
+package fetch
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader fails reads from the wrapped reader once its context is done
+// so that copies from sources that don't observe the context themselves, e.g.
+// local files, are aborted when a fetch is canceled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.reader.Read(p)
+}
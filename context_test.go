@@ -0,0 +1,83 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func Test_Context_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-context-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	suite.Run("copy stops once the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		reader := &contextReader{ctx, bytes.NewReader(make([]byte, 100))}
+
+		read, err := io.CopyN(ioutil.Discard, reader, 10)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 10, read)
+
+		cancel()
+		read, err = io.Copy(ioutil.Discard, reader)
+		assert.Equal(t, context.Canceled, err)
+		assert.EqualValues(t, 0, read)
+	})
+
+	suite.Run("requests are made with the context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		req, err := authenticatedRequest(ctx, "http://pkgs.example.com/pkg/part.tgz", nil, newFetchSession(Options{}))
+		assert.Nil(t, err)
+		assert.Equal(t, ctx, req.Context())
+	})
+
+	suite.Run("meta request is aborted when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// responds only once the client gives up
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cancel()
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		pkgURL, err := url.Parse(server.URL + "/pkg.json")
+		assert.Nil(t, err)
+
+		factory := func(overrideTimeoutS *uint) *http.Client { return &http.Client{} }
+		_, _, err = ParseAndVerifyMetaContext(ctx, factory, *pkgURL, "", tmpDir, false, "", "", nil, Options{InsecureSkipSignatureVerification: true})
+		assert.True(t, errors.Is(err, context.Canceled), err)
+	})
+
+	suite.Run("fetch with a canceled context requests nothing", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		requested := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+		}))
+		defer server.Close()
+
+		pkgURL, err := url.Parse(server.URL + "/pkg.json")
+		assert.Nil(t, err)
+
+		factory := func(overrideTimeoutS *uint) *http.Client { return &http.Client{} }
+		_, err = PkgFetchWithOptionsContext(ctx, factory, *pkgURL, "", tmpDir, "", "", nil, Options{InsecureSkipSignatureVerification: true})
+		assert.NotNil(t, err)
+		assert.False(t, requested)
+	})
+}
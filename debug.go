@@ -0,0 +1,204 @@
+package fetch
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// outcomes of a part's fetch recorded in a debug dump
+const (
+	dumpFetched = "FETCHED"
+	dumpSkipped = "SKIPPED"
+	dumpLinked  = "LINKED"
+	dumpFailed  = "FAILED"
+)
+
+// redacted replaces credentials in debug dumps
+const redacted = "REDACTED"
+
+// urlUserinfo matches the userinfo of URLs in text
+var urlUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#@\s"]+@`)
+
+// redactURLs replaces the userinfo of all URLs in text
+func redactURLs(text string) string {
+	return urlUserinfo.ReplaceAllString(text, "${1}"+redacted+"@")
+}
+
+// partDump describes a part and the outcome of its fetch in a debug dump
+type partDump struct {
+	ID         string   `json:"id"`
+	Sources    []string `json:"sources"`
+	Bytes      int64    `json:"bytes"`
+	Sha256sum  string   `json:"sha256sum"`
+	Outcome    string   `json:"outcome,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	ActualHash string   `json:"actual_hash,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// debugDump records the content of a Pkg fetch for debugging. All of its
+// methods may be called on a nil dump, in which case they do nothing.
+type debugDump struct {
+	lock sync.Mutex
+
+	PkgURL    string                       `json:"pkg_url"`
+	AuthCreds map[string]map[string]string `json:"auth_creds,omitempty"`
+	Meta      string                       `json:"meta,omitempty"`
+	Parts     map[string]*partDump         `json:"parts,omitempty"`
+	Error     string                       `json:"error,omitempty"`
+
+	// names of the parts written to each file
+	byFile map[string][]string
+}
+
+// newDebugDump returns a dump of the fetch of the Pkg at pkgURL with the
+// given credentials, which are redacted
+func newDebugDump(pkgURL string, authCreds map[string]map[string]string) *debugDump {
+	dump := &debugDump{
+		PkgURL:    redactURLs(pkgURL),
+		AuthCreds: make(map[string]map[string]string),
+		Parts:     make(map[string]*partDump),
+		byFile:    make(map[string][]string),
+	}
+
+	for prefix, creds := range authCreds {
+		redactedCreds := make(map[string]string)
+		for key, value := range creds {
+			if key != "username" {
+				value = redacted
+			}
+			redactedCreds[key] = value
+		}
+		dump.AuthCreds[redactURLs(prefix)] = redactedCreds
+	}
+
+	return dump
+}
+
+// setMeta records the raw Pkg meta
+func (d *debugDump) setMeta(rawBody []byte) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.Meta = redactURLs(string(rawBody))
+}
+
+// setParts records the parts to be fetched and the names of the files, by
+// part name, they're written to
+func (d *debugDump) setParts(parts horizonpkg.DockerImageParts, files map[string]string) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for name, part := range parts {
+		var sources []string
+		for _, source := range part.Sources {
+			sources = append(sources, redactURLs(source.URL))
+		}
+
+		d.Parts[name] = &partDump{
+			ID:        part.ID,
+			Sources:   sources,
+			Bytes:     part.Bytes,
+			Sha256sum: part.Sha256sum,
+		}
+
+		file, ok := files[name]
+		if !ok {
+			file = name
+		}
+		d.byFile[file] = append(d.byFile[file], name)
+	}
+}
+
+// updatePart applies update to the record of the named part, if there is one
+func (d *debugDump) updatePart(name string, update func(part *partDump)) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if part, ok := d.Parts[name]; ok {
+		update(part)
+	}
+}
+
+// recordOutcome records the outcome of the named part's fetch
+func (d *debugDump) recordOutcome(name string, outcome string, source string) {
+	d.updatePart(name, func(part *partDump) {
+		part.Outcome = outcome
+		if source != "" {
+			part.Source = redactURLs(source)
+		}
+	})
+}
+
+// recordHash records the actual hash of the parts written to partPath
+func (d *debugDump) recordHash(partPath string, actualHash string) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, name := range d.byFile[filepath.Base(partPath)] {
+		if part, ok := d.Parts[name]; ok {
+			part.ActualHash = actualHash
+		}
+	}
+}
+
+// recordFailure records the error failing the named part
+func (d *debugDump) recordFailure(name string, err error) {
+	d.updatePart(name, func(part *partDump) {
+		part.Outcome = dumpFailed
+		part.Error = redactURLs(err.Error())
+	})
+}
+
+// write writes the dump, including the fetch's error if it failed, to the
+// session's DebugDumpWriter or, if it has none, to its Logger
+func (d *debugDump) write(err error, session *fetchSession) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err != nil {
+		d.Error = redactURLs(err.Error())
+	}
+
+	content, mErr := json.MarshalIndent(d, "", "  ")
+	if mErr != nil {
+		session.log.Errorf("Failed to marshal debug dump of Pkg fetch from %v. Error: %v", d.PkgURL, mErr)
+		return
+	}
+
+	if session.opts.DebugDumpWriter == nil {
+		session.log.Infof(9, "Debug dump of Pkg fetch: %s", content)
+		return
+	}
+
+	// dumps of Pkgs fetched together share the writer
+	session.dumpLock.Lock()
+	defer session.dumpLock.Unlock()
+
+	if _, wErr := fmt.Fprintf(session.opts.DebugDumpWriter, "%s\n", content); wErr != nil {
+		session.log.Errorf("Failed to write debug dump of Pkg fetch from %v. Error: %v", d.PkgURL, wErr)
+	}
+}
@@ -0,0 +1,95 @@
+package fetch
+
+import (
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"io"
+	"os"
+	"sort"
+)
+
+// groupPartsBySha256 groups the names of parts with identical content so that
+// each group need be downloaded only once. Names in each group are sorted; the
+// first is the one to download.
+func groupPartsBySha256(parts horizonpkg.DockerImageParts) [][]string {
+	names := make([]string, 0, len(parts))
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var groups [][]string
+	groupIndex := make(map[string]int)
+
+	for _, name := range names {
+		sum := parts[name].Sha256sum
+
+		// parts without a hash can't be proven identical
+		if ix, exists := groupIndex[sum]; exists && sum != "" {
+			groups[ix] = append(groups[ix], name)
+		} else {
+			groupIndex[sum] = len(groups)
+			groups = append(groups, []string{name})
+		}
+	}
+
+	return groups
+}
+
+// partGroups groups the names of parts that are downloaded together: those
+// with identical content, or, since parts in a Destination can't be linked,
+// those written to the same file of a Destination
+func (s *fetchSession) partGroups(parts horizonpkg.DockerImageParts) [][]string {
+	groups := groupPartsBySha256(parts)
+	if s.opts.Destination == nil {
+		return groups
+	}
+
+	var byFile [][]string
+	for _, names := range groups {
+		index := make(map[string]int)
+
+		for _, name := range names {
+			file := s.partPath("", name)
+			if ix, exists := index[file]; exists {
+				byFile[ix] = append(byFile[ix], name)
+			} else {
+				index[file] = len(byFile)
+				byFile = append(byFile, []string{name})
+			}
+		}
+	}
+
+	return byFile
+}
+
+// linkOrCopy hardlinks src to dst in fs, copying src instead if it can't be
+// linked (for instance when dst is on another filesystem). An existing dst is
+// replaced.
+func linkOrCopy(fs FileSystem, src string, dst string, perm os.FileMode) error {
+	if err := fs.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := fs.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		fs.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,43 @@
+// +build unit
+
+package fetch
+
+import (
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_Dedup_Suite(suite *testing.T) {
+	suite.Run("parts are grouped by sha256sum", func(t *testing.T) {
+		groups := groupPartsBySha256(horizonpkg.DockerImageParts{
+			"c": {Sha256sum: "aa"},
+			"a": {Sha256sum: "aa"},
+			"b": {Sha256sum: "bb"},
+			"d": {},
+			"e": {},
+		})
+
+		assert.Equal(t, [][]string{{"a", "c"}, {"b"}, {"d"}, {"e"}}, groups)
+	})
+
+	suite.Run("linkOrCopy replaces destination with source content", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "fetch-test-dedup-")
+		assert.Nil(t, err)
+		defer os.RemoveAll(tmpDir)
+
+		src := path.Join(tmpDir, "src")
+		dst := path.Join(tmpDir, "dst")
+		assert.Nil(t, ioutil.WriteFile(src, []byte("content"), 0600))
+		assert.Nil(t, ioutil.WriteFile(dst, []byte("stale"), 0600))
+
+		assert.Nil(t, linkOrCopy(OSFileSystem{}, src, dst, 0600))
+
+		content, err := ioutil.ReadFile(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, "content", string(content))
+	})
+}
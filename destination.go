@@ -0,0 +1,75 @@
+package fetch
+
+import (
+	"fmt"
+	"io"
+)
+
+// Destination is a target into which parts are written in place of the
+// FileSystem, for instance an object store or a content addressed store.
+// Parts are named by the paths they would have in the FileSystem. Each part
+// is written once from start to finish; its hashes are computed as it's
+// written so it needn't be read back to be verified. A part that fails
+// verification is removed.
+type Destination interface {
+	// Create returns a writer of the named part, replacing any existing
+	// part of that name. The part is complete once the writer is closed
+	// without error.
+	Create(name string) (io.WriteCloser, error)
+
+	// Size returns the size of the named part, or an error satisfying
+	// os.IsNotExist if there is no such part
+	Size(name string) (int64, error)
+
+	// Remove removes the named part
+	Remove(name string) error
+}
+
+// DestinationReader is implemented by Destinations whose parts can be read
+// back. Parts already in such a Destination with their expected size are
+// verified by reading them rather than fetched again; parts already in other
+// Destinations are always fetched again.
+type DestinationReader interface {
+	Open(name string) (io.ReadCloser, error)
+}
+
+// partsReadable reports whether parts written by the session can be read
+// back to verify them
+func (s *fetchSession) partsReadable() bool {
+	if s.opts.Destination == nil {
+		return true
+	}
+
+	_, ok := s.opts.Destination.(DestinationReader)
+	return ok
+}
+
+// partSize returns the size of the part at partPath in the Destination, if
+// there is one, or the FileSystem
+func (s *fetchSession) partSize(partPath string) (int64, error) {
+	if s.opts.Destination != nil {
+		return s.opts.Destination.Size(partPath)
+	}
+
+	info, err := s.fs.Stat(partPath)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// openPart opens the part at partPath in the Destination, if there is one,
+// or the FileSystem for reading
+func (s *fetchSession) openPart(partPath string) (io.ReadCloser, error) {
+	if s.opts.Destination == nil {
+		return s.fs.Open(partPath)
+	}
+
+	reader, ok := s.opts.Destination.(DestinationReader)
+	if !ok {
+		return nil, fmt.Errorf("Part %v can't be read back from the Destination", partPath)
+	}
+
+	return reader.Open(partPath)
+}
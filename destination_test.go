@@ -0,0 +1,170 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// memoryDestination is a Destination whose parts can't be read back
+type memoryDestination struct {
+	lock    sync.Mutex
+	parts   map[string][]byte
+	removed []string
+}
+
+type memoryPart struct {
+	bytes.Buffer
+	dest *memoryDestination
+	name string
+}
+
+func (p *memoryPart) Close() error {
+	p.dest.lock.Lock()
+	defer p.dest.lock.Unlock()
+
+	p.dest.parts[p.name] = p.Bytes()
+	return nil
+}
+
+func (d *memoryDestination) Create(name string) (io.WriteCloser, error) {
+	return &memoryPart{dest: d, name: name}, nil
+}
+
+func (d *memoryDestination) Size(name string) (int64, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	content, exists := d.parts[name]
+	if !exists {
+		return 0, os.ErrNotExist
+	}
+	return int64(len(content)), nil
+}
+
+func (d *memoryDestination) Remove(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.parts, name)
+	d.removed = append(d.removed, name)
+	return nil
+}
+
+// readableDestination is a memoryDestination whose parts can be read back
+type readableDestination struct {
+	memoryDestination
+}
+
+func (d *readableDestination) Open(name string) (io.ReadCloser, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	content, exists := d.parts[name]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func Test_Destination_Suite(suite *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+	sources := []horizonpkg.PartSource{{URL: "/part"}}
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	suite.Run("part is written to the destination and verified without reading it back", func(t *testing.T) {
+		dest := &memoryDestination{parts: make(map[string][]byte)}
+		session := newFetchSession(Options{Destination: dest, InsecureSkipSignatureVerification: true})
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", "/bucket/pkg/part", int64(len(content)), "", sources, session)
+		assert.Nil(t, err)
+		assert.Equal(t, content, dest.parts["/bucket/pkg/part"])
+
+		assert.Nil(t, verifyPkgPart(context.Background(), "", "", "/bucket/pkg/part", sum, nil, nil, session))
+
+		// parts sharing the file are verified with the same hashes
+		assert.Nil(t, verifyPkgPart(context.Background(), "", "", "/bucket/pkg/part", sum, nil, nil, session))
+	})
+
+	suite.Run("digests of other algorithms are computed as the part is written", func(t *testing.T) {
+		dest := &memoryDestination{parts: make(map[string][]byte)}
+		session := newFetchSession(Options{Destination: dest, InsecureSkipSignatureVerification: true})
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", "/bucket/pkg/part", int64(len(content)), "", sources, session)
+		assert.Nil(t, err)
+
+		digests := []horizonpkg.Digest{{Algorithm: horizonpkg.SHA512, Value: fmt.Sprintf("%x", sha512.Sum512(content))}}
+		assert.Nil(t, verifyPkgPart(context.Background(), "", "", "/bucket/pkg/part", "", digests, nil, session))
+	})
+
+	suite.Run("part failing verification is removed from the destination", func(t *testing.T) {
+		dest := &memoryDestination{parts: make(map[string][]byte)}
+		session := newFetchSession(Options{Destination: dest, InsecureSkipSignatureVerification: true, KeepFailedArtifacts: true})
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", "/bucket/pkg/part", int64(len(content)), "", sources, session)
+		assert.Nil(t, err)
+
+		err = verifyPkgPart(context.Background(), "", "", "/bucket/pkg/part", fmt.Sprintf("%x", sha256.Sum256([]byte("other"))), nil, nil, session)
+		assert.NotNil(t, err)
+		assert.Equal(t, []string{"/bucket/pkg/part"}, dest.removed)
+
+		_, exists := dest.parts["/bucket/pkg/part"]
+		assert.False(t, exists)
+	})
+
+	suite.Run("existing part that can't be read back is fetched again", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		dest := &memoryDestination{parts: map[string][]byte{"/bucket/pkg/part": bytes.Repeat([]byte("x"), len(content))}}
+		session := newFetchSession(Options{Destination: dest})
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", "/bucket/pkg/part", int64(len(content)), "", sources, session)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+		assert.Equal(t, content, dest.parts["/bucket/pkg/part"])
+	})
+
+	suite.Run("existing part that can be read back is reused and verified by reading it", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		dest := &readableDestination{memoryDestination{parts: map[string][]byte{"/bucket/pkg/part": content}}}
+		session := newFetchSession(Options{Destination: dest, InsecureSkipSignatureVerification: true})
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", "/bucket/pkg/part", int64(len(content)), "", sources, session)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+		assert.Nil(t, verifyPkgPart(context.Background(), "", "", "/bucket/pkg/part", sum, nil, nil, session))
+	})
+
+	suite.Run("parts in a destination are grouped by file rather than content", func(t *testing.T) {
+		session := newFetchSession(Options{Destination: &memoryDestination{}})
+		parts := horizonpkg.DockerImageParts{
+			"a": {ID: "a", Sha256sum: sum},
+			"b": {ID: "b", Sha256sum: sum},
+			"c": {ID: "c", Sha256sum: sum},
+		}
+		session.partFiles = map[string]string{"a": sum, "b": sum, "c": "c"}
+
+		assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, session.partGroups(parts))
+	})
+}
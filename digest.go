@@ -0,0 +1,120 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"hash"
+	"io"
+)
+
+// newDigestHasher returns a hasher for the given digest algorithm or nil if
+// the algorithm isn't supported
+func newDigestHasher(algorithm horizonpkg.DigestAlgorithm) hash.Hash {
+	switch algorithm {
+	case horizonpkg.SHA256:
+		return sha256.New()
+	case horizonpkg.SHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// hashesCover reports whether hashers include a sha256 hasher and one for
+// each supported algorithm of digests
+func hashesCover(hashers map[horizonpkg.DigestAlgorithm]hash.Hash, digests []horizonpkg.Digest) bool {
+	if hashers[horizonpkg.SHA256] == nil {
+		return false
+	}
+
+	for _, digest := range digests {
+		if hashers[digest.Algorithm] == nil && newDigestHasher(digest.Algorithm) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// hashPart reads the part file's content into a sha256 hasher and a hasher
+// for each other supported algorithm of digests. Digests of unsupported
+// algorithms are ignored so that parts published with newer algorithms
+// alongside supported ones can still be verified.
+func hashPart(ctx context.Context, partPath string, digests []horizonpkg.Digest, session *fetchSession) (map[horizonpkg.DigestAlgorithm]hash.Hash, error) {
+	hashers := map[horizonpkg.DigestAlgorithm]hash.Hash{horizonpkg.SHA256: sha256.New()}
+	writers := []io.Writer{hashers[horizonpkg.SHA256]}
+
+	for _, digest := range digests {
+		if _, exists := hashers[digest.Algorithm]; exists {
+			continue
+		}
+
+		hasher := newDigestHasher(digest.Algorithm)
+		if hasher == nil {
+			session.log.Infof(3, "Ignoring digest of part %v with unsupported algorithm %v", partPath, digest.Algorithm)
+			continue
+		}
+
+		hashers[digest.Algorithm] = hasher
+		writers = append(writers, hasher)
+	}
+
+	partFile, err := session.openPart(partPath)
+	if err != nil {
+		return nil, err
+	}
+	defer partFile.Close()
+
+	// Read the file content into the hash functions.
+	if _, err := io.Copy(io.MultiWriter(writers...), &contextReader{ctx, partFile}); err != nil {
+		return nil, fmt.Errorf("Unable to copy image file content into hash function for part %v. Error: %v", partPath, err)
+	}
+
+	return hashers, nil
+}
+
+// digestMatches reports whether the content hashed by hashers matches
+// sha256sum or any of digests
+func digestMatches(sha256sum string, digests []horizonpkg.Digest, hashers map[horizonpkg.DigestAlgorithm]hash.Hash) bool {
+	if sha256sum != "" && digestEqual(sha256sum, hashers[horizonpkg.SHA256].Sum(nil)) {
+		return true
+	}
+
+	for _, digest := range digests {
+		if hasher, ok := hashers[digest.Algorithm]; ok && digest.Value != "" && digestEqual(digest.Value, hasher.Sum(nil)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// digestEqual reports whether the hex encoded expected digest is actual,
+// comparing them in constant time
+func digestEqual(expected string, actual []byte) bool {
+	decoded, err := hex.DecodeString(expected)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(decoded, actual) == 1
+}
+
+// contentDigest returns the hex sha256 of the JSON object mapping each part
+// name to the hex sha256 of its content, whose keys are sorted, so that Pkgs
+// fetched with identical parts have the same digest however and wherever
+// they were fetched
+func contentDigest(sums map[string]string) string {
+	// encoding/json sorts map keys
+	encoded, err := json.Marshal(sums)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(encoded))
+}
@@ -0,0 +1,99 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_Digest_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-digest-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := []byte("part content")
+	partPath := path.Join(tmpDir, "part")
+	assert.Nil(suite, ioutil.WriteFile(partPath, content, 0600))
+
+	sha256sum := fmt.Sprintf("%x", sha256.Sum256(content))
+	sha512sum := fmt.Sprintf("%x", sha512.Sum512(content))
+	stale := fmt.Sprintf("%x", sha256.Sum256([]byte("old content")))
+
+	session := newFetchSession(Options{})
+
+	suite.Run("content matching sha256sum is accepted", func(t *testing.T) {
+		hashers, err := hashPart(context.Background(), partPath, nil, session)
+		assert.Nil(t, err)
+		assert.True(t, digestMatches(sha256sum, nil, hashers))
+		assert.False(t, digestMatches(stale, nil, hashers))
+
+		// sums that aren't hex or are truncated never match
+		assert.False(t, digestMatches("not hex", nil, hashers))
+		assert.False(t, digestMatches(sha256sum[:32], nil, hashers))
+	})
+
+	suite.Run("content matching any digest is accepted", func(t *testing.T) {
+		digests := []horizonpkg.Digest{{horizonpkg.SHA512, sha512sum}}
+
+		hashers, err := hashPart(context.Background(), partPath, digests, session)
+		assert.Nil(t, err)
+		assert.True(t, digestMatches(stale, digests, hashers))
+		assert.True(t, digestMatches("", digests, hashers))
+	})
+
+	suite.Run("content matching no digest is rejected", func(t *testing.T) {
+		digests := []horizonpkg.Digest{{horizonpkg.SHA512, stale}, {horizonpkg.SHA256, stale}}
+
+		hashers, err := hashPart(context.Background(), partPath, digests, session)
+		assert.Nil(t, err)
+		assert.False(t, digestMatches("", digests, hashers))
+	})
+
+	suite.Run("digests of unsupported algorithms are ignored", func(t *testing.T) {
+		digests := []horizonpkg.Digest{{"blake3", sha256sum}, {horizonpkg.SHA256, sha256sum}}
+
+		hashers, err := hashPart(context.Background(), partPath, digests, session)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(hashers))
+		assert.True(t, digestMatches("", digests, hashers))
+		assert.False(t, digestMatches("", digests[:1], hashers))
+	})
+
+	suite.Run("part failing all digests is discarded by verification", func(t *testing.T) {
+		mismatched := path.Join(tmpDir, "mismatched")
+		assert.Nil(t, ioutil.WriteFile(mismatched, content, 0600))
+
+		err := verifyPkgPart(context.Background(), "", "", mismatched, stale, []horizonpkg.Digest{{horizonpkg.SHA512, stale}}, nil, session)
+		assert.Contains(t, err.Error(), "Mismatch between expected hash")
+
+		// both hashes are reported
+		assert.Contains(t, err.Error(), stale)
+		assert.Contains(t, err.Error(), sha256sum)
+
+		_, err = os.Stat(mismatched)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	suite.Run("content digest depends only on part names and sums", func(t *testing.T) {
+		sums := map[string]string{"a": sha256sum, "b": stale}
+
+		// maps built in different orders
+		reordered := make(map[string]string)
+		reordered["b"] = stale
+		reordered["a"] = sha256sum
+
+		assert.Len(t, contentDigest(sums), 64)
+		assert.Equal(t, contentDigest(sums), contentDigest(reordered))
+		assert.NotEqual(t, contentDigest(sums), contentDigest(map[string]string{"a": stale, "b": sha256sum}))
+		assert.NotEqual(t, contentDigest(sums), contentDigest(map[string]string{"a": sha256sum}))
+	})
+}
@@ -0,0 +1,47 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_Discard_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-discard-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	partPath := path.Join(tmpDir, "part")
+
+	suite.Run("failed part is deleted by default", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(partPath, []byte("corrupt"), 0600))
+
+		err := verifyPkgPart(context.Background(), "", "", partPath, "0000", nil, nil, newFetchSession(Options{}))
+		assert.IsType(t, fetcherrors.PkgSignatureVerificationError{}, err)
+
+		_, err = os.Stat(partPath)
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(partPath + ".corrupt")
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	suite.Run("failed part is quarantined if artifacts are kept", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(partPath, []byte("corrupt"), 0600))
+
+		err := verifyPkgPart(context.Background(), "", "", partPath, "0000", nil, nil, newFetchSession(Options{KeepFailedArtifacts: true}))
+		assert.IsType(t, fetcherrors.PkgSignatureVerificationError{}, err)
+
+		_, err = os.Stat(partPath)
+		assert.True(t, os.IsNotExist(err))
+
+		content, err := ioutil.ReadFile(partPath + ".corrupt")
+		assert.Nil(t, err)
+		assert.Equal(t, "corrupt", string(content))
+	})
+}
@@ -0,0 +1,122 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dnsCache resolves the hosts dialed by a fetch's client, returning the
+// addresses of hosts pinned by Options.ResolveHosts and caching others'
+// addresses for Options.DNSCacheTTL so that parts fetched while DNS is
+// briefly unavailable reuse them. Failed lookups aren't cached.
+type dnsCache struct {
+	lock    sync.Mutex
+	entries map[string]dnsEntry
+
+	// lookup resolves a host, net.DefaultResolver's LookupHost by default
+	lookup func(ctx context.Context, host string) ([]string, error)
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{
+		entries: make(map[string]dnsEntry),
+		lookup:  net.DefaultResolver.LookupHost,
+	}
+}
+
+// resolve returns the addresses of host
+func (c *dnsCache) resolve(ctx context.Context, host string, session *fetchSession) ([]string, error) {
+	if pinned, ok := session.opts.ResolveHosts[host]; ok {
+		return []string{pinned}, nil
+	}
+
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	ttl := session.opts.DNSCacheTTL
+
+	c.lock.Lock()
+	cached, ok := c.entries[host]
+	c.lock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.addrs, nil
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		c.lock.Lock()
+		c.entries[host] = dnsEntry{addrs, time.Now().Add(ttl)}
+		c.lock.Unlock()
+		session.log.Infof(5, "Cached addresses %v of host %v for %v", addrs, host, ttl)
+	}
+
+	return addrs, nil
+}
+
+// withResolver returns client with a transport that dials the addresses to
+// which the session's dnsCache resolves hosts, if the session pins hosts or
+// caches their addresses. Only *http.Transport transports (including the
+// default transport used if client.Transport is nil) can be configured.
+func withResolver(client *http.Client, session *fetchSession) *http.Client {
+	if session.opts.DNSCacheTTL <= 0 && len(session.opts.ResolveHosts) == 0 {
+		return client
+	}
+
+	roundTripper := client.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		session.log.Errorf("Unable to configure DNS caching on HTTP client transport of type %T, hosts will be resolved by it", roundTripper)
+		return client
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	resolved := transport.Clone()
+	resolved.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs, err := session.resolver.resolve(ctx, host, session)
+		if err != nil {
+			return nil, err
+		}
+
+		// each address is tried in turn as the default dialer would
+		lastErr := fmt.Errorf("No addresses for host %v", host)
+		for _, addr := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+
+	configured := *client
+	configured.Transport = resolved
+	return &configured
+}
@@ -0,0 +1,96 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_DNSCache_Suite(suite *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	assert.Nil(suite, err)
+	_, port, err := net.SplitHostPort(serverURL.Host)
+	assert.Nil(suite, err)
+
+	get := func(client *http.Client, host string) error {
+		response, err := client.Get("http://" + net.JoinHostPort(host, port) + "/part")
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		return nil
+	}
+
+	// lookups resolves hosts to the server until it fails
+	lookups := 0
+	failing := false
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if failing {
+			return nil, errors.New("no such host")
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+
+	suite.Run("pinned host is dialed without resolving it", func(t *testing.T) {
+		lookups = 0
+		session := newFetchSession(Options{ResolveHosts: map[string]string{"pkgs.example.com": "127.0.0.1"}})
+		session.resolver.lookup = lookup
+
+		client := withResolver(&http.Client{Transport: &http.Transport{}}, session)
+		assert.Nil(t, get(client, "pkgs.example.com"))
+		assert.Equal(t, 0, lookups)
+	})
+
+	suite.Run("resolved addresses are reused while DNS fails", func(t *testing.T) {
+		lookups = 0
+		failing = false
+		session := newFetchSession(Options{DNSCacheTTL: time.Minute})
+		session.resolver.lookup = lookup
+
+		// connections aren't kept so each request dials
+		client := withResolver(&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}, session)
+		assert.Nil(t, get(client, "pkgs.example.com"))
+
+		failing = true
+		assert.Nil(t, get(client, "pkgs.example.com"))
+		assert.Equal(t, 1, lookups)
+
+		// the cache is shared by the sessions of Pkgs fetched together
+		assert.Nil(t, get(withResolver(&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}, session.forPkg()), "pkgs.example.com"))
+		assert.Equal(t, 1, lookups)
+	})
+
+	suite.Run("expired addresses aren't used", func(t *testing.T) {
+		lookups = 0
+		failing = false
+		session := newFetchSession(Options{DNSCacheTTL: time.Millisecond})
+		session.resolver.lookup = lookup
+
+		client := withResolver(&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}, session)
+		assert.Nil(t, get(client, "pkgs.example.com"))
+
+		time.Sleep(5 * time.Millisecond)
+		failing = true
+		assert.NotNil(t, get(client, "pkgs.example.com"))
+		assert.Equal(t, 2, lookups)
+	})
+
+	suite.Run("client is unchanged unless enabled", func(t *testing.T) {
+		client := &http.Client{}
+		assert.Equal(t, client, withResolver(client, newFetchSession(Options{})))
+	})
+}
@@ -0,0 +1,161 @@
+// Package dockerload loads the image parts of fetched Pkgs into a Docker
+// daemon through its Engine API. It is separate from package fetch so users
+// that don't load images into Docker don't depend on it.
+package dockerload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultSocketPath is the path of the unix socket a Docker daemon listens on
+// by default
+const DefaultSocketPath = "/var/run/docker.sock"
+
+// Loader loads images into a Docker daemon. Create one with NewLoader or
+// NewLoaderWithClient.
+type Loader struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewLoader returns a Loader for the Docker daemon listening on the unix
+// socket at socketPath
+func NewLoader(socketPath string) *Loader {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	// the host is ignored, requests are sent on the socket
+	return NewLoaderWithClient(&http.Client{Transport: transport}, "http://docker")
+}
+
+// NewLoaderWithClient returns a Loader that makes requests of the Docker
+// Engine API at baseURL, e.g. http://host:2375, with client
+func NewLoaderWithClient(client *http.Client, baseURL string) *Loader {
+	return &Loader{
+		client:  client,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// ImageLoadResult is the outcome of loading one part's image into the daemon
+type ImageLoadResult struct {
+	// PartID is the name of the part in the Pkg
+	PartID string
+
+	// RepoTag is the image the Pkg meta says the part provides
+	RepoTag string
+
+	// PartPath is the path of the part file loaded
+	PartPath string
+
+	// Loaded are the images the daemon reported loading, e.g.
+	// "Loaded image: repo:tag"
+	Loaded []string
+
+	// Err is the error loading the part, nil if it was loaded
+	Err error
+}
+
+// LoadFetched loads the parts of a fetch's Pkg that were fetched and verified
+// into the daemon, one at a time in part name order, and returns the result
+// of each. The failure of one part's load doesn't stop the others; only an
+// unusable FetchResult is an error.
+func (l *Loader) LoadFetched(ctx context.Context, result *fetch.FetchResult) ([]ImageLoadResult, error) {
+	if result == nil || result.Pkg == nil || result.Pkg.Meta == nil {
+		return nil, errors.New("FetchResult has no Pkg")
+	}
+
+	var names []string
+	for name := range result.Parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var results []ImageLoadResult
+	for _, name := range names {
+		partPath := result.Parts[name]
+		loaded, err := l.Load(ctx, partPath)
+
+		results = append(results, ImageLoadResult{
+			PartID:   name,
+			RepoTag:  result.Pkg.Meta.Provides.Images[name],
+			PartPath: partPath,
+			Loaded:   loaded,
+			Err:      err,
+		})
+	}
+
+	return results, nil
+}
+
+// loadMessage is one of the JSON messages streamed in response to a load
+type loadMessage struct {
+	Stream      string `json:"stream"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// Load streams the image tarball at partPath into the daemon and returns the
+// images the daemon reported loading
+func (l *Loader) Load(ctx context.Context, partPath string) ([]string, error) {
+	file, err := os.Open(partPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	req, err := http.NewRequest(http.MethodPost, l.baseURL+"/images/load", file)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	response, err := l.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %v into Docker daemon. Error: %v", partPath, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, fmt.Errorf("Docker daemon responded to load of %v with HTTP status code: %v. Body: %v", partPath, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var loaded []string
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var message loadMessage
+		if err := decoder.Decode(&message); err == io.EOF {
+			return loaded, nil
+		} else if err != nil {
+			return loaded, fmt.Errorf("Failed to read response of Docker daemon to load of %v. Error: %v", partPath, err)
+		}
+
+		if message.ErrorDetail.Message != "" {
+			return loaded, fmt.Errorf("Docker daemon failed to load %v: %v", partPath, message.ErrorDetail.Message)
+		} else if message.Error != "" {
+			return loaded, fmt.Errorf("Docker daemon failed to load %v: %v", partPath, message.Error)
+		}
+
+		if stream := strings.TrimSpace(message.Stream); stream != "" {
+			loaded = append(loaded, stream)
+		}
+	}
+}
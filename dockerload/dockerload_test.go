@@ -0,0 +1,96 @@
+// +build unit
+
+package dockerload
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_Loader_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "dockerload-test-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	var received []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/images/load" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, string(body))
+
+		switch string(body) {
+		case "broken":
+			fmt.Fprintln(w, `{"errorDetail":{"message":"unexpected EOF"},"error":"unexpected EOF"}`)
+		default:
+			fmt.Fprintf(w, "{\"stream\":\"Loaded image: %s\\n\"}\n", body)
+		}
+	})
+
+	for name, content := range map[string]string{"a": "image-a:1", "b": "broken"} {
+		assert.Nil(suite, ioutil.WriteFile(path.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	result := &fetch.FetchResult{
+		Pkg: &horizonpkg.Pkg{
+			ID: "pkg",
+			Meta: &horizonpkg.Meta{
+				Provides: horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"a": "image-a:1", "b": "image-b:1", "c": "image-c:1"}},
+			},
+		},
+		// c wasn't fetched
+		Parts: map[string]string{"a": path.Join(tmpDir, "a"), "b": path.Join(tmpDir, "b")},
+	}
+
+	suite.Run("fetched parts are loaded with a result for each", func(t *testing.T) {
+		received = nil
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		results, err := NewLoaderWithClient(server.Client(), server.URL+"/").LoadFetched(context.Background(), result)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"image-a:1", "broken"}, received)
+		assert.Equal(t, 2, len(results))
+
+		assert.Equal(t, "a", results[0].PartID)
+		assert.Equal(t, "image-a:1", results[0].RepoTag)
+		assert.Equal(t, []string{"Loaded image: image-a:1"}, results[0].Loaded)
+		assert.Nil(t, results[0].Err)
+
+		assert.Equal(t, "b", results[1].PartID)
+		assert.Contains(t, results[1].Err.Error(), "unexpected EOF")
+	})
+
+	suite.Run("images are loaded through a unix socket", func(t *testing.T) {
+		received = nil
+		socketPath := path.Join(tmpDir, "docker.sock")
+		listener, err := net.Listen("unix", socketPath)
+		assert.Nil(t, err)
+
+		server := &http.Server{Handler: handler}
+		go server.Serve(listener)
+		defer server.Close()
+
+		loaded, err := NewLoader(socketPath).Load(context.Background(), path.Join(tmpDir, "a"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Loaded image: image-a:1"}, loaded)
+	})
+
+	suite.Run("a FetchResult without a Pkg is an error", func(t *testing.T) {
+		_, err := NewLoader(DefaultSocketPath).LoadFetched(context.Background(), &fetch.FetchResult{})
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,69 @@
+package fetch
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"io"
+)
+
+// checkEncoding returns an error if parts with the given encoding can't be
+// decoded
+func checkEncoding(encoding horizonpkg.PartEncoding) error {
+	switch encoding {
+	case "", horizonpkg.GZIP:
+		return nil
+	default:
+		return fmt.Errorf("Unsupported part encoding: %v", encoding)
+	}
+}
+
+// decodeError indicates a part source served content that couldn't be decoded
+type decodeError struct {
+	err error
+}
+
+func (e decodeError) Error() string {
+	return fmt.Sprintf("Failed to decode part content: %v", e.err)
+}
+
+// decodingReader decodes the content read from a part source; the decoder is
+// created on first Read so that errors reading the encoding's header are
+// returned from Read as well
+type decodingReader struct {
+	body    io.Reader
+	decoder io.Reader
+}
+
+// decode returns a reader of the decoded content of body
+func decode(body io.Reader, encoding horizonpkg.PartEncoding) io.Reader {
+	if encoding == "" {
+		return body
+	}
+
+	return &decodingReader{body: body}
+}
+
+func (r *decodingReader) Read(p []byte) (int, error) {
+	if r.decoder == nil {
+		// the only encoding is gzip, see checkEncoding
+		decoder, err := gzip.NewReader(r.body)
+		if err != nil {
+			return 0, r.wrap(err)
+		}
+		r.decoder = decoder
+	}
+
+	n, err := r.decoder.Read(p)
+	return n, r.wrap(err)
+}
+
+// wrap marks errors as decode errors; errStalled is passed through so a stall
+// is still reported as one
+func (r *decodingReader) wrap(err error) error {
+	if err == nil || err == io.EOF || err == errStalled {
+		return err
+	}
+
+	return decodeError{err}
+}
@@ -0,0 +1,75 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_Encoding_Suite(suite *testing.T) {
+	content := []byte("decoded part content")
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(content)
+	writer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzip":
+			w.Write(compressed.Bytes())
+		case "/plain":
+			w.Write(content)
+		default:
+			w.Write([]byte("not gzip"))
+		}
+	}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "fetch-test-encoding-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	session := newFetchSession(Options{})
+
+	fetch := func(name string, encoding horizonpkg.PartEncoding, sources ...horizonpkg.PartSource) ([]byte, error) {
+		partPath := path.Join(tmpDir, name)
+		if err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, name, partPath, int64(len(content)), encoding, sources, session); err != nil {
+			return nil, err
+		}
+
+		return ioutil.ReadFile(partPath)
+	}
+
+	suite.Run("gzip part is decoded on download", func(t *testing.T) {
+		written, err := fetch("gzip", horizonpkg.GZIP, horizonpkg.PartSource{URL: "/gzip"})
+		assert.Nil(t, err)
+		assert.Equal(t, content, written)
+	})
+
+	suite.Run("undecodable source is skipped", func(t *testing.T) {
+		written, err := fetch("fallback", horizonpkg.GZIP, horizonpkg.PartSource{URL: "/bad"}, horizonpkg.PartSource{URL: "/gzip"})
+		assert.Nil(t, err)
+		assert.Equal(t, content, written)
+	})
+
+	suite.Run("part without encoding is written as served", func(t *testing.T) {
+		written, err := fetch("plain", "", horizonpkg.PartSource{URL: "/plain"})
+		assert.Nil(t, err)
+		assert.Equal(t, content, written)
+	})
+
+	suite.Run("unknown encoding is rejected", func(t *testing.T) {
+		assert.NotNil(t, checkEncoding("zstd"))
+	})
+}
@@ -0,0 +1,52 @@
+package fetch
+
+import (
+	"context"
+)
+
+// ExistingPartCheck is a faux-enum identifying how an existing part file of
+// the expected size is checked before it's reused rather than fetched again
+type ExistingPartCheck string
+
+const (
+	// CHECK_DIGEST hashes an existing part file and fetches the part again
+	// if its content doesn't match the Pkg meta, e.g. because the meta
+	// changed the part's content since the file was fetched; it is the
+	// default. The hashes are kept so the file isn't read again to verify
+	// it.
+	CHECK_DIGEST ExistingPartCheck = "CHECK_DIGEST"
+
+	// CHECK_SIZE reuses an existing part file of the expected size without
+	// reading it; a file whose content doesn't match the Pkg meta then fails
+	// verification
+	CHECK_SIZE ExistingPartCheck = "CHECK_SIZE"
+)
+
+// existingPartCurrent reports whether the existing part file at partPath,
+// which has the expected size, has the content the Pkg meta describes for
+// the part partID, as checked with the session's ExistingParts. A file that
+// can't be checked is reused and left to verification.
+func (s *fetchSession) existingPartCurrent(ctx context.Context, partID string, partPath string) bool {
+	if s.opts.ExistingParts == CHECK_SIZE {
+		return true
+	}
+
+	// the part's hashes are needed to check the file
+	part, known := s.parts[partID]
+	if !known {
+		return true
+	}
+
+	hashers, err := hashPart(ctx, partPath, part.Digests, s)
+	if err != nil {
+		s.log.Errorf("Unable to hash existing part file %v, leaving it to verification. Error: %v", partPath, err)
+		return true
+	}
+
+	if !digestMatches(part.Sha256sum, part.Digests, hashers) {
+		return false
+	}
+
+	s.recordHashes(partPath, hashers)
+	return true
+}
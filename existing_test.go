@@ -0,0 +1,83 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_ExistingParts_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-existing-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	// the part's content changed in the meta, its size didn't
+	oldContent := []byte("old content")
+	newContent := []byte("new content")
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(newContent)
+	}))
+	defer server.Close()
+
+	fetch := func(name string, opts Options) []byte {
+		atomic.StoreInt32(&requests, 0)
+
+		partPath := path.Join(tmpDir, name)
+		assert.Nil(suite, ioutil.WriteFile(partPath, oldContent, 0600))
+
+		session := newFetchSession(opts)
+		session.parts = horizonpkg.DockerImageParts{
+			"part": {
+				ID:        "part",
+				Bytes:     int64(len(newContent)),
+				Sha256sum: fmt.Sprintf("%x", sha256.Sum256(newContent)),
+			},
+		}
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", partPath, int64(len(newContent)), "", []horizonpkg.PartSource{{URL: "/part"}}, session)
+		assert.Nil(suite, err)
+
+		content, err := ioutil.ReadFile(partPath)
+		assert.Nil(suite, err)
+		return content
+	}
+
+	suite.Run("part file with content from earlier meta is fetched again", func(t *testing.T) {
+		assert.Equal(t, newContent, fetch("changed", Options{}))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+
+	suite.Run("part file is trusted for its size if configured", func(t *testing.T) {
+		assert.Equal(t, oldContent, fetch("trusted", Options{ExistingParts: CHECK_SIZE}))
+		assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	})
+
+	suite.Run("current part file is reused and its hashes kept", func(t *testing.T) {
+		partPath := path.Join(tmpDir, "current")
+		assert.Nil(t, ioutil.WriteFile(partPath, newContent, 0600))
+
+		session := newFetchSession(Options{})
+		session.parts = horizonpkg.DockerImageParts{"part": {ID: "part", Bytes: int64(len(newContent)), Sha256sum: fmt.Sprintf("%x", sha256.Sum256(newContent))}}
+		assert.True(t, session.existingPartCurrent(context.Background(), "part", partPath))
+
+		expected := sha256.Sum256(newContent)
+		hashers := session.takeHashes(partPath)
+		if assert.NotNil(t, hashers[horizonpkg.SHA256]) {
+			assert.Equal(t, expected[:], hashers[horizonpkg.SHA256].Sum(nil))
+		}
+	})
+}
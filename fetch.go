@@ -1,12 +1,13 @@
 package fetch
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/golang/glog"
-	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
 	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
 	"hash"
@@ -17,20 +18,82 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
-func authenticatedRequest(pURL string, authCreds map[string]map[string]string) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodGet, pURL, nil)
+func authenticatedRequest(ctx context.Context, pURL string, authCreds map[string]map[string]string, session *fetchSession) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	userAgent := session.opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	if err := applyCredentials(req, pURL, authCreds, session); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// credentialHeaderPrefix marks the keys of credentials that are arbitrary
+// headers to set on matching requests, e.g. "header:X-Api-Key"
+const credentialHeaderPrefix = "header:"
+
+// credentialHeaders returns the headers, by name, configured in creds
+func credentialHeaders(creds map[string]string) map[string]string {
+	headers := make(map[string]string)
+	for k, v := range creds {
+		if strings.HasPrefix(k, credentialHeaderPrefix) {
+			headers[strings.TrimPrefix(k, credentialHeaderPrefix)] = v
+		}
+	}
+
+	return headers
+}
+
+// applyCredentials sets the auth header, or AWS SigV4 signature, and any other
+// configured headers from authCreds matching pURL on req. OAuth2 bearer tokens
+// are requested if none are cached.
+func applyCredentials(req *http.Request, pURL string, authCreds map[string]map[string]string, session *fetchSession) error {
+	for k, v := range authCreds {
+		if strings.HasPrefix(pURL, k) {
+			for name, value := range credentialHeaders(v) {
+				session.log.Infof(5, "Setting configured header %v on request to %v", name, pURL)
+				req.Header.Set(name, value)
+			}
+		}
+	}
+
 	// matching them (for now) amounts to first prefix match wins
 	for k, v := range authCreds {
 		if strings.HasPrefix(pURL, k) {
 
+			// sources requiring AWS SigV4 are signed rather than given Basic auth
+			if creds, ok := sigV4Credentials(v); ok {
+				session.log.Infof(3, "Signing request to %v with AWS SigV4 using access key %v", pURL, creds.accessKeyID)
+				signSigV4(req, creds, time.Now())
+				break
+			}
+
+			if creds, ok := oauth2CredentialsFrom(v); ok {
+				token, err := session.tokens.token(req.Context(), creds, session)
+				if err != nil {
+					return fetcherrors.PkgSourceFetchAuthError{fmt.Sprintf("Failed to obtain OAuth2 token for request to %v", pURL), err, nil}
+				}
+
+				session.log.Infof(3, "Using OAuth2 bearer token of client %v in request to %v", creds.clientID, pURL)
+				req.Header.Set("Authorization", "Bearer "+token)
+				break
+			}
+
 			var username string
 			if val, ok := v["username"]; ok {
 				username = val
@@ -42,55 +105,109 @@ func authenticatedRequest(pURL string, authCreds map[string]map[string]string) (
 			}
 
 			if username != "" && password != "" {
-				glog.V(3).Infof("Using username %v in HTTPS Basic auth header to %v", username, pURL)
+				session.log.Infof(3, "Using username %v in HTTPS Basic auth header to %v", username, pURL)
 				req.SetBasicAuth(username, password)
 				break
 			}
 		}
 	}
 
-	return req, nil
+	return nil
 }
 
-// side effect: stores the pkgMeta file in destinationDir
-func fetchPkgMeta(client *http.Client, authCreds map[string]map[string]string, primarySigningKey string, userKeysDir string, pkgURL string, pkgURLSignature string, destinationDir string) (*horizonpkg.Pkg, error) {
-	writeFile := func(destinationDir string, fileName string, content []byte) (string, error) {
-		destFilePath := path.Join(destinationDir, fileName)
-		// this'll overwrite
-		if err := ioutil.WriteFile(destFilePath, content, 0600); err != nil {
-			return "", fetcherrors.PkgMetaError{fmt.Sprintf("Failed to write file %v", destFilePath), err}
-		}
+// side effect: stores the pkgMeta file in destinationDir if writeMeta is true
+// and returns its path. Meta that is written is streamed to disk rather than
+// held in memory.
+func fetchPkgMeta(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, primarySigningKey string, userKeysDir string, pkgURL string, pkgURLSignature string, destinationDir string, writeMeta bool, session *fetchSession) (*horizonpkg.Pkg, string, error) {
+	session.log.Infof(5, "Fetching Pkg from %v", pkgURL)
 
-		return destFilePath, nil
-	}
-
-	glog.V(5).Infof("Fetching Pkg from %v", pkgURL)
-
-	req, err := authenticatedRequest(pkgURL, authCreds)
+	req, err := authenticatedRequest(ctx, pkgURL, authCreds, session)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
+	// asking for gzip explicitly means the transport leaves the body compressed
+	// so it's decompressed here, see metaBody
+	req.Header.Set("Accept-Encoding", "gzip")
+
 	// fetch, hydrate
 	response, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Unexpected status code in response to Horizon Pkg fetch: %v", response.StatusCode), fmt.Errorf("Failed to fetch Pkg meta from %v", pkgURL)}
+		return nil, "", fetcherrors.PkgMetaError{fmt.Sprintf("Unexpected status code in response to Horizon Pkg fetch: %v", response.StatusCode), fmt.Errorf("Failed to fetch Pkg meta from %v", pkgURL)}
 	}
 	defer response.Body.Close()
-	rawBody, err := ioutil.ReadAll(response.Body)
+
+	// meta is untrusted until verified so no more of it is read than the limit, whatever its encoding
+	limit := session.maxMetadataBytes()
+	if response.ContentLength > limit {
+		return nil, "", fetcherrors.PkgMetaError{fmt.Sprintf("Pkg meta from %v is %v bytes, larger than the limit of %v bytes", pkgURL, response.ContentLength, limit), fmt.Errorf("Refused Pkg meta from %v", pkgURL)}
+	}
+
+	// a response that clearly isn't Pkg meta is reported as such rather than failing signature verification
+	body := bufio.NewReader(&metaLimitReader{reader: metaBody(response, pkgURL, session), limit: limit})
+	if err := checkMetaJSON(body, response.Header.Get("Content-Type"), pkgURL); err != nil {
+		return nil, "", err
+	}
+
+	if writeMeta {
+		return streamPkgMeta(ctx, body, primarySigningKey, userKeysDir, pkgURL, pkgURLSignature, destinationDir, session)
+	}
+
+	rawBody, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", fetcherrors.PkgMetaError{fmt.Sprintf("Failed to read Pkg meta from %v", pkgURL), err}
+	}
+	session.dump.setMeta(rawBody)
+
+	pkg, err := parsePkgMeta(ctx, rawBody, primarySigningKey, userKeysDir, pkgURL, pkgURLSignature, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return pkg, "", nil
+}
+
+// metaBody returns a reader of the Pkg meta in the response from source,
+// decompressing it if it was served with Content-Encoding: gzip. The meta's
+// signature is of its decompressed content.
+func metaBody(response *http.Response, source string, session *fetchSession) io.Reader {
+	if !strings.EqualFold(response.Header.Get("Content-Encoding"), "gzip") {
+		return response.Body
+	}
+
+	session.log.Infof(5, "Decompressing gzip-encoded Pkg meta from %v", source)
+	return decode(response.Body, horizonpkg.GZIP)
+}
+
+// requireSignature returns an error if no Pkg signature is given, either as
+// pkgURLSignature or in the session's MetaSignatures, unless the session skips
+// signature verification
+func (s *fetchSession) requireSignature(pkgURLSignature string) error {
+	if len(s.metaSignatures(pkgURLSignature)) == 0 && !s.opts.InsecureSkipSignatureVerification {
+		return fmt.Errorf("Disabling Pkg file signature checking not supported")
+	}
+
+	return nil
+}
+
+// parsePkgMeta verifies the signature of the raw Pkg meta read from source and
+// returns the valid Pkg it describes
+func parsePkgMeta(ctx context.Context, rawBody []byte, primarySigningKey string, userKeysDir string, source string, pkgURLSignature string, session *fetchSession) (*horizonpkg.Pkg, error) {
+	if err := checkMetaStructure(bytes.NewReader(rawBody), session.maxParts()); err != nil {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Pkg metadata from %v is not acceptable JSON", source), err}
+	}
 
 	hasher := sha256.New()
 	if _, err := io.Copy(hasher, bytes.NewReader(rawBody)); err != nil {
 		return nil, fmt.Errorf("Unable to copy Pkg content into hash function. Error: %v", err)
 	}
 
-	if err := verifySignatureWithAnyKey(primarySigningKey, userKeysDir, hasher, []string{pkgURLSignature}); err != nil {
-
-		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Pkg metadata failed cryptographic verification: %v", err), fmt.Errorf("Failure processing Pkg meta: %v and signature: %v", pkgURL, pkgURLSignature)}
+	if err := verifyMetaSignature(ctx, hasher, primarySigningKey, userKeysDir, source, pkgURLSignature, session); err != nil {
+		return nil, err
 	}
 
 	var pkg horizonpkg.Pkg
@@ -98,29 +215,229 @@ func fetchPkgMeta(client *http.Client, authCreds map[string]map[string]string, p
 		return nil, err
 	}
 
-	fetchFilePath, err := writeFile(destinationDir, fmt.Sprintf("%v.json", pkg.ID), rawBody)
-	if err != nil {
+	if err := pkg.Validate(); err != nil {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Pkg metadata from %v is invalid", source), err}
+	}
+
+	if err := session.checkFreshness(&pkg, source); err != nil {
 		return nil, err
 	}
 
-	glog.V(2).Infof("Wrote PkgMeta to %v", fetchFilePath)
+	// keep a private copy of the verified bytes, the parts to fetch are decoded from it
+	session.verifiedMeta = append([]byte(nil), rawBody...)
+
+	return &pkg, nil
+}
+
+// metaSignatures returns the signatures with which the Pkg meta is verified:
+// the session's MetaSignatures and pkgURLSignature, if given
+func (s *fetchSession) metaSignatures(pkgURLSignature string) []string {
+	signatures := append([]string(nil), s.opts.MetaSignatures...)
+	if pkgURLSignature != "" {
+		signatures = append(signatures, pkgURLSignature)
+	}
+
+	return signatures
+}
+
+// partSignatures returns the signatures with which the named part is
+// verified: those in the session's PartSignatures if there are any, otherwise
+// those in the Pkg meta
+func (s *fetchSession) partSignatures(name string, part horizonpkg.DockerImagePart) []string {
+	signatures := part.Signatures
+	if overridden := s.opts.PartSignatures[name]; len(overridden) > 0 {
+		signatures = overridden
+	}
+
+	// signatures fetched from sidecars are tried as well
+	if sidecar := s.sidecarSignaturesOf(name); len(sidecar) > 0 {
+		signatures = append(append([]string{}, signatures...), sidecar...)
+	}
+	return signatures
+}
 
-	// TODO: dump all pkg content (both meta and parts) to debug
+// verifyMetaSignature verifies the signature of the Pkg meta read from source
+// whose content was hashed by hasher, unless the session skips verification
+func verifyMetaSignature(ctx context.Context, hasher hash.Hash, primarySigningKey string, userKeysDir string, source string, pkgURLSignature string, session *fetchSession) error {
+	if session.opts.InsecureSkipSignatureVerification {
+		session.log.Errorf("WARNING: signature verification is disabled, Pkg meta %v and its parts are NOT verified to be authentic", source)
+		return nil
+	}
+
+	if err := verifySignatureWithAnyKey(ctx, primarySigningKey, userKeysDir, hasher, session.metaSignatures(pkgURLSignature), session); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		return fetcherrors.PkgMetaError{fmt.Sprintf("Pkg metadata failed cryptographic verification: %v", err), fmt.Errorf("Failure processing Pkg meta: %v and signature: %v", source, pkgURLSignature)}
+	}
+
+	return nil
+}
+
+// trustPkg treats the session's trustedPkg, whose meta was verified by the
+// caller, as if its meta had been fetched and verified by the session, and
+// returns a copy of it
+func (s *fetchSession) trustPkg() (*horizonpkg.Pkg, error) {
+	if err := s.trustedPkg.Validate(); err != nil {
+		return nil, fetcherrors.PkgMetaError{"Pkg meta verified by the caller is invalid", err}
+	}
+
+	if err := s.checkFreshness(s.trustedPkg, "the caller"); err != nil {
+		return nil, err
+	}
+
+	// the Pkg is copied so later changes to the caller's don't affect the fetch
+	meta, err := json.Marshal(s.trustedPkg)
+	if err != nil {
+		return nil, fetcherrors.PkgMetaError{"Failed to copy Pkg meta verified by the caller", err}
+	}
+
+	s.log.Infof(3, "Using Pkg meta of %v verified by the caller, not fetching it", s.trustedPkg.ID)
+	s.verifiedMeta = meta
+	return s.verifiedPkg()
+}
+
+// verifiedPkg decodes the Pkg from the meta whose signature the session
+// verified. Each call returns a new Pkg sharing nothing with any other so its
+// content is exactly what was signed however other Pkgs have been used.
+func (s *fetchSession) verifiedPkg() (*horizonpkg.Pkg, error) {
+	// meta streamed to disk is checked against the sha256 that was verified
+	if s.verifiedMetaPath != "" {
+		return decodeMetaFile(s.verifiedMetaPath, s.verifiedMetaSum, s)
+	}
+
+	if s.verifiedMeta == nil {
+		return nil, errors.New("No Pkg meta has been verified")
+	}
+
+	var pkg horizonpkg.Pkg
+	if err := json.Unmarshal(s.verifiedMeta, &pkg); err != nil {
+		return nil, err
+	}
 
 	return &pkg, nil
 }
 
-func precheckPkgParts(pkg *horizonpkg.Pkg) error {
-	for _, part := range pkg.Parts {
+// precheckPkgParts checks the parts with the given IDs (all of the Pkg's parts
+// if partIDs is empty) of pkg, whose meta the session must have verified, and
+// returns them
+func precheckPkgParts(pkg *horizonpkg.Pkg, partIDs []string, session *fetchSession) (horizonpkg.DockerImageParts, error) {
+	// the parts are taken from the verified meta rather than pkg so that their
+	// digests and sources are those signed, even if pkg was changed or came
+	// from other meta
+	verified, err := session.verifiedPkg()
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg.ID != verified.ID {
+		return nil, fmt.Errorf("Pkg %v is not the Pkg %v whose metadata was verified", pkg.ID, verified.ID)
+	}
+
+	parts, err := selectPkgParts(verified, partIDs, session)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.opts.RequireImageParts {
+		if err := checkImageParts(verified); err != nil {
+			return nil, err
+		}
+	}
+
+	// sources don't matter to parts that are only verified
+	if !session.verifyOnly {
+		if err := checkMinSources(parts, session.opts.MinSourcesPerPart); err != nil {
+			return nil, err
+		}
+	}
+
+	return parts, nil
+}
+
+// checkMinSources checks that each of parts has at least minSources sources
+// with distinct URLs, naming the parts that don't
+func checkMinSources(parts horizonpkg.DockerImageParts, minSources int) error {
+	if minSources < 2 {
+		return nil
+	}
+
+	var lacking []string
+	for name, part := range parts {
+		urls := make(map[string]bool)
+		for _, source := range part.Sources {
+			urls[source.URL] = true
+		}
+
+		if len(urls) < minSources {
+			lacking = append(lacking, name)
+		}
+	}
+
+	if len(lacking) > 0 {
+		sort.Strings(lacking)
+		return fmt.Errorf("Error in pkg file: parts %v have fewer than the required %v distinct sources", strings.Join(lacking, ", "), minSources)
+	}
+
+	return nil
+}
+
+// checkImageParts checks that each image the Pkg provides has a part, naming
+// the images that don't
+func checkImageParts(pkg *horizonpkg.Pkg) error {
+	var missing []string
+	for name, image := range pkg.Meta.Provides.Images {
+		if _, exists := pkg.Parts[name]; !exists {
+			missing = append(missing, fmt.Sprintf("%v (part %v)", image, name))
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Error in pkg file: images %v provided in Meta.Provides have no parts", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// selectPkgParts checks the parts with the given IDs (all of the Pkg's parts
+// if partIDs is empty) of pkg and returns them. Unlike precheckPkgParts it
+// doesn't require that the session verified pkg's meta.
+func selectPkgParts(pkg *horizonpkg.Pkg, partIDs []string, session *fetchSession) (horizonpkg.DockerImageParts, error) {
+	var err error
+	selected := pkg.Parts
+
+	if len(partIDs) > 0 {
+		selected = horizonpkg.DockerImageParts{}
+		for _, id := range partIDs {
+			part, exists := pkg.Parts[id]
+			if !exists {
+				return nil, fmt.Errorf("Error in requested parts: Pkg has no part with id %v", id)
+			}
+			selected[id] = part
+		}
+	}
+
+	for _, part := range selected {
 		repoTag, exists := pkg.Meta.Provides.Images[part.ID]
 		if !exists {
-			return fmt.Errorf("Error in pkg file: Meta.Provides is expected to contain metadata about each part and it is missing info about part %v", part)
+			return nil, fmt.Errorf("Error in pkg file: Meta.Provides is expected to contain metadata about each part and it is missing info about part %v", part)
 		}
-		glog.V(2).Infof("Precheck of container %v (Pkg part id: %v) passed, will fetch it", repoTag, part.ID)
+
+		if err := checkEncoding(part.Encoding); err != nil {
+			return nil, fmt.Errorf("Error in pkg file: part %v can't be fetched. Error: %v", part.ID, err)
+		}
+		session.log.Infof(2, "Precheck of container %v (Pkg part id: %v) passed, will fetch it", repoTag, part.ID)
 
 	}
 
-	return nil
+	if session.partFiles, err = session.partFileNames(selected); err != nil {
+		return nil, fmt.Errorf("Error naming part files: %v", err)
+	}
+	session.parts = selected
+
+	return selected, nil
 }
 
 // VerificationError extends error, indicating a problem verifying a Pkg part
@@ -138,8 +455,21 @@ type fetchErrRecorder struct {
 	WriteLock *sync.Mutex
 }
 
-func (r fetchErrRecorder) String() string {
-	return fmt.Sprintf("Errors: %v", r.Errors)
+// joined returns the errors of the failed parts, in part name order, joined
+// into one whose Unwrap returns each
+func (r fetchErrRecorder) joined() error {
+	var names []string
+	for name := range r.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("Part %v: %w", name, r.Errors[name]))
+	}
+
+	return errors.Join(errs...)
 }
 
 func newFetchErrRecorder() fetchErrRecorder {
@@ -152,165 +482,651 @@ func newFetchErrRecorder() fetchErrRecorder {
 type partFetchFailure struct {
 	HTTPStatusCode int
 	PartURL        string
+	Err            error
 }
 
-func fetchPkgPart(client *http.Client, authCreds map[string]map[string]string, pkgURLBase string, partPath string, expectedBytes int64, sources []horizonpkg.PartSource) error {
-	tryOpen := func(path string) (*os.File, error) {
-		return os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0600)
+// outcome describes the failure as the outcome of an attempt to fetch from
+// its source taking the given time
+func (f *partFetchFailure) outcome(duration time.Duration) fetcherrors.SourceOutcome {
+	return fetcherrors.SourceOutcome{f.PartURL, f.HTTPStatusCode, f.Err, duration}
+}
+
+// baseURL returns the URL of the directory containing the Pkg meta at pkgURL,
+// without a trailing slash, to which part sources with absolute paths are
+// relative. The query and fragment of pkgURL are dropped; a pkgURL ending
+// with a slash is a directory.
+func baseURL(pkgURL url.URL) string {
+	dir := pkgURL.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
 	}
 
-	tryRemove := func(f *os.File, msg string) error {
-		glog.Error(msg)
+	// path.Dir of a path without directories is "."
+	if dir == "." {
+		dir = ""
+	}
 
-		f.Close()
-		err := os.Remove(f.Name())
-		if err != nil {
-			return err
+	base := url.URL{
+		Scheme: pkgURL.Scheme,
+		User:   pkgURL.User,
+		Host:   pkgURL.Host,
+		Path:   strings.TrimSuffix(dir, "/"),
+	}
+
+	return base.String()
+}
+
+// partSourceURL composes the full URL of a part source
+func partSourceURL(pkgURLBase string, source horizonpkg.PartSource, session *fetchSession) string {
+	if gatewayURL, ok := session.ipfsGatewayURL(source.URL); ok {
+		if gatewayURL == "" {
+			session.log.Errorf("Part source %v is addressed by IPFS CID but no IPFS gateway is configured, it can't be fetched", source.URL)
+			return source.URL
 		}
 
+		session.log.Infof(3, "Part source %v is addressed by IPFS CID, fetching it from gateway URL %v", source.URL, gatewayURL)
+		return gatewayURL
+	}
+
+	if strings.HasPrefix(source.URL, "/") {
+		// it's an absolute path but we need to prepend the Pkg's domain, it's assumed by convention
+		pURL := fmt.Sprintf("%s%s", pkgURLBase, source.URL)
+		session.log.Infof(3, "Part has absolute URL path but assumes domain by convention. Composed full URL %v using domain from Pkg URL", pURL)
+		return pURL
+	}
+
+	return source.URL
+}
+
+// discard removes a failed part file or, if the session keeps failed
+// artifacts, quarantines it by renaming it with the suffix ".corrupt". Files
+// are left in place if the session only verifies. Parts in a Destination are
+// always removed.
+func (s *fetchSession) discard(partPath string) error {
+	if s.verifyOnly {
+		s.log.Infof(3, "Verifying only, leaving failed part file %v in place", partPath)
 		return nil
 	}
 
-	var partFile *os.File
-	var openErr error
-	partFile, openErr = tryOpen(partPath)
+	if s.opts.Destination != nil {
+		return s.opts.Destination.Remove(partPath)
+	}
+
+	// the validators of a failed part are of no use
+	if s.conditionalRequests() {
+		if err := s.fs.Remove(partPath + fetchManifestSuffix); err != nil && !os.IsNotExist(err) {
+			s.log.Errorf("Failed to remove fetch manifest of part %v. Error: %v", partPath, err)
+		}
+	}
+
+	if !s.opts.KeepFailedArtifacts {
+		return s.fs.Remove(partPath)
+	}
+
+	quarantinePath := partPath + ".corrupt"
+	if err := s.fs.Rename(partPath, quarantinePath); err != nil {
+		return err
+	}
+
+	s.log.Errorf("Kept failed part file as %v", quarantinePath)
+	return nil
+}
+
+// prioritizedSources returns a copy of sources ordered by descending
+// Priority, keeping the order of sources of equal Priority
+func prioritizedSources(sources []horizonpkg.PartSource) []horizonpkg.PartSource {
+	prioritized := make([]horizonpkg.PartSource, len(sources))
+	copy(prioritized, sources)
 
-	if openErr != nil && os.IsExist(openErr) {
+	sort.SliceStable(prioritized, func(i, j int) bool {
+		return prioritized[i].Priority > prioritized[j].Priority
+	})
+	return prioritized
+}
 
-		info, statErr := os.Stat(partPath)
-		if statErr != nil {
-			err := tryRemove(partFile, fmt.Sprintf("Error getting status for file %v although it exists. Will attempt to delete it and continue", partPath))
+func fetchPkgPart(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, pkgURLBase string, partID string, partPath string, expectedBytes int64, encoding horizonpkg.PartEncoding, sources []horizonpkg.PartSource, session *fetchSession) error {
+	// sources on hosts that aren't allowed are never contacted, nor are hosts
+	// they redirect to; responses are classified with the session's StatusClasses
+	ctx = session.withStatusClasses(partRequests(ctx))
+	sources, err := session.allowedSources(pkgURLBase, partID, sources)
+	if err != nil {
+		return err
+	}
+
+	// mirror indexes are replaced by the mirrors they list, whose hosts must be allowed too
+	sources, err = session.allowedSources(pkgURLBase, partID, expandMirrorIndexes(ctx, client, authCreds, pkgURLBase, partID, sources, session))
+	if err != nil {
+		return err
+	}
+
+	// the response of a source revalidating an existing part file with new content
+	var revalidated *revalidation
+
+	if size, statErr := session.partSize(partPath); statErr == nil {
+		reusable := size == expectedBytes && session.partsReadable()
+		if reusable {
+			// a part file with validators is revalidated with its source rather than trusted for its size
+			revalidated = revalidatePart(ctx, client, authCreds, pkgURLBase, partID, partPath, sources, session)
+			reusable = revalidated == nil || revalidated.reused
+		}
+
+		// a part file that wasn't revalidated is checked against the Pkg meta, which may have changed since it was fetched
+		stale := reusable && revalidated == nil && !session.existingPartCurrent(ctx, partID, partPath)
+		if stale {
+			reusable = false
+		}
+
+		if reusable {
+			session.log.Infof(3, "Part file %v exists on disk and it has the appropriate size, skipping redownload", partPath)
+			session.metrics.IncSkipped(session.pkgID, partID)
+			session.dump.recordOutcome(partID, dumpSkipped, "")
+			session.addReused(expectedBytes)
+			fetchSidecarSignatures(ctx, client, authCreds, pkgURLBase, partID, sources, session)
+			return nil
+		}
+
+		if revalidated != nil {
+			if revalidated.response != nil {
+				// closed early once written, this is in case the part file can't be replaced
+				defer revalidated.response.Body.Close()
+			}
+			session.log.Infof(3, "Part file %v is out of date, deleting it and fetching it again", partPath)
+		} else if stale {
+			session.log.Errorf("Part file %v has the appropriate size but not the content in the Pkg meta, which may have changed since it was fetched. Deleting it and fetching it again", partPath)
+		} else if size == expectedBytes {
+			session.log.Infof(3, "Part %v exists in the Destination but can't be read back to verify it, fetching it again", partPath)
+		} else {
+			session.log.Errorf("Part file %v exists on disk but it's not complete (%v bytes and should be %v bytes). Deleting it and trying again", partPath, size, expectedBytes)
+		}
+		if err := session.discard(partPath); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(statErr) {
+		session.log.Errorf("Error getting status for file %v. Will attempt to delete it and continue", partPath)
+		if err := session.discard(partPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// encoded parts are decoded as they're downloaded so they can't be resumed,
+	// nor can parts written to a Destination be appended to
+	download, openErr := openPartDownload(partPath, expectedBytes, session.opts.ResumeDownloads && encoding == "" && session.opts.Destination == nil, session)
+	if openErr != nil {
+		return openErr
+	}
+	defer download.Close()
+
+	if download.resumable && download.offset == expectedBytes {
+		session.log.Infof(3, "Partial download %v is complete", download.path)
+		session.addReused(expectedBytes)
+		session.dump.recordOutcome(partID, dumpFetched, "")
+		fetchSidecarSignatures(ctx, client, authCreds, pkgURLBase, partID, sources, session)
+		return download.complete()
+	}
+
+	// bytes of a resumed download were downloaded by an earlier fetch
+	resumed := download.offset
+	session.addReused(resumed)
+
+	// restart discards what has been downloaded so the next source starts over
+	restart := func(msg string) error {
+		session.log.Errorf("%v", msg)
+		session.addReused(-resumed)
+		resumed = 0
+		return download.reset()
+	}
+
+	var fetchFailure *partFetchFailure
+	started := time.Now()
+
+	// the outcome of each failed attempt, reported if all sources fail
+	var attempted []fetcherrors.SourceOutcome
+
+	// set if a source's content differs from the partial download it resumed
+	var drifted bool
+
+	// copies a successful response into the part file; returns true if the part is complete
+	writePart := func(response *http.Response, source horizonpkg.PartSource, pURL string) (bool, error) {
+		// a resumed download overlaps the end of what was downloaded
+		var overlap int64
+		if response.StatusCode == http.StatusPartialContent {
+			overlap = download.overlap()
+			if start, ok := contentRangeStart(response); !ok || start != download.offset-overlap {
+				msg := fmt.Sprintf("Content-Range of response from %v is %v and part %v should resume at byte %v", pURL, response.Header.Get("Content-Range"), partPath, download.offset-overlap)
+				session.log.Errorf("%v. Skipping this source", msg)
+				fetchFailure = &partFetchFailure{response.StatusCode, pURL, fetcherrors.PkgSourceContentLengthError{msg, fmt.Errorf("Rejected source of part: %v", partPath)}}
+				return false, nil
+			}
+		} else if download.offset > 0 {
+			if err := restart(fmt.Sprintf("Source %v didn't resume part %v, downloading it from the start", pURL, partPath)); err != nil {
+				return false, err
+			}
+		}
+		offset := download.offset
+
+		// a missing Content-Length (-1) is unknown, we'll check the size after download; the
+		// Content-Length of an encoded part is its encoded size so it can't be checked here
+		if encoding == "" && response.ContentLength >= 0 && response.ContentLength != expectedBytes-offset+overlap {
+			msg := fmt.Sprintf("Content-Length of response from %v is %v bytes and part %v should be %v bytes", pURL, response.ContentLength, partPath, expectedBytes-offset+overlap)
+			session.log.Errorf("%v. Skipping this source", msg)
+			fetchFailure = &partFetchFailure{response.StatusCode, pURL, fetcherrors.PkgSourceContentLengthError{msg, fmt.Errorf("Rejected source of part: %v", partPath)}}
+			return false, nil
+		}
+
+		var body io.Reader = response.Body
+		if session.opts.StallTimeout > 0 {
+			stallReader := newStallReader(response.Body, session.opts.StallTimeout)
+			defer stallReader.Stop()
+			body = stallReader
+		}
+		body = session.throttle(ctx, &countingReader{&contextReader{ctx, body}, session})
+
+		// the overlap must match what was downloaded lest the source's content have changed since
+		if overlap > 0 {
+			tail := make([]byte, overlap)
+			if _, err := io.ReadFull(body, tail); err != nil {
+				msg := fmt.Sprintf("Download of part %v from %v was interrupted before resuming at byte %v", partPath, pURL, offset)
+				session.log.Errorf("%v. Error: %v", msg, err)
+				fetchFailure = &partFetchFailure{response.StatusCode, pURL, fetcherrors.PkgSourceInterruptedError{msg, err}}
+				return false, nil
+			}
+
+			matched, err := download.matchesTail(tail)
 			if err != nil {
+				return false, err
+			} else if !matched {
+				msg := fmt.Sprintf("Content of part %v from %v differs from its partial download, downloading it from the start", partPath, pURL)
+				fetchFailure = &partFetchFailure{response.StatusCode, pURL, fetcherrors.PkgSourceFetchError{msg, fmt.Errorf("Last %v bytes before byte %v don't match", overlap, offset), nil}}
+				drifted = true
+				return false, restart(msg)
+			}
+		}
+
+		// a source serving more than the part's size is cut off rather than copied without bound
+		reported := &progressReader{decode(body, encoding), ContextReporter(ctx), partID, download.offset, expectedBytes}
+		bytes, err := io.Copy(download, io.LimitReader(reported, expectedBytes-offset+1))
+		if decodeErr, ok := err.(decodeError); ok {
+			msg := fmt.Sprintf("Content of part %v from %v could not be decoded as %v", partPath, pURL, encoding)
+			fetchFailure = &partFetchFailure{response.StatusCode, pURL, fetcherrors.PkgSourceFetchError{msg, decodeErr, nil}}
+
+			// give it another shot with the next source
+			return false, restart(msg)
+		} else if err == errStalled {
+			msg := fmt.Sprintf("Download of part %v from %v stalled after %v bytes: no bytes received in %v", partPath, pURL, bytes, session.opts.StallTimeout)
+			fetchFailure = &partFetchFailure{response.StatusCode, pURL, fetcherrors.PkgSourceStalledError{msg, err}}
+
+			if download.resumable {
+				session.log.Errorf("%v. Resuming from byte %v with the next source", msg, download.offset)
+				return false, nil
+			}
+
+			// give it another shot with the next source
+			return false, restart(msg)
+		} else if ctx.Err() == nil && (interruptedCopy(err) || (err == nil && encoding == "" && offset+bytes < expectedBytes)) {
+			// the source closed the connection early if it served fewer bytes without an error
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			msg := fmt.Sprintf("Download of part %v from %v was interrupted after %v of its %v bytes", partPath, pURL, offset+bytes, expectedBytes)
+			fetchFailure = &partFetchFailure{response.StatusCode, pURL, fetcherrors.PkgSourceInterruptedError{msg, err}}
+
+			if download.resumable {
+				session.log.Errorf("%v. Resuming from byte %v", msg, download.offset)
+				return false, nil
+			}
+
+			// give it another shot from the start
+			return false, restart(msg)
+		} else if err == errBudgetExceeded {
+			return false, session.budgetError()
+		} else if err != nil {
+			return false, fmt.Errorf("IO copy from HTTP response body failed on part: %v. Error: %v", partPath, err)
+		}
+
+		if offset+bytes != expectedBytes {
+			session.log.Errorf("Error in download and copy of part %v from %v (using url %v)", partPath, source, pURL)
+			msg := fmt.Sprintf("Downloaded %v bytes from %v and part %v should be %v bytes", offset+bytes, pURL, partPath, expectedBytes)
+			if offset+bytes > expectedBytes {
+				msg = fmt.Sprintf("Source %v served more than the %v bytes of part %v, aborted download", pURL, expectedBytes, partPath)
+			}
+			fetchFailure = &partFetchFailure{response.StatusCode, pURL, fetcherrors.PkgSourceSizeError{msg, fmt.Errorf("Size mismatch in download of part: %v", partPath)}}
+
+			// give it another shot
+			return false, restart(fmt.Sprintf("Error in download and copy of part %v from %v (using url %v)", partPath, source, pURL))
+		}
+
+		if err := download.complete(); err != nil {
+			return false, err
+		}
+
+		if err := writeFetchManifest(partPath, pURL, response, session); err != nil {
+			session.log.Errorf("Failed to write fetch manifest of part %v. Error: %v", partPath, err)
+		}
+
+		session.log.Infof(2, "Successfully wrote %v", partPath)
+		session.dump.recordOutcome(partID, dumpFetched, pURL)
+		session.partDownloaded(partID, pURL)
+		session.metrics.ObserveFetch(session.pkgID, partID, bytes, time.Since(started))
+
+		if source.SignatureSidecar {
+			fetchSidecarSignature(ctx, client, authCreds, partID, pURL, session)
+		}
+		return true, nil
+	}
+
+	if revalidated != nil && revalidated.response != nil {
+		done, err := writePart(revalidated.response, revalidated.source, revalidated.pURL)
+		revalidated.response.Body.Close()
+		if err != nil || done {
+			return err
+		}
+		attempted = append(attempted, fetchFailure.outcome(time.Since(started)))
+		session.attemptFailed(partID, fetchFailure.error())
+	}
+
+	sources = session.orderedSources(sources)
+	remaining := sources
+
+	// a large part is downloaded in concurrent chunks from its first source if it supports range requests
+	if revalidated == nil && len(sources) > 0 && session.chunked(expectedBytes, encoding, download) {
+		pURL := partSourceURL(pkgURLBase, sources[0], session)
+
+		head, err := fetchChunks(ctx, client, authCreds, partID, pURL, expectedBytes, download.file.(io.WriterAt), session)
+		if err == nil {
+			if err := download.complete(); err != nil {
 				return err
 			}
 
-		} else if info.Size() == expectedBytes {
-			glog.V(3).Infof("Part file %v exists on disk and it has the appropriate size, skipping redownload", partPath)
+			if err := writeFetchManifest(partPath, pURL, head, session); err != nil {
+				session.log.Errorf("Failed to write fetch manifest of part %v. Error: %v", partPath, err)
+			}
+
+			session.log.Infof(2, "Successfully wrote %v in chunks of %v bytes", partPath, session.opts.ChunkBytes)
+			session.dump.recordOutcome(partID, dumpFetched, pURL)
+			session.partDownloaded(partID, pURL)
+			session.metrics.ObserveFetch(session.pkgID, partID, expectedBytes, time.Since(started))
+
+			if sources[0].SignatureSidecar {
+				fetchSidecarSignature(ctx, client, authCreds, partID, pURL, session)
+			}
 			return nil
+		}
+
+		if session.overBudget() {
+			return session.budgetError()
+		} else if err == errRangesUnsupported {
+			session.log.Infof(3, "Source %v of part %v doesn't support range requests, downloading it in a single stream", pURL, partPath)
 		} else {
-			// TODO: can try resume here if we have an HTTP server that knows how to handle it
-			err := tryRemove(partFile, fmt.Sprintf("Part file %v exists on disk but it's not complete (%v bytes and should be %v bytes). Deleting it and trying again", partPath, info.Size(), expectedBytes))
-			if err != nil {
+			fetchFailure = &partFetchFailure{0, pURL, err}
+			if se, ok := err.(statusError); ok {
+				fetchFailure.HTTPStatusCode = se.statusCode
+			}
+			attempted = append(attempted, fetchFailure.outcome(time.Since(started)))
+			session.attemptFailed(partID, err)
+
+			// the sources are tried again in turn with a single stream
+			if err := restart(fmt.Sprintf("Chunked download of part %v from %v failed, downloading it in a single stream. Error: %v", partPath, pURL, err)); err != nil {
 				return err
 			}
 		}
-		partFile.Close()
-		partFile, openErr = tryOpen(partPath)
-		if openErr != nil {
-			return openErr
-		}
 	}
 
-	var fetchFailure *partFetchFailure
+	if race := session.racedSources(len(sources)); race > 1 {
+		raced := sources[:race]
+		remaining = sources[race:]
 
-	// we are clean, try download
-	for _, source := range sources {
-		var pURL string
-		if strings.HasPrefix(source.URL, "/") {
-			// it's an absolute path but we need to prepend the Pkg's domain, it's assumed by convention
-			pURL = fmt.Sprintf("%s%s", pkgURLBase, source.URL)
-			glog.V(3).Infof("Part has absolute URL path but assumes domain by convention. Composed full URL %v using domain from Pkg URL", pURL)
+		winner, failure, outcomes := raceSources(ctx, client, authCreds, pkgURLBase, raced, session)
+		attempted = append(attempted, outcomes...)
+		if failure != nil {
+			fetchFailure = failure
 		} else {
-			pURL = source.URL
+			done, err := writePart(winner.response, winner.source, winner.pURL)
+			winner.response.Body.Close()
+			winner.cancel()
+
+			if err != nil || done {
+				return err
+			}
+			attempted = append(attempted, fetchFailure.outcome(time.Since(started)))
 		}
+		session.attemptFailed(partID, fetchFailure.error())
+	}
 
-		fetchFailure = nil
+	// we are clean, try download
+	for ix, source := range remaining {
+		pURL := partSourceURL(pkgURLBase, source, session)
 
-		req, err := authenticatedRequest(pURL, authCreds)
-		if err != nil {
-			return err
+		if ix > 0 || len(remaining) < len(sources) {
+			session.metrics.IncRetry(session.pkgID, partID)
 		}
 
-		// fetch, hydrate
-		response, err := client.Do(req)
-		if err != nil || response.StatusCode != http.StatusOK {
-			glog.Errorf("Failed to download part %v from %v (using url %v). Response: %v. Error: %v", partPath, source, pURL, response, err)
-			fetchFailure = &partFetchFailure{response.StatusCode, pURL}
-		} else {
-			defer response.Body.Close()
-			bytes, err := io.Copy(partFile, response.Body)
-			if err != nil {
-				return fmt.Errorf("IO copy from HTTP response body failed on part: %v. Error: %v", partPath, err)
-			}
+		sourceStarted := time.Now()
 
-			if bytes != expectedBytes {
-				glog.Errorf("Error in download and copy of part %v from %v (using url %v)", partPath, source, pURL)
+		// an interrupted download is retried from the same source as the
+		// session's Retrier permits, resuming it if it's resumable
+		for attempt := 1; ; attempt++ {
+			fetchFailure = nil
 
-				// ignore error, give it another shot
-				tryRemove(partFile, fmt.Sprintf("Error in download and copy of part %v from %v (using url %v)", partPath, source, pURL))
+			// a resumed download requests the last bytes downloaded again to check them
+			offset, header := download.offset, http.Header(nil)
+			if overlap := download.overlap(); overlap > 0 {
+				offset = 0
+				header = http.Header{}
+				header.Set("Range", fmt.Sprintf("bytes=%d-", download.offset-overlap))
+			}
 
-				partFile, openErr = tryOpen(partPath)
-				if openErr != nil {
-					return openErr
+			// fetch, hydrate
+			response, err := requestWithRetries(ctx, client, authCreds, partID, pURL, offset, header, session)
+			if err != nil || (response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent) {
+				session.log.Errorf("Failed to download part %v from %v (using url %v). Response: %v. Error: %v", partPath, source, pURL, response, err)
+				fetchFailure = &partFetchFailure{0, pURL, err}
+				if response != nil {
+					fetchFailure.HTTPStatusCode = response.StatusCode
+					response.Body.Close()
 				}
-				defer partFile.Close()
+				break
+			}
+
+			// closed before the next attempt so responses don't pile up
+			done, err := writePart(response, source, pURL)
+			response.Body.Close()
+			if err != nil || done {
+				return err
+			}
+
+			// a source whose content changed since the partial download is downloaded again from the start
+			if drifted {
+				drifted = false
+				attempted = append(attempted, fetchFailure.outcome(time.Since(sourceStarted)))
+				session.attemptFailed(partID, fetchFailure.error())
+				sourceStarted = time.Now()
 				continue
-			} else {
-				glog.V(2).Infof("Successfully wrote %v", partPath)
-				return nil
 			}
+
+			if _, interrupted := fetchFailure.Err.(fetcherrors.PkgSourceInterruptedError); !interrupted {
+				break
+			}
+
+			wait, retry := session.retrier().NextBackoff(attempt, fetchFailure.Err, nil)
+			if !retry {
+				break
+			}
+
+			attempted = append(attempted, fetchFailure.outcome(time.Since(sourceStarted)))
+			session.attemptFailed(partID, fetchFailure.error())
+			session.log.Infof(3, "Retrying interrupted download of part %v from %v in %v (attempt %v)", partPath, pURL, wait, attempt+1)
+			session.metrics.IncRetry(session.pkgID, partID)
+
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+			sourceStarted = time.Now()
 		}
+		attempted = append(attempted, fetchFailure.outcome(time.Since(sourceStarted)))
+		session.attemptFailed(partID, fetchFailure.error())
+	}
+
+	if err := download.abandon(); err != nil {
+		session.log.Errorf("Failed to discard part file %v of failed fetch. Error: %v", download.path, err)
 	}
 
 	internalError := fmt.Errorf("Part could not be fetched: %v from any of its sources: %v", partPath, sources)
 
 	// if this isn't nil, we failed on at least the most recent source and report it
 	if fetchFailure != nil {
-		if fetchFailure.HTTPStatusCode == 401 || fetchFailure.HTTPStatusCode == 403 {
-			return fetcherrors.PkgSourceFetchAuthError{fmt.Sprintf("Authentication or Authorization error attempting to fetch part from URL: %v. HTTP Status code: %v", fetchFailure.PartURL, fetchFailure.HTTPStatusCode), internalError}
+		if fetchFailure.Err != nil {
+			return fetcherrors.PkgSourceFetchError{fmt.Sprintf("Error when fetching part from URL: %v", fetchFailure.PartURL), fetchFailure.Err, attempted}
 		}
 
-		return fetcherrors.PkgSourceFetchError{fmt.Sprintf("Error when fetching part from URL: %v. HTTP Status code: %v", fetchFailure.PartURL, fetchFailure.HTTPStatusCode), internalError}
+		class := session.statusClass(fetchFailure.HTTPStatusCode)
+		if class == AUTH {
+			return fetcherrors.PkgSourceFetchAuthError{fmt.Sprintf("Authentication or Authorization error attempting to fetch part from URL: %v. HTTP Status code: %v", fetchFailure.PartURL, fetchFailure.HTTPStatusCode), internalError, attempted}
+		}
+
+		return fetcherrors.PkgSourceFetchError{fmt.Sprintf("Error when fetching part from URL: %v. HTTP Status code: %v", fetchFailure.PartURL, fetchFailure.HTTPStatusCode), statusError{fetchFailure.HTTPStatusCode, internalError, class}, attempted}
 	}
 
 	// try fetching a part from each source, if all fail exit with error
-	return fetcherrors.PkgSourceFetchError{fmt.Sprintf("Failed to complete fetch."), internalError}
+	return fetcherrors.PkgSourceFetchError{fmt.Sprintf("Failed to complete fetch."), internalError, attempted}
 }
 
-// all provided signatures must match keys in userKeysDir
-func verifyPkgPart(primarySigningKey string, userKeysDir string, partPath string, partHash string, signatures []string) error {
+// requestWithRetries requests pURL from byte offset with any additional
+// header, retrying per the session's Retrier if the request fails
+func requestWithRetries(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, partID string, pURL string, offset int64, header http.Header, session *fetchSession) (*http.Response, error) {
+	retrier := session.retrier()
 
-	glog.V(5).Infof("Verifying pkg part %v with userKeysDir %v and signatures %v", partPath, userKeysDir, signatures)
+	for attempt := 1; ; attempt++ {
+		req, err := authenticatedRequest(ctx, pURL, authCreds, session)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
 
-	partFile, err := os.Open(partPath)
-	if err != nil {
-		return err
+		response, err := client.Do(req)
+		if (err == nil && (response.StatusCode == http.StatusOK || response.StatusCode == http.StatusPartialContent)) || ctx.Err() != nil {
+			return response, err
+		}
+
+		// a redirect to a host that isn't allowed won't be allowed on retry
+		var disallowed fetcherrors.PkgSourceDisallowedError
+		if errors.As(err, &disallowed) {
+			return nil, err
+		}
+
+		wait, retry := retrier.NextBackoff(attempt, err, response)
+		if !retry {
+			return response, err
+		}
+
+		if response != nil {
+			session.attemptFailed(partID, statusError{response.StatusCode, fmt.Errorf("Source %v of part failed", pURL), session.statusClass(response.StatusCode)})
+		} else {
+			session.attemptFailed(partID, err)
+		}
+
+		if response != nil {
+			session.log.Infof(3, "Source %v responded with status %v, retrying in %v (attempt %v)", pURL, response.StatusCode, wait, attempt+1)
+			response.Body.Close()
+		} else {
+			session.log.Infof(3, "Request to source %v failed, retrying in %v (attempt %v). Error: %v", pURL, wait, attempt+1, err)
+		}
+		session.metrics.IncRetry(session.pkgID, partID)
+
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
 	}
-	defer partFile.Close()
+}
 
-	// Read the file content into the hash function.
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, partFile); err != nil {
-		return fmt.Errorf("Unable to copy image file content into hash function for part %v. Error: %v", partPath, err)
+// all provided signatures must match keys in userKeysDir; the content must
+// match partHash or any of digests
+func verifyPkgPart(ctx context.Context, primarySigningKey string, userKeysDir string, partPath string, partHash string, digests []horizonpkg.Digest, signatures []string, session *fetchSession) error {
+
+	session.log.Infof(5, "Verifying pkg part %v with userKeysDir %v and signatures %v", partPath, userKeysDir, signatures)
+
+	// the hashes of a resumable download or a part written to a Destination
+	// are computed as it's downloaded
+	hashers := session.takeHashes(partPath)
+	if !hashesCover(hashers, digests) {
+		var err error
+		if hashers, err = hashPart(ctx, partPath, digests, session); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
 	}
+	hasher := hashers[horizonpkg.SHA256]
 
-	// check the hash first
 	actualHash := fmt.Sprintf("%x", string(hasher.Sum(nil)))
-	if partHash != actualHash {
+	session.dump.recordHash(partPath, actualHash)
+
+	// check the hash first
+	if !digestMatches(partHash, digests, hashers) {
 		// delete file too
-		partFile.Close()
-		err := os.Remove(partPath)
+		err := session.discard(partPath)
 		if err != nil {
-			glog.Errorf("Failed to remove part %v after failed hash check. Error: %v", partPath, err)
+			session.log.Errorf("Failed to remove part %v after failed hash check. Error: %v", partPath, err)
 		}
-		return fetcherrors.PkgSignatureVerificationError{fmt.Sprintf("Mismatch between expected hash, %v and actual hash.", partHash, actualHash), fmt.Errorf("Part failed verification: %v", partPath)}
+		return fetcherrors.PkgSignatureVerificationError{fmt.Sprintf("Mismatch between expected hash %v and actual hash %v", partHash, actualHash), fmt.Errorf("Part failed verification: %v", partPath)}
+	}
+
+	if session.opts.InsecureSkipSignatureVerification {
+		session.log.Infof(3, "Skipped signature verification of part %v", partPath)
+		session.recordVerifiedSum(partPath, actualHash)
+		return nil
+	}
+
+	// the signed part index vouches for the content, its hash is enough
+	if session.indexed(actualHash) {
+		session.log.Infof(3, "Part %v is listed in the verified part index, skipping its signatures", partPath)
+		session.recordVerifiedSum(partPath, actualHash)
+		return nil
 	}
 
-	if err := verifySignatureWithAnyKey(primarySigningKey, userKeysDir, hasher, signatures); err == nil {
+	err := verifySignatureWithAnyKey(ctx, primarySigningKey, userKeysDir, hasher, signatures, session)
+	if err == nil {
 		// verified
+		session.recordVerifiedSum(partPath, actualHash)
 		return nil
+	} else if ctxErr := ctx.Err(); ctxErr != nil {
+		// not a verification failure, the part wasn't checked
+		return ctxErr
 	}
 
 	return fetcherrors.PkgSignatureVerificationError{fmt.Sprintf("Part failed cryptographic verification: %v", err), fmt.Errorf("Part failed verification: %v", partPath)}
 }
 
-func verifySignatureWithAnyKey(primarySigningKey string, userKeysDir string, hasher hash.Hash, signatures []string) error {
+// verifySignatureWithAnyKey verifies that any of the signatures of the content
+// hashed by hasher was made with one of the keys in primarySigningKey, the
+// session's PrimarySigningKeys or userKeysDir. Signatures may be RSA-PSS or, for ed25519 keys, ed25519
+// signatures of the content's SHA-256 digest.
+func verifySignatureWithAnyKey(ctx context.Context, primarySigningKey string, userKeysDir string, hasher hash.Hash, signatures []string, session *fetchSession) error {
+	// keys are parsed once per fetch, not for each part
+	ed25519Keys, rsaKeys := session.keys.load(session.primarySigningKeys(primarySigningKey), userKeysDir)
+	digest := hasher.Sum(nil)
 
 	// this is computationally expensive
 	for _, sig := range signatures {
-		// TODO: refactor this code, extract verification into rsapss-tool; for efficiency, perhaps we should give keys IDs and include those in the pkg signature
-		glog.V(7).Infof("Verifying with sig: %v, userKeysDir: %v", sig, userKeysDir)
-		verified, err := policy.VerifyWorkload(primarySigningKey, sig, hasher, userKeysDir)
+		// a canceled fetch shouldn't grind through the remaining signatures
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// ed25519 verification is cheap so it's tried first with any such keys
+		if len(ed25519Keys) > 0 && verifyEd25519(ed25519Keys, sig, digest) {
+			session.log.Infof(7, "Verified ed25519 sig: %v", sig)
+			return nil
+		}
+
+		// TODO: for efficiency, perhaps we should give keys IDs and include those in the pkg signature
+		session.log.Infof(7, "Verifying with sig: %v, userKeysDir: %v", sig, userKeysDir)
+		verified, err := verifyRSAPSS(rsaKeys, sig, digest)
 		if err != nil {
 			return err
 		}
@@ -323,9 +1139,44 @@ func verifySignatureWithAnyKey(primarySigningKey string, userKeysDir string, has
 	return VerificationError{}
 }
 
-func fetchAndVerify(httpClientFactory func(overrideTimeoutS *uint) *http.Client, authCreds map[string]map[string]string, pkgURLBase string, parts horizonpkg.DockerImageParts, destinationDir string, primarySigningKey string, userKeysDir string) ([]string, error) {
+// fatalPartError reports whether err fetching one part means fetches of the
+// Pkg's other parts will fail too
+func fatalPartError(err error) bool {
+	switch err.(type) {
+	case fetcherrors.PkgSourceFetchAuthError, fetcherrors.PkgBudgetExceededError:
+		return true
+	}
+	return false
+}
+
+func fetchAndVerify(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, pkgURLBase string, parts horizonpkg.DockerImageParts, destinationDir string, primarySigningKey string, userKeysDir string, session *fetchSession) ([]string, error) {
+	if limit := session.opts.MaxTotalBytes; limit > 0 {
+		var total int64
+		for _, part := range parts {
+			total += part.Bytes
+		}
+
+		if total > limit {
+			return nil, fetcherrors.PkgPrecheckError{fmt.Sprintf("Parts to fetch total %v bytes, more than the limit of %v bytes", total, limit), fmt.Errorf("Refused to fetch parts into %v", destinationDir)}
+		}
+	}
+
+	// the part index is verified before anything is downloaded
+	if err := session.trustIndex(ctx, primarySigningKey, userKeysDir, parts); err != nil {
+		return nil, err
+	}
+
+	reporter := ContextReporter(ctx)
+
 	fetchErrs := newFetchErrRecorder()
 	var fetched []string
+	fetchedPaths := make(map[string]bool)
+	session.fetchedParts = make(map[string]string)
+	session.fetchedSums = make(map[string]string)
+
+	// a fatal error fetching one part cancels the fetches of the others
+	ctx, cancelParts := context.WithCancel(ctx)
+	defer cancelParts()
 
 	addResult := func(id string, err error, partPath string) {
 		fetchErrs.WriteLock.Lock()
@@ -334,114 +1185,387 @@ func fetchAndVerify(httpClientFactory func(overrideTimeoutS *uint) *http.Client,
 		if err != nil {
 			// record failures
 
-			glog.V(6).Infof("Recording fetch error: %v with key: %v", err, id)
+			session.log.Infof(6, "Recording fetch error: %v with key: %v", err, id)
 			fetchErrs.Errors[id] = err
+			session.dump.recordFailure(id, err)
+			session.partFailed(id, err)
+			session.emitPart(PartResult{ID: id, Err: err})
+			reporter.PartCompleted(id, err)
+
+			if fatalPartError(err) {
+				session.log.Errorf("Fatal error fetching part %v, canceling fetches of remaining parts. Error: %v", id, err)
+				cancelParts()
+			}
 		} else if partPath != "" {
 			// success
 
-			var abs string
-			abs, err = filepath.Abs(partPath)
+			// parts in a Destination are named by their paths as they are
+			abs := partPath
+			if session.opts.Destination == nil {
+				abs, err = filepath.Abs(partPath)
+			}
 			if err != nil {
 				fetchErrs.Errors[id] = err
 			} else {
-				fetched = append(fetched, abs)
+				// parts with the same content may share a file
+				if !fetchedPaths[abs] {
+					fetched = append(fetched, abs)
+					fetchedPaths[abs] = true
+				}
+				session.fetchedParts[id] = abs
+				if sum := session.verifiedSum(partPath); sum != "" {
+					session.fetchedSums[id] = sum
+				}
+				session.partCompleted(id, abs)
+				session.emitPart(PartResult{ID: id, Path: abs, Sha256sum: session.fetchedSums[id]})
+				reporter.PartCompleted(id, nil)
+			}
+		}
+	}
+
+	reporter.FetchStarted(len(parts), session.concurrency(len(parts)))
+
+	// parts verified by an interrupted earlier fetch are neither fetched nor verified again
+	progress := loadFetchProgress(destinationDir, session)
+	remaining := parts
+	if progress != nil {
+		remaining = make(horizonpkg.DockerImageParts)
+		for name, part := range parts {
+			partPath := session.partPath(destinationDir, name)
+			if !progress.verified(name, part, partPath) {
+				remaining[name] = part
+				continue
 			}
+
+			session.log.Infof(3, "Part file %v was verified by an earlier fetch, skipping it", partPath)
+			session.recordVerifiedSum(partPath, progress.sum(name))
+			session.partStarted(name, part.Bytes)
+			reporter.PartStarted(name, part.Bytes)
+			session.metrics.IncSkipped(session.pkgID, name)
+			session.dump.recordOutcome(name, dumpSkipped, "")
+			session.addReused(part.Bytes)
+			addResult(name, nil, partPath)
+		}
+	}
+
+	partClient := withoutTimeout(client)
+
+	// parts missing from destinationDir are downloaded and verified in the staging directory, if any, and moved into place
+	stagingDir := session.stagingDir(destinationDir)
+	var stagedLock sync.Mutex
+	staged := make(map[string]string)
+	if stagingDir != "" {
+		if err := session.fs.MkdirAll(stagingDir, session.dirMode()); err != nil {
+			err = fetcherrors.PkgSourceError{fmt.Sprintf("Failed to create staging directory %v", stagingDir), err}
+			reporter.FetchCompleted(err)
+			return nil, err
 		}
+		defer session.fs.Remove(stagingDir)
+	}
+	stagedPath := func(name string) string {
+		stagedLock.Lock()
+		defer stagedLock.Unlock()
+
+		return staged[name]
+	}
+
+	// verification is CPU-bound, it is done by a pool of verifiers separate from the downloads
+	verifications := make(chan string)
+	var verifiers sync.WaitGroup
+
+	for ix := 0; ix < session.verifyConcurrency(); ix++ {
+		verifiers.Add(1)
+
+		go func() {
+			defer verifiers.Done()
+
+			for name := range verifications {
+				// TODO: support retries here
+				fetchErrs.WriteLock.Lock()
+				failed := len(fetchErrs.Errors) != 0
+				fetchErrs.WriteLock.Unlock()
+
+				// parts downloaded by a failing resumable fetch are still verified so the next fetch can skip them
+				if failed && progress == nil {
+					continue
+				}
+
+				// the fetch's deadline may pass while parts wait to be verified
+				if err := ctx.Err(); err != nil {
+					addResult(name, err, "")
+					continue
+				}
+
+				part := parts[name]
+				partPath := session.partPath(destinationDir, name)
+
+				verifyPath := partPath
+				if stagedFile := stagedPath(name); stagedFile != "" {
+					verifyPath = stagedFile
+				}
+
+				session.log.Infof(2, "Verifying %v", part)
+				err := verifyPkgPart(ctx, primarySigningKey, userKeysDir, verifyPath, part.Sha256sum, part.Digests, session.partSignatures(name, part), session)
+				if err == nil && verifyPath != partPath {
+					session.log.Infof(3, "Moving verified part %v from staging to %v", name, partPath)
+					if moveErr := session.unstage(verifyPath, partPath); moveErr != nil {
+						err = fetcherrors.PkgSourceError{fmt.Sprintf("Failed to move staged part %v to %v", verifyPath, partPath), moveErr}
+					}
+				}
+				if err != nil {
+					session.metrics.IncFailure(session.pkgID, name)
+					if _, ok := err.(fetcherrors.PkgSignatureVerificationError); ok {
+						session.metrics.IncVerificationFailure(session.pkgID)
+					}
+				} else {
+					progress.record(name, part, partPath)
+				}
+				addResult(name, err, partPath)
+			}
+		}()
 	}
 
 	var group sync.WaitGroup
 
-	for name, part := range parts {
+	// parts with identical content are downloaded once and linked to the others' paths
+	groups := session.partGroups(remaining)
+	if err := session.checkBudget(groups, remaining, destinationDir); err != nil {
+		reporter.FetchCompleted(err)
+		return nil, err
+	}
+
+	for _, names := range groups {
 
 		group.Add(1)
 
 		// wrap up the functionality per part; (note that we avoid problematic closed-over iteration vars in the go routine)
-		go func(name string, part horizonpkg.DockerImagePart) {
+		go func(names []string) {
 			defer group.Done()
 
+			name := names[0]
+			part := parts[name]
+
+			for _, name := range names {
+				session.partStarted(name, parts[name].Bytes)
+				reporter.PartStarted(name, parts[name].Bytes)
+			}
+
 			// we don't care about file extensions if they're not in the ID
-			partPath := path.Join(destinationDir, name)
+			partPath := session.partPath(destinationDir, name)
 
-			glog.V(5).Infof("Dispatched goroutine to download (%v) to path: %v (part: %v)", name, partPath, part)
+			// the group's parts are all downloaded where its first is
+			workDir := destinationDir
+			if stagingDir != "" && session.stages(partPath, part.Bytes) {
+				workDir = stagingDir
+				partPath = session.partPath(stagingDir, name)
 
-			var timeoutS uint
-			if part.Bytes <= 1024*1024 {
-				timeoutS = uint(120)
-			} else {
-				timeoutS = uint((part.Bytes * 8) / 1024 / 100)
+				stagedLock.Lock()
+				for _, name := range names {
+					staged[name] = session.partPath(stagingDir, name)
+				}
+				stagedLock.Unlock()
+			}
+
+			session.log.Infof(5, "Dispatched goroutine to download (%v) to path: %v (part: %v)", name, partPath, part)
+
+			download := func() error {
+				if err := session.acquirePart(ctx); err != nil {
+					return fetcherrors.PkgSourceFetchError{fmt.Sprintf("Canceled while waiting to fetch part %v", name), err, nil}
+				}
+				defer session.releasePart()
+
+				timeout := session.partTimeout(part.Bytes)
+
+				session.log.Infof(2, "Fetching %v with timeout %v", part.ID, timeout)
+				// the client is shared by all parts so it can reuse connections, timeouts are set per part
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+
+				return fetchPkgPart(ctx, partClient, authCreds, pkgURLBase, name, partPath, part.Bytes, part.Encoding, part.Sources, session)
+			}
+
+			if err := session.content.fetch(part.Sha256sum, partPath, part.Bytes, download, session); err != nil {
+				for _, name := range names {
+					session.metrics.IncFailure(session.pkgID, name)
+					addResult(name, err, "")
+				}
+				return
 			}
 
-			glog.V(2).Infof("Fetching %v", part.ID)
-			addResult(name, fetchPkgPart(httpClientFactory(&timeoutS), authCreds, pkgURLBase, partPath, part.Bytes, part.Sources), "")
+			for _, duplicate := range names[1:] {
+				session.shareSidecarSignatures(name, duplicate)
 
-			// TODO: support retries here
-			if len(fetchErrs.Errors) == 0 {
-				glog.V(2).Infof("Verifying %v", part)
-				addResult(name, verifyPkgPart(primarySigningKey, userKeysDir, partPath, part.Sha256sum, part.Signatures), partPath)
+				duplicatePath := session.partPath(workDir, duplicate)
+				if duplicatePath == partPath {
+					session.dump.recordOutcome(duplicate, dumpLinked, "")
+					continue
+				}
+				session.log.Infof(3, "Part %v has the same content as %v, linking %v to %v", duplicate, name, partPath, duplicatePath)
+
+				if err := linkOrCopy(session.fs, partPath, duplicatePath, session.fileMode()); err != nil {
+					session.metrics.IncFailure(session.pkgID, duplicate)
+					addResult(duplicate, fetcherrors.PkgSourceError{fmt.Sprintf("Failed to link part %v to %v", partPath, duplicatePath), err}, "")
+				} else {
+					session.addReused(parts[duplicate].Bytes)
+					session.dump.recordOutcome(duplicate, dumpLinked, "")
+				}
+			}
+
+			// hand off to the verifiers so this download slot isn't held during verification
+			for _, name := range names {
+				verifications <- name
 			}
 
-		}(name, part)
+		}(names)
 	}
 
 	group.Wait()
+	close(verifications)
+	verifiers.Wait()
 
 	if len(fetchErrs.Errors) > 0 {
-		return nil, fmt.Errorf("Error fetching parts. Errors: %v", &fetchErrs)
+		var err error = fetcherrors.PkgPartsError{"Error fetching parts", fetchErrs.joined()}
+
+		// the parts that weren't fetched were aborted for the budget
+		if session.overBudget() {
+			err = session.budgetError()
+		}
+		reporter.FetchCompleted(err)
+		return nil, err
 	}
 
+	progress.complete()
+	reporter.FetchCompleted(nil)
 	return fetched, nil
 }
 
-// PkgFetch fetches a pkg metadata file from the given URL and then verifies
-// the content of the pkg.
-//     pkgURL is the URL of the pkg file containing the image content
-func PkgFetch(httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string) ([]string, error) {
-	mkdirs := func(pp string) error {
-		if err := os.MkdirAll(pp, 0700); err != nil {
-			return err
-		}
-		return nil
+// localPkgURL returns pkgURL with the file scheme if it is a local path
+func localPkgURL(pkgURL url.URL) url.URL {
+	if pkgURL.Scheme == "" && path.IsAbs(pkgURL.Path) {
+		pkgURL.Scheme = "file"
 	}
 
-	client := httpClientFactory(nil)
-
-	if pkgURLSignature == "" {
-		return nil, fmt.Errorf("Disabling Pkg file signature checking not supported")
-	}
+	return pkgURL
+}
 
-	// make pkg subdirectory in destination directory
-	if err := mkdirs(destinationDir); err != nil {
-		return nil, fetcherrors.PkgSourceError{"Failed creating Pkg destination dirs on host", err}
-	}
+// PkgFetch fetches a pkg metadata file from the given URL and then verifies
+// the content of the pkg.
+//     pkgURL is the URL of the pkg file containing the image content; it and
+//     part sources may be file:// URLs (pkgURL may also be a local path) to
+//     fetch from local or mounted storage
+//     httpClientFactory is called once per fetch; the client it produces is
+//     shared by all part fetches so connections are reused, with per-part
+//     timeouts applied to each request
+//     authCreds maps URL prefixes to the credentials used for requests to
+//     them: "username" and "password" for Basic auth, "client_cert" and
+//     "client_key" paths of a TLS client certificate, "aws_access_key_id",
+//     "aws_secret_access_key", "aws_region" and optionally "aws_service"
+//     (default "s3") and "aws_session_token" to sign requests with AWS SigV4,
+//     "oauth2_token_url", "oauth2_client_id", "oauth2_client_secret" and
+//     optionally "oauth2_scope" to send bearer tokens obtained with the OAuth2
+//     client credentials grant, and any other headers to send, keyed by
+//     "header:" and the header name
+// Callers making many fetches with the same configuration may prefer a
+// Fetcher.
+//
+// Deprecated: use PkgFetchContext, which can be canceled.
+func PkgFetch(httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string) ([]string, error) {
+	return PkgFetchContext(context.Background(), httpClientFactory, pkgURL, pkgURLSignature, destinationDir, primarySigningKey, userKeysDir, authCreds)
+}
 
-	pkg, err := fetchPkgMeta(client, authCreds, primarySigningKey, userKeysDir, pkgURL.String(), pkgURLSignature, destinationDir)
+// PkgFetchContext behaves like PkgFetch but aborts the fetch, returning ctx's
+// error, once ctx is done: requests are made with ctx and copies of part
+// content stop.
+func PkgFetchContext(ctx context.Context, httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string) ([]string, error) {
+	result, err := PkgFetchWithOptionsContext(ctx, httpClientFactory, pkgURL, pkgURLSignature, destinationDir, primarySigningKey, userKeysDir, authCreds, Options{})
 	if err != nil {
 		return nil, err
 	}
 
-	// we do this separately so we have a greater chance of the async fetches succeeding before we start them all
-	if err := precheckPkgParts(pkg); err != nil {
-		return nil, fetcherrors.PkgPrecheckError{"Failed to validate Pkg information before fetching", err}
-	}
-
-	pkgDestinationDir := path.Join(destinationDir, pkg.ID)
-	if err := mkdirs(pkgDestinationDir); err != nil {
-		return nil, fetcherrors.PkgSourceError{"Failed creating Pkg destination dirs on host", err}
-	}
+	return result.PartPaths, nil
+}
 
-	pkgURLParts := strings.Split(pkgURL.String(), "/")
-	pkgURLBase := strings.Join(pkgURLParts[0:len(pkgURLParts)-1], "/")
+// FetchResult describes a completed Pkg fetch.
+type FetchResult struct {
+	// Pkg is the fetched and verified Pkg meta
+	Pkg *horizonpkg.Pkg
+
+	// MetaPath is the path of the Pkg meta file written to the destination
+	// directory
+	MetaPath string
+
+	// PartPaths are the absolute paths of the fetched and verified parts; if
+	// Options.PartIDs was set, only the selected parts are included
+	PartPaths []string
+
+	// Parts are the absolute paths of the fetched and verified parts by part
+	// name; parts with the same content may share a path
+	Parts map[string]string
+
+	// Sha256sums are the hex-encoded sha256 digests of the content of the
+	// fetched and verified parts by part name, as computed when they were
+	// verified
+	Sha256sums map[string]string
+
+	// ContentDigest is a hex sha256 digest of the names and Sha256sums of the
+	// fetched and verified parts. It depends on nothing else, so fetches of
+	// Pkgs whose parts have the same names and content have the same digest
+	// wherever their sources and destinations are.
+	ContentDigest string
+
+	// Sources are the URLs of the sources that served the fetched and
+	// verified parts by part name. Parts that were already on disk or linked
+	// to a part with the same content aren't included.
+	Sources map[string]string
+
+	// BytesDownloaded is the number of bytes of part content received from
+	// sources, including those of failed attempts
+	BytesDownloaded int64
+
+	// BytesReused is the number of bytes of part content satisfied by files
+	// already on disk or downloaded once for parts with identical content
+	BytesReused int64
+}
 
-	glog.V(4).Infof("Extracted pkgURLBase %v from pkgURL %v", pkgURLBase, pkgURL.String())
+// PkgFetchWithMeta behaves like PkgFetchWithOptions but fetches the parts of
+// pkg, whose meta the caller has already verified, without fetching the meta
+// again; see Fetcher.FetchWithMeta.
+//
+// Deprecated: use PkgFetchWithMetaContext, which can be canceled.
+func PkgFetchWithMeta(httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkg *horizonpkg.Pkg, metaVerified bool, pkgURL url.URL, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string, opts Options) (*FetchResult, error) {
+	return PkgFetchWithMetaContext(context.Background(), httpClientFactory, pkg, metaVerified, pkgURL, destinationDir, primarySigningKey, userKeysDir, authCreds, opts)
+}
 
-	var fetched []string
-	fetched, err = fetchAndVerify(httpClientFactory, authCreds, pkgURLBase, pkg.Parts, pkgDestinationDir, primarySigningKey, userKeysDir)
-	if err != nil {
-		return nil, err
-	}
+// PkgFetchWithMetaContext behaves like PkgFetchWithMeta but aborts the fetch,
+// returning ctx's error, once ctx is done.
+func PkgFetchWithMetaContext(ctx context.Context, httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkg *horizonpkg.Pkg, metaVerified bool, pkgURL url.URL, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string, opts Options) (*FetchResult, error) {
+	fetcher := NewFetcher(
+		WithHTTPClientFactory(httpClientFactory),
+		WithSigningKeys(primarySigningKey, userKeysDir),
+		WithAuthCreds(authCreds),
+		WithOptions(opts),
+	)
+
+	return fetcher.FetchWithMeta(ctx, pkg, metaVerified, pkgURL, destinationDir)
+}
 
-	// TODO: expand to return the .fetch file; also shortcut some fetch operations if it exists
-	// for now we just return the old-style image files slice
+// PkgFetchWithOptions behaves like PkgFetch but applies the given Options to
+// the fetch and returns a FetchResult.
+//
+// Deprecated: use PkgFetchWithOptionsContext, which can be canceled.
+func PkgFetchWithOptions(httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string, opts Options) (*FetchResult, error) {
+	return PkgFetchWithOptionsContext(context.Background(), httpClientFactory, pkgURL, pkgURLSignature, destinationDir, primarySigningKey, userKeysDir, authCreds, opts)
+}
 
-	return fetched, nil
+// PkgFetchWithOptionsContext behaves like PkgFetchWithOptions but aborts the
+// fetch, returning ctx's error, once ctx is done.
+func PkgFetchWithOptionsContext(ctx context.Context, httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string, opts Options) (*FetchResult, error) {
+	fetcher := NewFetcher(
+		WithHTTPClientFactory(httpClientFactory),
+		WithSigningKeys(primarySigningKey, userKeysDir),
+		WithAuthCreds(authCreds),
+		WithOptions(opts),
+	)
+
+	return fetcher.Fetch(ctx, pkgURL, pkgURLSignature, destinationDir)
 }
@@ -3,13 +3,16 @@
 package fetch
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
 	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
 	"github.com/open-horizon/rsapss-tool/sign"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -17,6 +20,8 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -46,7 +51,7 @@ func setup(t *testing.T, tmpDir string, serverURL string) *horizonpkg.Pkg {
 	for id, _ := range pkg.Parts {
 		// only modify those with scheme and domain, ignore the absolute path source URLs
 		if strings.HasPrefix(pkg.Parts[id].Sources[0].URL, "http") {
-			pkg.Parts[id].Sources[0] = horizonpkg.PartSource{fmt.Sprintf("%s%s/%s/%s.tgz", serverURL, urlPath, pkg.ID, id)}
+			pkg.Parts[id].Sources[0] = horizonpkg.PartSource{URL: fmt.Sprintf("%s%s/%s/%s.tgz", serverURL, urlPath, pkg.ID, id)}
 		}
 	}
 
@@ -102,8 +107,20 @@ func Test_PkgFetch_Suite(suite *testing.T) {
 	router := mux.NewRouter()
 	router.PathPrefix(urlPath).Handler(http.StripPrefix(urlPath, http.FileServer(http.Dir(fmt.Sprintf("%v/srv", tmpDir)))))
 
+	var requests, connections int32
+	counted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		router.ServeHTTP(w, r)
+	})
+
 	// serve out of tmpDir, setup will change content of the Pkg to match the ad-hoc server set up here
-	server := httptest.NewServer(router)
+	server := httptest.NewUnstartedServer(counted)
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
 	defer server.Close()
 
 	pkg := setup(suite, tmpDir, server.URL)
@@ -169,5 +186,479 @@ func Test_PkgFetch_Suite(suite *testing.T) {
 		assert.Contains(t, pkgs, abs)
 	})
 
+	suite.Run("PkgFetchWithOptions returns Pkg and meta path", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		result, err := PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), destinationDir, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, pkgID, result.Pkg.ID)
+		assert.Equal(t, path.Join(destinationDir, fmt.Sprintf("%s.json", pkgID)), result.MetaPath)
+		assert.EqualValues(t, 2, len(result.PartPaths))
+
+		// the sha256 of each part is that it was verified against
+		assert.Equal(t, len(pkg.Parts), len(result.Sha256sums))
+		for name, part := range pkg.Parts {
+			assert.Equal(t, part.Sha256sum, result.Sha256sums[name], name)
+		}
+	})
+
+	suite.Run("PkgPlan reports fetched parts as skipped and others to download", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		plan, err := PkgPlan(fakeHTTPClientFactory, *ur, string(sigBytes), destinationDir, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(plan.Parts))
+		assert.EqualValues(t, 0, plan.DownloadBytes)
+		for _, partPlan := range plan.Parts {
+			assert.Equal(t, SKIP, partPlan.Action)
+		}
+
+		emptyDir := path.Join(tmpDir, "plan-destination")
+		plan, err = PkgPlan(fakeHTTPClientFactory, *ur, string(sigBytes), emptyDir, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+		for _, partPlan := range plan.Parts {
+			assert.Equal(t, DOWNLOAD, partPlan.Action)
+		}
+
+		_, err = os.Stat(emptyDir)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	suite.Run("ParseAndVerifyMeta returns the verified Pkg, writing its meta only if asked", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		metaDir := path.Join(tmpDir, "meta-destination")
+
+		metaPkg, metaPath, err := ParseAndVerifyMeta(fakeHTTPClientFactory, *ur, string(sigBytes), metaDir, false, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, pkgID, metaPkg.ID)
+		assert.EqualValues(t, 2, len(metaPkg.Parts))
+		assert.Empty(t, metaPath)
+
+		_, err = os.Stat(metaDir)
+		assert.True(t, os.IsNotExist(err))
+
+		_, metaPath, err = ParseAndVerifyMeta(fakeHTTPClientFactory, *ur, string(sigBytes), metaDir, true, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(metaDir, fmt.Sprintf("%s.json", pkgID)), metaPath)
+
+		_, err = os.Stat(metaPath)
+		assert.Nil(t, err)
+
+		_, _, err = ParseAndVerifyMeta(fakeHTTPClientFactory, *ur, "bogus", metaDir, false, "", keysDir, emptyAuth, Options{})
+		assert.NotNil(t, err)
+	})
+
+	suite.Run("PkgFetchWithMeta fetches the parts of a Pkg whose meta the caller verified", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		verified, _, err := ParseAndVerifyMeta(fakeHTTPClientFactory, *ur, string(sigBytes), "", false, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+
+		withMetaDir := path.Join(tmpDir, "with-meta-destination")
+		_, err = PkgFetchWithMeta(fakeHTTPClientFactory, verified, false, *ur, withMetaDir, "", keysDir, emptyAuth, Options{})
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+
+		result, err := PkgFetchWithMeta(fakeHTTPClientFactory, verified, true, *ur, withMetaDir, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+		assert.Empty(t, result.MetaPath)
+		assert.EqualValues(t, 2, len(result.PartPaths))
+		assert.Equal(t, pkgID, result.Pkg.ID)
+
+		// no meta was written
+		_, err = os.Stat(path.Join(withMetaDir, fmt.Sprintf("%s.json", pkgID)))
+		assert.True(t, os.IsNotExist(err))
+
+		// parts are still verified
+		for id, part := range verified.Parts {
+			part.Signatures = []string{"bogus"}
+			verified.Parts[id] = part
+		}
+		_, err = PkgFetchWithMeta(fakeHTTPClientFactory, verified, true, *ur, path.Join(tmpDir, "with-bad-meta-destination"), "", keysDir, emptyAuth, Options{})
+		assert.NotNil(t, err)
+	})
+
+	suite.Run("PkgFetch fetches Pkg from file URL with a mix of file and http part sources", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("file://%s/srv/%s.json", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		fileDestinationDir := path.Join(tmpDir, "file-destination")
+		pkgs, err := PkgFetch(fakeHTTPClientFactory, *ur, string(sigBytes), fileDestinationDir, "", keysDir, emptyAuth)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(pkgs))
+	})
+
+	suite.Run("PkgFetchWithOptions fetches only the selected parts", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		var id string
+		for id, _ = range pkg.Parts {
+			break
+		}
+
+		selectedDestinationDir := path.Join(tmpDir, "selected-destination")
+		result, err := PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), selectedDestinationDir, "", keysDir, emptyAuth, Options{PartIDs: []string{id}})
+		assert.Nil(t, err)
+
+		abs, err := filepath.Abs(path.Join(selectedDestinationDir, pkg.ID, id))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{abs}, result.PartPaths)
+
+		_, err = PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), selectedDestinationDir, "", keysDir, emptyAuth, Options{PartIDs: []string{"nonexistent"}})
+		assert.IsType(t, fetcherrors.PkgPrecheckError{}, err)
+	})
+
+	suite.Run("Fetcher fetches with its configuration and honors canceled context", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		fetcher := NewFetcher(WithHTTPClientFactory(fakeHTTPClientFactory), WithSigningKeys("", keysDir))
+
+		result, err := fetcher.Fetch(context.Background(), *ur, string(sigBytes), path.Join(tmpDir, "fetcher-destination"))
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(result.PartPaths))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err = fetcher.Fetch(ctx, *ur, string(sigBytes), path.Join(tmpDir, "canceled-destination"))
+		assert.NotNil(t, err)
+	})
+
+	suite.Run("Fetcher streams parts as they are fetched", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		fetcher := NewFetcher(WithHTTPClientFactory(fakeHTTPClientFactory), WithSigningKeys("", keysDir))
+		stream := fetcher.FetchStream(context.Background(), *ur, string(sigBytes), path.Join(tmpDir, "stream-destination"))
+
+		streamed := make(map[string]string)
+		for part := range stream.Parts() {
+			assert.Nil(t, part.Err)
+			assert.NotEmpty(t, part.Sha256sum)
+			streamed[part.ID] = part.Path
+		}
+
+		result, err := stream.Result()
+		assert.Nil(t, err)
+		assert.Equal(t, result.Parts, streamed)
+	})
+
+	suite.Run("PkgFetchWithOptions gives up when its Timeout passes", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		_, err = PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), path.Join(tmpDir, "deadline-destination"), "", keysDir, emptyAuth, Options{Timeout: time.Nanosecond})
+		assert.IsType(t, fetcherrors.PkgFetchDeadlineError{}, err)
+
+		result, err := PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), path.Join(tmpDir, "deadline-destination"), "", keysDir, emptyAuth, Options{Timeout: time.Minute})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(result.PartPaths))
+	})
+
+	suite.Run("PkgFetchWithOptions dumps fetch for debugging", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		dumpDestinationDir := path.Join(tmpDir, "dump-destination")
+
+		for _, outcome := range []string{dumpFetched, dumpSkipped} {
+			var out strings.Builder
+			_, err = PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), dumpDestinationDir, "", keysDir, emptyAuth, Options{DebugDump: true, DebugDumpWriter: &out})
+			assert.Nil(t, err)
+
+			var dump debugDump
+			assert.Nil(t, json.Unmarshal([]byte(out.String()), &dump))
+			assert.NotEmpty(t, dump.Meta)
+			assert.EqualValues(t, 2, len(dump.Parts))
+			for _, part := range dump.Parts {
+				assert.Equal(t, outcome, part.Outcome)
+				assert.Equal(t, part.Sha256sum, part.ActualHash)
+			}
+		}
+	})
+
+	suite.Run("PkgFetch rejects Pkg with part names escaping its directory", func(t *testing.T) {
+		traversal := *pkg
+		traversal.Parts = horizonpkg.DockerImageParts{}
+		for name, part := range pkg.Parts {
+			traversal.Parts[name] = part
+		}
+		for name, part := range pkg.Parts {
+			traversal.Parts["../../escaped"] = part
+			delete(traversal.Parts, name)
+			break
+		}
+
+		bytes, err := json.Marshal(traversal)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(fmt.Sprintf("%s/srv/traversal.json", tmpDir), bytes, 0666))
+
+		sig, err := sign.Input(fmt.Sprintf("%s/keys/private/private.key", testMaterialDirName), bytes)
+		assert.Nil(t, err)
+
+		ur, err := url.Parse(fmt.Sprintf("%s%s/traversal.json", server.URL, urlPath))
+		assert.Nil(t, err)
+
+		traversalDir := path.Join(tmpDir, "traversal", "destination")
+		_, err = PkgFetch(fakeHTTPClientFactory, *ur, sig, traversalDir, "", keysDir, emptyAuth)
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+		assert.Contains(t, err.Error(), "../../escaped")
+
+		_, err = os.Stat(path.Join(tmpDir, "escaped"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	suite.Run("Fetcher calls part lifecycle hooks", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		var lock sync.Mutex
+		started := make(map[string]int64)
+		completed := make(map[string]string)
+
+		fetcher := NewFetcher(WithHTTPClientFactory(fakeHTTPClientFactory), WithSigningKeys("", keysDir), WithHooks(&PartHooks{
+			OnPartStart: func(partID string, bytes int64) {
+				lock.Lock()
+				defer lock.Unlock()
+				started[partID] = bytes
+			},
+			OnPartComplete: func(partID string, partPath string, duration time.Duration, source string) {
+				lock.Lock()
+				defer lock.Unlock()
+				completed[partID] = source
+			},
+			OnPartError: func(partID string, err error, attempt int) {
+				t.Errorf("Unexpected error fetching part %v: %v", partID, err)
+			},
+		}))
+
+		hooksDestinationDir := path.Join(tmpDir, "hooks-destination")
+		_, err = fetcher.Fetch(context.Background(), *ur, string(sigBytes), hooksDestinationDir)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, 2, len(started))
+		assert.EqualValues(t, 2, len(completed))
+		for id, part := range pkg.Parts {
+			assert.Equal(t, part.Bytes, started[id])
+			assert.NotEmpty(t, completed[id])
+		}
+
+		// parts already on disk have no source
+		_, err = fetcher.Fetch(context.Background(), *ur, string(sigBytes), hooksDestinationDir)
+		assert.Nil(t, err)
+		for _, source := range completed {
+			assert.Empty(t, source)
+		}
+	})
+
+	suite.Run("PkgFetchWithOptions aborts before fetching parts if BeforeFetch rejects the Pkg", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		var checked *horizonpkg.Pkg
+		rejection := fmt.Errorf("image not allowed")
+		opts := Options{
+			BeforeFetch: func(pkg *horizonpkg.Pkg) error {
+				checked = pkg
+				return rejection
+			},
+		}
+
+		rejectedDestinationDir := path.Join(tmpDir, "rejected-destination")
+		_, err = PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), rejectedDestinationDir, "", keysDir, emptyAuth, opts)
+		assert.IsType(t, fetcherrors.PkgRejectedError{}, err)
+		assert.Equal(t, rejection, err.(fetcherrors.PkgRejectedError).InternalError)
+
+		assert.NotNil(t, checked)
+		assert.Equal(t, pkgID, checked.ID)
+
+		// no part was fetched
+		_, err = os.Stat(path.Join(rejectedDestinationDir, pkgID))
+		assert.True(t, os.IsNotExist(err))
+
+		// the Pkg is fetched once accepted
+		opts.BeforeFetch = func(pkg *horizonpkg.Pkg) error { return nil }
+		_, err = PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), rejectedDestinationDir, "", keysDir, emptyAuth, opts)
+		assert.Nil(t, err)
+	})
+
+	suite.Run("PkgVerify reports parts on disk without changing them", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		verifyDestinationDir := path.Join(tmpDir, "verify-destination")
+		_, err = PkgFetch(fakeHTTPClientFactory, *ur, string(sigBytes), verifyDestinationDir, "", keysDir, emptyAuth)
+		assert.Nil(t, err)
+
+		report, err := PkgVerify(pkgID, string(sigBytes), verifyDestinationDir, "", keysDir, Options{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(report.Parts))
+		assert.Empty(t, report.Failed())
+
+		var id string
+		for id, _ = range pkg.Parts {
+			break
+		}
+
+		// detached part signatures are used in place of those in the meta, and the meta's in place of the pkgURLSignature
+		report, err = PkgVerify(pkgID, "", verifyDestinationDir, "", keysDir, Options{MetaSignatures: []string{string(sigBytes)}, PartSignatures: map[string][]string{id: {"bogus"}}})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{id}, report.Failed())
+
+		partPath := path.Join(verifyDestinationDir, pkgID, id)
+		assert.Nil(t, ioutil.WriteFile(partPath, []byte("corrupt"), 0600))
+
+		report, err = PkgVerify(pkgID, string(sigBytes), verifyDestinationDir, "", keysDir, Options{})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{id}, report.Failed())
+
+		_, err = os.Stat(partPath)
+		assert.Nil(t, err)
+
+		assert.Nil(t, os.Remove(partPath))
+		_, err = PkgVerify(pkgID, string(sigBytes), verifyDestinationDir, "", keysDir, Options{})
+		assert.IsType(t, fetcherrors.PkgSourceError{}, err)
+
+		// meta changed on disk since it was fetched is detected
+		metaPath := path.Join(verifyDestinationDir, fmt.Sprintf("%s.json", pkgID))
+		meta, err := ioutil.ReadFile(metaPath)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(metaPath, append(meta, ' '), 0600))
+
+		_, err = PkgVerify(pkgID, string(sigBytes), verifyDestinationDir, "", keysDir, Options{})
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+		assert.Contains(t, err.Error(), "changed since it was fetched")
+	})
+
+	suite.Run("FetchMany fetches Pkgs concurrently, downloading shared parts once", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		atomic.StoreInt32(&requests, 0)
+
+		fetcher := NewFetcher(WithHTTPClientFactory(fakeHTTPClientFactory), WithSigningKeys("", keysDir), WithOptions(Options{MaxConcurrentParts: 1}))
+		responses := fetcher.FetchMany(context.Background(), []PkgRequest{
+			{*ur, string(sigBytes), path.Join(tmpDir, "many-destination-a")},
+			{*ur, string(sigBytes), path.Join(tmpDir, "many-destination-b")},
+		})
+
+		assert.EqualValues(t, 2, len(responses))
+		for _, response := range responses {
+			assert.Nil(t, response.Err)
+			assert.EqualValues(t, 2, len(response.Result.PartPaths))
+		}
+
+		// both metas and each part once
+		assert.EqualValues(t, 4, atomic.LoadInt32(&requests))
+	})
+
+	suite.Run("PkgFetchWithOptions reports bytes downloaded and reused", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		var total int64
+		for _, part := range pkg.Parts {
+			total += part.Bytes
+		}
+
+		statsDestinationDir := path.Join(tmpDir, "stats-destination")
+		result, err := PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), statsDestinationDir, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+		assert.Equal(t, total, result.BytesDownloaded)
+		assert.EqualValues(t, 0, result.BytesReused)
+
+		downloaded := result
+
+		result, err = PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), statsDestinationDir, "", keysDir, emptyAuth, Options{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 0, result.BytesDownloaded)
+		assert.Equal(t, total, result.BytesReused)
+
+		// the content is the same however it was fetched
+		assert.NotEmpty(t, result.ContentDigest)
+		assert.Equal(t, downloaded.ContentDigest, result.ContentDigest)
+	})
+
+	suite.Run("PkgFetchWithOptions verifies all parts with a single verifier", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		result, err := PkgFetchWithOptions(fakeHTTPClientFactory, *ur, string(sigBytes), path.Join(tmpDir, "verifier-destination"), "", keysDir, emptyAuth, Options{VerifyConcurrency: 1})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(result.PartPaths))
+	})
+
+	suite.Run("PkgFetch reuses connections across meta and part fetches", func(t *testing.T) {
+		ur, err := url.Parse(fmt.Sprintf("%s%s/%s.json", server.URL, urlPath, pkgID))
+		assert.Nil(t, err)
+
+		sigBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/srv/%s.json.sig", tmpDir, pkgID))
+		assert.Nil(t, err)
+
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&connections, 0)
+
+		_, err = PkgFetch(fakeHTTPClientFactory, *ur, string(sigBytes), path.Join(tmpDir, "pooled-destination"), "", keysDir, emptyAuth)
+		assert.Nil(t, err)
+
+		// meta and both parts are fetched from the server
+		assert.EqualValues(t, 3, atomic.LoadInt32(&requests))
+		assert.True(t, atomic.LoadInt32(&connections) < atomic.LoadInt32(&requests), "Expected fewer connections than requests, got %v connections", atomic.LoadInt32(&connections))
+	})
+
 	// TODO: expand these cases, test the edges
 }
@@ -0,0 +1,392 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMetaTimeout is the timeout of requests made by the default client;
+// part fetches have their own timeouts, see Options.PartTimeout
+const defaultMetaTimeout = 2 * time.Minute
+
+// Fetcher fetches and verifies Pkgs using configuration shared by all of its
+// fetches. Create one with NewFetcher and Close it once it's no longer
+// needed.
+type Fetcher struct {
+	httpClientFactory func(overrideTimeoutS *uint) *http.Client
+	primarySigningKey string
+	userKeysDir       string
+	authCreds         map[string]map[string]string
+	opts              Options
+
+	// the sessions of fetches in progress, set closed by Close
+	lock     sync.Mutex
+	sessions map[*fetchSession]bool
+	closed   bool
+}
+
+// FetcherOption configures a Fetcher
+type FetcherOption func(*Fetcher)
+
+// WithHTTPClientFactory sets the factory of the client used for each fetch;
+// see PkgFetch. By default a client with a two minute timeout is used.
+func WithHTTPClientFactory(httpClientFactory func(overrideTimeoutS *uint) *http.Client) FetcherOption {
+	return func(f *Fetcher) {
+		f.httpClientFactory = httpClientFactory
+	}
+}
+
+// WithSigningKeys sets the keys with which Pkg meta and parts are verified
+func WithSigningKeys(primarySigningKey string, userKeysDir string) FetcherOption {
+	return func(f *Fetcher) {
+		f.primarySigningKey = primarySigningKey
+		f.userKeysDir = userKeysDir
+	}
+}
+
+// WithAuthCreds sets the credentials sent to sources, keyed by URL prefix
+func WithAuthCreds(authCreds map[string]map[string]string) FetcherOption {
+	return func(f *Fetcher) {
+		f.authCreds = authCreds
+	}
+}
+
+// WithOptions sets the Options applied to each fetch, replacing those set by
+// earlier FetcherOptions
+func WithOptions(opts Options) FetcherOption {
+	return func(f *Fetcher) {
+		f.opts = opts
+	}
+}
+
+// WithLogger sets the Logger that receives the log output of each fetch
+func WithLogger(logger Logger) FetcherOption {
+	return func(f *Fetcher) {
+		f.opts.Logger = logger
+	}
+}
+
+// WithMetrics sets the MetricsSink that receives measurements of each fetch
+func WithMetrics(metrics MetricsSink) FetcherOption {
+	return func(f *Fetcher) {
+		f.opts.Metrics = metrics
+	}
+}
+
+// WithHooks sets the hooks called at points in the lifecycle of each part
+func WithHooks(hooks *PartHooks) FetcherOption {
+	return func(f *Fetcher) {
+		f.opts.Hooks = hooks
+	}
+}
+
+// WithRetryPolicy sets the Retrier of part sources, e.g. a RetryPolicy
+func WithRetryPolicy(retry Retrier) FetcherOption {
+	return func(f *Fetcher) {
+		f.opts.Retry = retry
+	}
+}
+
+// WithSourceSelection sets how the order in which a part's sources are tried
+// is chosen
+func WithSourceSelection(selection SourceSelection) FetcherOption {
+	return func(f *Fetcher) {
+		f.opts.SourceSelection = selection
+	}
+}
+
+// NewFetcher returns a Fetcher configured with the given FetcherOptions,
+// applied in order
+func NewFetcher(fetcherOpts ...FetcherOption) *Fetcher {
+	f := &Fetcher{
+		httpClientFactory: defaultHTTPClientFactory,
+		authCreds:         map[string]map[string]string{},
+	}
+
+	for _, opt := range fetcherOpts {
+		opt(f)
+	}
+
+	if f.httpClientFactory == nil {
+		f.httpClientFactory = defaultHTTPClientFactory
+	}
+
+	return f
+}
+
+func defaultHTTPClientFactory(overrideTimeoutS *uint) *http.Client {
+	timeout := defaultMetaTimeout
+	if overrideTimeoutS != nil {
+		timeout = time.Duration(*overrideTimeoutS) * time.Second
+	}
+
+	return &http.Client{Timeout: timeout}
+}
+
+// Fetch fetches the pkg metadata file at pkgURL into destinationDir and then
+// fetches and verifies the pkg's parts; see PkgFetch. Canceling ctx aborts
+// the fetch.
+func (f *Fetcher) Fetch(ctx context.Context, pkgURL url.URL, pkgURLSignature string, destinationDir string) (*FetchResult, error) {
+	session := newFetchSession(f.opts)
+	client, err := f.configureClient(session)
+	if err != nil {
+		return nil, fetcherrors.PkgSourceError{"Failed configuring HTTP client", err}
+	}
+	defer f.release(session)
+
+	return f.fetch(ctx, client, pkgURL, pkgURLSignature, destinationDir, session)
+}
+
+// FetchWithMeta fetches and verifies the parts of pkg into destinationDir
+// like Fetch but without fetching its meta, which the caller must already
+// have verified, e.g. with ParseAndVerifyMeta in an earlier stage of a
+// pipeline; metaVerified asserts that it has been and must be true. Part
+// sources with absolute paths are relative to pkgURL, the URL the meta was
+// fetched from. The parts are still verified against their hashes and
+// signatures but no meta file is written, so the result's MetaPath is empty.
+func (f *Fetcher) FetchWithMeta(ctx context.Context, pkg *horizonpkg.Pkg, metaVerified bool, pkgURL url.URL, destinationDir string) (*FetchResult, error) {
+	if !metaVerified {
+		return nil, fetcherrors.PkgMetaError{"Only Pkgs whose meta was verified can be fetched without fetching their meta", fmt.Errorf("Pkg %v is not verified", pkg.ID)}
+	}
+
+	session := newFetchSession(f.opts)
+	session.trustedPkg = pkg
+
+	client, err := f.configureClient(session)
+	if err != nil {
+		return nil, fetcherrors.PkgSourceError{"Failed configuring HTTP client", err}
+	}
+	defer f.release(session)
+
+	return f.fetch(ctx, client, pkgURL, "", destinationDir, session)
+}
+
+// PkgRequest identifies a Pkg to fetch with FetchMany; its fields are as the
+// arguments of Fetch
+type PkgRequest struct {
+	PkgURL          url.URL
+	PkgURLSignature string
+	DestinationDir  string
+}
+
+// PkgResponse is the outcome of fetching a single Pkg with FetchMany
+type PkgResponse struct {
+	Result *FetchResult
+	Err    error
+}
+
+// FetchMany fetches the requested Pkgs concurrently, returning the outcome of
+// each in the order requested. The fetches share one HTTP client, part
+// concurrency limit (Options.MaxConcurrentParts) and bandwidth limit
+// (Options.BytesPerSecond); parts with the same content in several Pkgs are
+// downloaded only once. Each Pkg should be requested only once.
+func (f *Fetcher) FetchMany(ctx context.Context, requests []PkgRequest) []PkgResponse {
+	responses := make([]PkgResponse, len(requests))
+
+	session := newFetchSession(f.opts)
+	client, err := f.configureClient(session)
+	if err != nil {
+		for ix := range responses {
+			responses[ix].Err = fetcherrors.PkgSourceError{"Failed configuring HTTP client", err}
+		}
+		return responses
+	}
+	defer f.release(session)
+
+	var group sync.WaitGroup
+
+	for ix, request := range requests {
+		group.Add(1)
+
+		go func(ix int, request PkgRequest) {
+			defer group.Done()
+
+			result, err := f.fetch(ctx, client, request.PkgURL, request.PkgURLSignature, request.DestinationDir, session.forPkg())
+			responses[ix] = PkgResponse{result, err}
+		}(ix, request)
+	}
+
+	group.Wait()
+	return responses
+}
+
+// errFetcherClosed is the error of fetches made with a closed Fetcher
+var errFetcherClosed = errors.New("Fetcher is closed")
+
+// configureClient returns the client of a fetch made with the session,
+// configured by it, unless the fetcher is closed. release must be called with
+// the session once the fetch is done.
+func (f *Fetcher) configureClient(session *fetchSession) (*http.Client, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return nil, errFetcherClosed
+	}
+
+	client, err := session.configureClient(f.httpClientFactory(nil), f.authCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.sessions == nil {
+		f.sessions = make(map[*fetchSession]bool)
+	}
+	f.sessions[session] = true
+	return client, nil
+}
+
+// release closes the idle connections of a session whose fetch is done,
+// which no other fetch reuses
+func (f *Fetcher) release(session *fetchSession) {
+	f.lock.Lock()
+	delete(f.sessions, session)
+	f.lock.Unlock()
+
+	session.closeIdleConnections()
+}
+
+// Close releases the resources held by the fetcher: the idle connections of
+// fetches in progress are closed, as are those of each once it's done.
+// Fetches in progress aren't aborted, cancel their contexts to do so. The
+// fetcher must not be used after it's closed; its fetches fail.
+func (f *Fetcher) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.closed = true
+	for session := range f.sessions {
+		session.closeIdleConnections()
+	}
+	return nil
+}
+
+// fetch fetches a single Pkg with the given client, which must have been
+// configured by the session, within the session's Timeout if it has one,
+// dumping the fetch for debugging if enabled
+func (f *Fetcher) fetch(ctx context.Context, client *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, session *fetchSession) (*FetchResult, error) {
+	if session.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, session.opts.Timeout)
+		defer cancel()
+	}
+
+	if session.opts.DebugDump {
+		session.dump = newDebugDump(pkgURL.String(), f.authCreds)
+	}
+
+	result, err := f.fetchPkg(ctx, client, pkgURL, pkgURLSignature, destinationDir, session)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fetcherrors.PkgFetchDeadlineError{fmt.Sprintf("Deadline exceeded fetching Pkg from %v", pkgURL.String()), err}
+	}
+
+	session.dump.write(err, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (f *Fetcher) fetchPkg(ctx context.Context, client *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, session *fetchSession) (*FetchResult, error) {
+	mkdirs := func(pp string) error {
+		if err := session.fs.MkdirAll(pp, session.dirMode()); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// unusable keys are reported before anything is requested or written
+	if session.opts.ValidateSigningKeys {
+		if err := session.keys.validate(session.primarySigningKeys(f.primarySigningKey), f.userKeysDir, session.opts.InsecureSkipSignatureVerification); err != nil {
+			return nil, err
+		}
+	}
+
+	// the meta of a trusted Pkg isn't fetched, nor is there a signature of it
+	if session.trustedPkg == nil {
+		if err := session.requireSignature(pkgURLSignature); err != nil {
+			return nil, err
+		}
+	}
+
+	pkgURL = localPkgURL(pkgURL)
+
+	// make pkg subdirectory in destination directory
+	if err := mkdirs(destinationDir); err != nil {
+		return nil, fetcherrors.PkgSourceError{"Failed creating Pkg destination dirs on host", err}
+	}
+
+	var pkg *horizonpkg.Pkg
+	var metaPath string
+	var err error
+	if session.trustedPkg != nil {
+		pkg, err = session.trustPkg()
+	} else {
+		pkg, metaPath, err = fetchPkgMeta(ctx, client, f.authCreds, f.primarySigningKey, f.userKeysDir, pkgURL.String(), pkgURLSignature, destinationDir, true, session)
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.pkgID = pkg.ID
+
+	// we do this separately so we have a greater chance of the async fetches succeeding before we start them all
+	parts, err := precheckPkgParts(pkg, session.opts.PartIDs, session)
+	if err != nil {
+		return nil, fetcherrors.PkgPrecheckError{"Failed to validate Pkg information before fetching", err}
+	}
+	session.dump.setParts(parts, session.partFiles)
+
+	if session.opts.BeforeFetch != nil {
+		if err := session.opts.BeforeFetch(pkg); err != nil {
+			return nil, fetcherrors.PkgRejectedError{fmt.Sprintf("Pkg %v was rejected before fetching its parts", pkg.ID), err}
+		}
+	}
+
+	// parts written to a Destination need no directory on the host
+	pkgDestinationDir := path.Join(destinationDir, pkg.ID)
+	if session.opts.Destination == nil {
+		if err := mkdirs(pkgDestinationDir); err != nil {
+			return nil, fetcherrors.PkgSourceError{"Failed creating Pkg destination dirs on host", err}
+		}
+	}
+
+	pkgURLBase := baseURL(pkgURL)
+
+	session.log.Infof(4, "Extracted pkgURLBase %v from pkgURL %v", pkgURLBase, pkgURL.String())
+
+	if session.opts.HeadPrecheck {
+		if err := headPrecheckParts(ctx, client, f.authCreds, pkgURLBase, parts, session); err != nil {
+			return nil, fetcherrors.PkgPrecheckError{"Failed to validate Pkg part sources before fetching", err}
+		}
+	}
+
+	var fetched []string
+	fetched, err = fetchAndVerify(ctx, client, f.authCreds, pkgURLBase, parts, pkgDestinationDir, f.primarySigningKey, f.userKeysDir, session)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: expand to return the .fetch file; also shortcut some fetch operations if it exists
+
+	return &FetchResult{
+		Pkg:             pkg,
+		MetaPath:        metaPath,
+		PartPaths:       fetched,
+		Parts:           session.fetchedParts,
+		Sources:         session.fetchedSources(),
+		Sha256sums:      session.fetchedSums,
+		ContentDigest:   contentDigest(session.fetchedSums),
+		BytesDownloaded: atomic.LoadInt64(&session.downloadedBytes),
+		BytesReused:     atomic.LoadInt64(&session.reusedBytes),
+	}, nil
+}
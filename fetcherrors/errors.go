@@ -2,6 +2,8 @@ package fetcherrors
 
 import (
 	"fmt"
+	"strings"
+	"time"
 )
 
 // PkgMetaError indicates an error fetching, verifying and using a Pkg meta
@@ -31,6 +33,34 @@ func (e PkgPrecheckError) Error() string {
 	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
 }
 
+// PkgRejectedError indicates that a Pkg was refused by the caller's
+// Options.BeforeFetch callback after its meta was verified; none of its parts
+// were fetched. InternalError is the error returned by the callback.
+type PkgRejectedError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error).
+func (e PkgRejectedError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
+
+// PkgRollbackError indicates that verified Pkg meta was refused because it is
+// older than the caller has already seen, e.g. because an old signed Pkg was
+// replayed to roll back to stale images. See Options.MinSequence.
+type PkgRollbackError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error).
+func (e PkgRollbackError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
+
 // PkgSourceFetchAuthError indicates either an authentication or
 // authorization error when fetching a Pkg from sources. It is expected to be
 // returned only if all sources fail to fetch not for any single of multiple
@@ -40,12 +70,17 @@ func (e PkgPrecheckError) Error() string {
 type PkgSourceFetchAuthError struct {
 	Msg           string
 	InternalError error
+
+	// Sources are the outcomes of the attempts to fetch the part from each
+	// of the sources tried, in the order they were tried
+	Sources []SourceOutcome
 }
 
 // Error provides a loggable error message including the message of an
-// internal error (one enclosed in this error)
+// internal error (one enclosed in this error) and the outcomes of the
+// attempts to fetch from each source
 func (e PkgSourceFetchAuthError) Error() string {
-	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+	return fmt.Sprintf("%v. InternalError: %v%v", e.Msg, e.InternalError, describeSources(e.Sources))
 }
 
 // PkgSourceFetchError indicates a generic (non-auth) error fetching a part
@@ -56,11 +91,162 @@ func (e PkgSourceFetchAuthError) Error() string {
 type PkgSourceFetchError struct {
 	Msg           string
 	InternalError error
+
+	// Sources are the outcomes of the attempts to fetch the part from each
+	// of the sources tried, in the order they were tried
+	Sources []SourceOutcome
 }
 
 // Error provides a loggable error message including the message of an
-// internal error (one enclosed in this error)
+// internal error (one enclosed in this error) and the outcomes of the
+// attempts to fetch from each source
 func (e PkgSourceFetchError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v%v", e.Msg, e.InternalError, describeSources(e.Sources))
+}
+
+// PkgPartsError indicates that some of a Pkg's parts failed to be fetched or
+// verified. InternalError joins the errors of each failed part so they can be
+// inspected with errors.Is and errors.As.
+type PkgPartsError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the messages of the
+// errors of each failed part
+func (e PkgPartsError) Error() string {
+	var msgs []string
+	for _, err := range e.Unwrap() {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("%v. Errors: [%v]", e.Msg, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of each failed part
+func (e PkgPartsError) Unwrap() []error {
+	if joined, ok := e.InternalError.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+
+	if e.InternalError == nil {
+		return nil
+	}
+
+	return []error{e.InternalError}
+}
+
+// SourceOutcome describes a failed attempt to fetch a part from one source.
+type SourceOutcome struct {
+	URL string
+
+	// HTTPStatusCode is the status of the source's response, 0 if it didn't
+	// respond
+	HTTPStatusCode int
+
+	// Err is the error fetching from the source, nil if it's described by
+	// HTTPStatusCode alone
+	Err error
+
+	// Duration is the time spent on the source, including any retries
+	Duration time.Duration
+}
+
+// String describes the outcome for logging
+func (o SourceOutcome) String() string {
+	var failure string
+	switch {
+	case o.Err != nil:
+		failure = o.Err.Error()
+	case o.HTTPStatusCode != 0:
+		failure = fmt.Sprintf("HTTP status code: %v", o.HTTPStatusCode)
+	default:
+		failure = "failed"
+	}
+
+	return fmt.Sprintf("%v: %v (after %v)", o.URL, failure, o.Duration)
+}
+
+func describeSources(sources []SourceOutcome) string {
+	if len(sources) == 0 {
+		return ""
+	}
+
+	described := make([]string, len(sources))
+	for ix, source := range sources {
+		described[ix] = source.String()
+	}
+
+	return fmt.Sprintf(". Sources tried: [%v]", strings.Join(described, "; "))
+}
+
+// PkgSourceContentLengthError indicates that a source responded with a
+// Content-Length header that doesn't match the size of the part given in the
+// Pkg meta. The source is rejected before its content is downloaded.
+type PkgSourceContentLengthError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error)
+func (e PkgSourceContentLengthError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
+
+// PkgSourceSizeError indicates that the content downloaded from a source
+// doesn't match the size of the part given in the Pkg meta. Unlike
+// PkgSourceContentLengthError, this is detected after download.
+type PkgSourceSizeError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error)
+func (e PkgSourceSizeError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
+
+// PkgSourceStalledError indicates that a download from a source was aborted
+// because no bytes were received within the configured idle window. This is
+// distinct from a timeout of the whole download.
+type PkgSourceStalledError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error)
+func (e PkgSourceStalledError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
+
+// PkgSourceInterruptedError indicates that a download from a source ended
+// before all of a part's bytes were received, e.g. because the connection
+// was dropped mid-stream
+type PkgSourceInterruptedError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error)
+func (e PkgSourceInterruptedError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
+
+// PkgSourceDisallowedError indicates that a part couldn't be fetched because
+// none of its sources is on a host permitted by the fetch's allowed and
+// denied hosts, or a source redirected to a host that isn't
+type PkgSourceDisallowedError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error)
+func (e PkgSourceDisallowedError) Error() string {
 	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
 }
 
@@ -91,3 +277,44 @@ type PkgSignatureVerificationError struct {
 func (e PkgSignatureVerificationError) Error() string {
 	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
 }
+
+// PkgBudgetExceededError indicates that a fetch was refused or aborted
+// because it would download, or had downloaded, more bytes of part content
+// than its budget. BytesDownloaded is the number of bytes it downloaded.
+type PkgBudgetExceededError struct {
+	Msg             string
+	InternalError   error
+	BytesDownloaded int64
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error)
+func (e PkgBudgetExceededError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
+
+// PkgSigningKeyError indicates that the signing keys with which a Pkg is to
+// be verified can't be loaded; nothing was fetched.
+type PkgSigningKeyError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error)
+func (e PkgSigningKeyError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
+
+// PkgFetchDeadlineError indicates that a Pkg fetch was abandoned because its
+// overall deadline passed before the Pkg was fetched and verified.
+type PkgFetchDeadlineError struct {
+	Msg           string
+	InternalError error
+}
+
+// Error provides a loggable error message including the message of an
+// internal error (one enclosed in this error).
+func (e PkgFetchDeadlineError) Error() string {
+	return fmt.Sprintf("%v. InternalError: %v", e.Msg, e.InternalError)
+}
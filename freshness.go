@@ -0,0 +1,21 @@
+package fetch
+
+import (
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+)
+
+// checkFreshness returns a PkgRollbackError if the verified meta of pkg, read
+// from source, is older than the session's MinSequence or MinCreateTS
+func (s *fetchSession) checkFreshness(pkg *horizonpkg.Pkg, source string) error {
+	if min := s.opts.MinSequence; min > 0 && pkg.Meta.Sequence < min {
+		return fetcherrors.PkgRollbackError{fmt.Sprintf("Pkg meta from %v is older than the last seen", source), fmt.Errorf("Pkg %v has sequence %v, expected at least %v", pkg.ID, pkg.Meta.Sequence, min)}
+	}
+
+	if min := s.opts.MinCreateTS; min > 0 && pkg.Meta.CreateTS < min {
+		return fetcherrors.PkgRollbackError{fmt.Sprintf("Pkg meta from %v is older than the last seen", source), fmt.Errorf("Pkg %v was created at %v, expected no earlier than %v", pkg.ID, pkg.Meta.CreateTS, min)}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package fetch
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// File is a file opened in a FileSystem
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// FileSystem is the filesystem into which Pkgs are fetched. OSFileSystem is
+// used by default; others may be injected with Options.FileSystem, for
+// instance to test error handling.
+type FileSystem interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	Rename(oldpath string, newpath string) error
+	Link(oldname string, newname string) error
+	MkdirAll(path string, perm os.FileMode) error
+	WriteFile(name string, data []byte, perm os.FileMode) error
+}
+
+// OSFileSystem is the FileSystem of the operating system, it delegates to the
+// os package
+type OSFileSystem struct{}
+
+// Open opens the named file for reading
+func (OSFileSystem) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+// OpenFile opens the named file with the given flags and permissions
+func (OSFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+// Stat returns the named file's info
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Remove removes the named file
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Rename renames oldpath to newpath
+func (OSFileSystem) Rename(oldpath string, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Link creates newname as a hard link to oldname
+func (OSFileSystem) Link(oldname string, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+// MkdirAll creates the directory path and any missing parents
+func (OSFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// WriteFile writes data to the named file, creating or truncating it
+func (OSFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
@@ -0,0 +1,291 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// faultyFileSystem fails the operations it has errors for and delegates the
+// others to the OS
+type faultyFileSystem struct {
+	OSFileSystem
+	openFileErr error
+	removeErr   error
+}
+
+func (f faultyFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if f.openFileErr != nil {
+		return nil, f.openFileErr
+	}
+	return f.OSFileSystem.OpenFile(name, flag, perm)
+}
+
+func (f faultyFileSystem) Remove(name string) error {
+	if f.removeErr != nil {
+		return f.removeErr
+	}
+	return f.OSFileSystem.Remove(name)
+}
+
+// openCounter counts the files and response bodies open at once
+type openCounter struct {
+	lock    sync.Mutex
+	opened  int
+	open    int
+	maxOpen int
+}
+
+func (c *openCounter) add() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.opened++
+	c.open++
+	if c.open > c.maxOpen {
+		c.maxOpen = c.open
+	}
+}
+
+func (c *openCounter) remove() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.open--
+}
+
+// countedCloser counts itself closed once
+type countedCloser struct {
+	counter *openCounter
+	once    sync.Once
+}
+
+func (c *countedCloser) close() {
+	c.once.Do(c.counter.remove)
+}
+
+type countedFile struct {
+	File
+	countedCloser
+}
+
+func (f *countedFile) Close() error {
+	f.close()
+	return f.File.Close()
+}
+
+// countingFileSystem counts the files opened in it
+type countingFileSystem struct {
+	OSFileSystem
+	counter *openCounter
+}
+
+func (f countingFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := f.OSFileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	f.counter.add()
+	return &countedFile{file, countedCloser{counter: f.counter}}, nil
+}
+
+type countedBody struct {
+	io.ReadCloser
+	countedCloser
+}
+
+func (b *countedBody) Close() error {
+	b.close()
+	return b.ReadCloser.Close()
+}
+
+// countingTransport counts the response bodies it returns
+type countingTransport struct {
+	counter *openCounter
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.counter.add()
+	response.Body = &countedBody{response.Body, countedCloser{counter: t.counter}}
+	return response, nil
+}
+
+func Test_FileSystem_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-fs-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("short"))
+	}))
+	defer server.Close()
+
+	sources := []horizonpkg.PartSource{{URL: "/part"}}
+
+	suite.Run("part file creation error is returned", func(t *testing.T) {
+		denied := errors.New("permission denied")
+		session := newFetchSession(Options{FileSystem: faultyFileSystem{openFileErr: denied}})
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", path.Join(tmpDir, "denied"), 5, "", sources, session)
+		assert.Equal(t, denied, err)
+	})
+
+	suite.Run("failure removing an incomplete part file is returned", func(t *testing.T) {
+		busy := errors.New("device busy")
+		session := newFetchSession(Options{FileSystem: faultyFileSystem{removeErr: busy}})
+
+		partPath := path.Join(tmpDir, "incomplete")
+		assert.Nil(t, ioutil.WriteFile(partPath, []byte("inc"), 0600))
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", partPath, 5, "", sources, session)
+		assert.Equal(t, busy, err)
+	})
+
+	suite.Run("failure removing a part failing its hash check still reports the mismatch", func(t *testing.T) {
+		session := newFetchSession(Options{FileSystem: faultyFileSystem{removeErr: errors.New("device busy")}})
+
+		partPath := path.Join(tmpDir, "mismatched")
+		assert.Nil(t, ioutil.WriteFile(partPath, []byte("corrupt"), 0600))
+
+		err := verifyPkgPart(context.Background(), "", "", partPath, "0000", nil, nil, session)
+		assert.IsType(t, fetcherrors.PkgSignatureVerificationError{}, err)
+	})
+
+	suite.Run("one part file and response are open at a time however many sources are tried", func(t *testing.T) {
+		complete := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("entire"))
+		}))
+		defer complete.Close()
+
+		files := &openCounter{}
+		responses := &openCounter{}
+		session := newFetchSession(Options{FileSystem: countingFileSystem{counter: files}})
+		client := &http.Client{Transport: countingTransport{responses}}
+
+		// the short sources fail their size check before the last succeeds
+		sources := []horizonpkg.PartSource{{URL: server.URL + "/a"}, {URL: server.URL + "/b"}, {URL: server.URL + "/c"}, {URL: complete.URL + "/part"}}
+		err := fetchPkgPart(context.Background(), client, nil, server.URL, "part", path.Join(tmpDir, "sources"), 6, "", sources, session)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 1, files.maxOpen)
+		assert.Equal(t, 0, files.open)
+		assert.Equal(t, 1, responses.maxOpen)
+		assert.Equal(t, 0, responses.open)
+	})
+
+	suite.Run("failed sources release their response and part file before the next is tried", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+			// flushed without a Content-Length so it's written before it's found short
+			w.Write([]byte("ent"))
+			w.(http.Flusher).Flush()
+		})
+		mux.HandleFunc("/complete", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("entire"))
+		})
+		sourceServer := httptest.NewServer(mux)
+		defer sourceServer.Close()
+
+		files := &openCounter{}
+		responses := &openCounter{}
+		session := newFetchSession(Options{FileSystem: countingFileSystem{counter: files}, Retry: RetryPolicy{MaxAttempts: 1}})
+		client := &http.Client{Transport: countingTransport{responses}}
+
+		partPath := path.Join(tmpDir, "third")
+		sources := []horizonpkg.PartSource{{URL: "/unavailable"}, {URL: "/short"}, {URL: "/complete"}}
+		err := fetchPkgPart(context.Background(), client, nil, sourceServer.URL, "part", partPath, 6, "", sources, session)
+		assert.Nil(t, err)
+
+		written, err := ioutil.ReadFile(partPath)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("entire"), written)
+
+		// the short source's file was closed and replaced before the third was tried
+		assert.Equal(t, 2, files.opened)
+		assert.Equal(t, 1, files.maxOpen)
+		assert.Equal(t, 0, files.open)
+
+		assert.Equal(t, 3, responses.opened)
+		assert.Equal(t, 1, responses.maxOpen)
+		assert.Equal(t, 0, responses.open)
+	})
+}
+
+func Test_FileModes_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-modes-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	sources := []horizonpkg.PartSource{{URL: "/part"}}
+
+	// modes are checked without the umask masking them
+	umask := syscall.Umask(0)
+	defer syscall.Umask(umask)
+
+	mode := func(t *testing.T, p string) os.FileMode {
+		info, err := os.Stat(p)
+		assert.Nil(t, err)
+		return info.Mode().Perm()
+	}
+
+	suite.Run("files are only readable by their owner by default", func(t *testing.T) {
+		session := newFetchSession(Options{})
+		partPath := path.Join(tmpDir, "default")
+
+		assert.Nil(t, fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", partPath, 7, "", sources, session))
+		assert.Equal(t, os.FileMode(0600), mode(t, partPath))
+		assert.Equal(t, os.FileMode(0700), session.dirMode())
+	})
+
+	suite.Run("configured modes are used for created files and directories", func(t *testing.T) {
+		session := newFetchSession(Options{FileMode: 0640, DirMode: 0750, ResumeDownloads: true})
+		partPath := path.Join(tmpDir, "shared")
+
+		assert.Nil(t, fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", partPath, 7, "", sources, session))
+		assert.Equal(t, os.FileMode(0640), mode(t, partPath))
+
+		copied := path.Join(tmpDir, "copied")
+		assert.Nil(t, linkOrCopy(copyingFileSystem{}, partPath, copied, session.fileMode()))
+		assert.Equal(t, os.FileMode(0640), mode(t, copied))
+
+		dir := path.Join(tmpDir, "dir")
+		assert.Nil(t, session.fs.MkdirAll(dir, session.dirMode()))
+		assert.Equal(t, os.FileMode(0750), mode(t, dir))
+	})
+}
+
+// copyingFileSystem can't link files so they're copied
+type copyingFileSystem struct {
+	OSFileSystem
+}
+
+func (copyingFileSystem) Link(oldname string, newname string) error {
+	return errors.New("links not supported")
+}
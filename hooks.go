@@ -0,0 +1,120 @@
+package fetch
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PartHooks are called at points in the lifecycle of each part fetched. They
+// are called from the concurrent goroutines fetching and verifying parts so
+// implementations must be safe for concurrent use. Nil hooks are skipped.
+type PartHooks struct {
+	// OnPartStart is called when the fetch of a part of the given size starts
+	OnPartStart func(partID string, bytes int64)
+
+	// OnPartComplete is called when a part has been fetched and verified. The
+	// source is the URL it was downloaded from, empty if it was already on
+	// disk or was linked to a part with the same content.
+	OnPartComplete func(partID string, partPath string, duration time.Duration, source string)
+
+	// OnPartError is called with the error failing each attempt to download a
+	// part, numbered from 1, and with the error failing the part if it can't
+	// be fetched or verified, numbered 0.
+	OnPartError func(partID string, err error, attempt int)
+}
+
+// partLifecycle tracks the parts of a Pkg for its hooks
+type partLifecycle struct {
+	lock     sync.Mutex
+	started  map[string]time.Time
+	sources  map[string]string
+	attempts map[string]int
+}
+
+func newPartLifecycle() *partLifecycle {
+	return &partLifecycle{
+		started:  make(map[string]time.Time),
+		sources:  make(map[string]string),
+		attempts: make(map[string]int),
+	}
+}
+
+// error returns the error of the failed attempt
+func (f *partFetchFailure) error() error {
+	if f.Err != nil {
+		return f.Err
+	}
+
+	return statusError{f.HTTPStatusCode, fmt.Errorf("Source %v of part failed", f.PartURL), ""}
+}
+
+func (s *fetchSession) partStarted(partID string, bytes int64) {
+	if s.opts.Hooks == nil {
+		return
+	}
+
+	s.lifecycle.lock.Lock()
+	s.lifecycle.started[partID] = time.Now()
+	s.lifecycle.lock.Unlock()
+
+	if s.opts.Hooks.OnPartStart != nil {
+		s.opts.Hooks.OnPartStart(partID, bytes)
+	}
+}
+
+// partDownloaded records the source a part was downloaded from
+func (s *fetchSession) partDownloaded(partID string, source string) {
+	s.lifecycle.lock.Lock()
+	defer s.lifecycle.lock.Unlock()
+	s.lifecycle.sources[partID] = source
+}
+
+func (s *fetchSession) partCompleted(partID string, partPath string) {
+	if s.opts.Hooks == nil || s.opts.Hooks.OnPartComplete == nil {
+		return
+	}
+
+	s.lifecycle.lock.Lock()
+	duration := time.Since(s.lifecycle.started[partID])
+	source := s.lifecycle.sources[partID]
+	s.lifecycle.lock.Unlock()
+
+	s.opts.Hooks.OnPartComplete(partID, partPath, duration, source)
+}
+
+// fetchedSources returns the sources the fetched and verified parts were
+// downloaded from by part name; parts that weren't downloaded are omitted
+func (s *fetchSession) fetchedSources() map[string]string {
+	s.lifecycle.lock.Lock()
+	defer s.lifecycle.lock.Unlock()
+
+	sources := make(map[string]string)
+	for name := range s.fetchedParts {
+		if source, ok := s.lifecycle.sources[name]; ok {
+			sources[name] = source
+		}
+	}
+	return sources
+}
+
+// attemptFailed reports a failed attempt to download a part
+func (s *fetchSession) attemptFailed(partID string, err error) {
+	if s.opts.Hooks == nil || s.opts.Hooks.OnPartError == nil {
+		return
+	}
+
+	s.lifecycle.lock.Lock()
+	s.lifecycle.attempts[partID]++
+	attempt := s.lifecycle.attempts[partID]
+	s.lifecycle.lock.Unlock()
+
+	s.opts.Hooks.OnPartError(partID, err, attempt)
+}
+
+// partFailed reports the error failing a part
+func (s *fetchSession) partFailed(partID string, err error) {
+	if s.opts.Hooks != nil && s.opts.Hooks.OnPartError != nil {
+		s.opts.Hooks.OnPartError(partID, err, 0)
+	}
+}
@@ -0,0 +1,84 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_PartHooks_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-hooks-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte("content"))
+		}
+	}))
+	defer server.Close()
+
+	type failure struct {
+		partID  string
+		status  int
+		attempt int
+	}
+
+	suite.Run("each failed attempt is reported in order", func(t *testing.T) {
+		var lock sync.Mutex
+		var failures []failure
+
+		hooks := &PartHooks{
+			OnPartError: func(partID string, err error, attempt int) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				status := 0
+				if se, ok := err.(statusError); ok {
+					status = se.statusCode
+				}
+				failures = append(failures, failure{partID, status, attempt})
+			},
+		}
+		session := newFetchSession(Options{Hooks: hooks, Retry: RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}})
+
+		sources := []horizonpkg.PartSource{{URL: "/unavailable"}, {URL: "/missing"}, {URL: "/part"}}
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", path.Join(tmpDir, "part"), 7, "", sources, session)
+		assert.Nil(t, err)
+
+		assert.Equal(t, []failure{
+			{"part", http.StatusServiceUnavailable, 1},
+			{"part", http.StatusServiceUnavailable, 2},
+			{"part", http.StatusNotFound, 3},
+		}, failures)
+	})
+
+	suite.Run("nil hooks are skipped", func(t *testing.T) {
+		session := newFetchSession(Options{Hooks: &PartHooks{}})
+
+		session.partStarted("part", 7)
+		session.partDownloaded("part", "/part")
+		session.partCompleted("part", "/tmp/part")
+		session.attemptFailed("part", statusError{})
+		session.partFailed("part", statusError{})
+
+		sources := []horizonpkg.PartSource{{URL: "/missing"}}
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", path.Join(tmpDir, "other"), 7, "", sources, session)
+		assert.NotNil(t, err)
+	})
+}
@@ -37,6 +37,57 @@ func (p *Pkg) Serialize() ([]byte, error) {
 	return serial, nil
 }
 
+// Validate returns an error if the Pkg has a spec version this library doesn't
+// understand, is missing required content or has an ID or part names that
+// aren't safe to use as file names
+func (p *Pkg) Validate() error {
+	if p.ID == "" {
+		return errors.New("Pkg is missing an id")
+	}
+
+	if err := CheckFileName(p.ID); err != nil {
+		return fmt.Errorf("Pkg id %q is invalid: %v", p.ID, err)
+	}
+
+	if p.Meta == nil {
+		return errors.New("Pkg is missing meta")
+	}
+
+	if p.Meta.SpecVersion != specVersion {
+		return fmt.Errorf("Unsupported Pkg spec version %v, expected %v", p.Meta.SpecVersion, specVersion)
+	}
+
+	if len(p.Parts) == 0 {
+		return errors.New("Pkg has no parts")
+	}
+
+	if len(p.Meta.Provides.Images) == 0 {
+		return errors.New("Pkg meta provides no images")
+	}
+
+	for name := range p.Parts {
+		if err := CheckFileName(name); err != nil {
+			return fmt.Errorf("Pkg part name %q is invalid: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckFileName returns an error if name can't safely be used as the name of
+// a file in a directory, i.e. if joining it to the directory's path could
+// name a file outside of that directory
+func CheckFileName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return errors.New("it is not a file name")
+	case strings.ContainsAny(name, "/\\\x00"):
+		return errors.New("it contains a path separator or NUL")
+	default:
+		return nil
+	}
+}
+
 // Meta describes metadata common to all Horizon Pkgs
 type Meta struct {
 	PartsType   PartsType           `json:"parts_type"`
@@ -44,6 +95,10 @@ type Meta struct {
 	SpecVersion string              `json:"spec_version"`
 	Provides    DockerPartsProvides `json:"provides"`
 	CreateTS    int64               `json:"createTS"` // unix nanoseconds
+
+	// Sequence is increased by the publisher with each release of a Pkg so
+	// that fetchers can refuse older, replayed meta; 0 if not set
+	Sequence uint64 `json:"sequence,omitempty"`
 }
 
 // DockerImageParts describes mappings of image ids to Pkg parts that are Docker providers
@@ -76,18 +131,61 @@ type DockerPartsProvides struct {
 	Images       DockerImagePartNames `json:"images"`
 }
 
-// PartSource indicates a fetchable source of a Pkg part
+// PartSource indicates a fetchable source of a Pkg part. The URL may be an
+// http(s), file or ipfs://<cid> URL or an absolute path on the Pkg's domain.
+// Sources with higher Priority are tried first; those of equal Priority are
+// tried in order unless the fetch spreads load across them by Weight. A
+// source with SignatureSidecar serves a signature of the part at its URL with
+// ".sig" appended that the part is verified with, as well as its Signatures.
+// A source that is a MirrorIndex serves a JSON document listing the part's
+// current sources, {"mirrors": [<PartSource>, ...]}, which are tried in its
+// place.
 type PartSource struct {
-	URL string `json:"url"`
+	URL              string `json:"url"`
+	Priority         int    `json:"priority,omitempty"`
+	Weight           int    `json:"weight,omitempty"`
+	SignatureSidecar bool   `json:"signature_sidecar,omitempty"`
+	MirrorIndex      bool   `json:"mirror_index,omitempty"`
+}
+
+// PartEncoding is a faux-enum identifying how a part's content is encoded at
+// its sources. The empty encoding indicates the content is stored as-is.
+type PartEncoding string
+
+const (
+	// GZIP is a part encoding indicating gzip-compressed content
+	GZIP PartEncoding = "gzip"
+)
+
+// DigestAlgorithm is a faux-enum identifying the hash algorithm of a Digest
+type DigestAlgorithm string
+
+const (
+	// SHA256 is the digest algorithm of the SHA-256 hash
+	SHA256 DigestAlgorithm = "sha256"
+
+	// SHA512 is the digest algorithm of the SHA-512 hash
+	SHA512 DigestAlgorithm = "sha512"
+)
+
+// Digest is a hex-encoded hash of a part's content
+type Digest struct {
+	Algorithm DigestAlgorithm `json:"algorithm"`
+	Value     string          `json:"value"`
 }
 
-// DockerImagePart is a Part that provides a Docker image
+// DockerImagePart is a Part that provides a Docker image. If the part has an
+// Encoding, Sha256sum and Bytes describe its decoded content. Digests are
+// acceptable alternatives to Sha256sum: a part's content is valid if it
+// matches Sha256sum or any of them.
 type DockerImagePart struct {
 	ID         string       `json:"id"`
 	Sha256sum  string       `json:"sha256sum"`
 	Signatures []string     `json:"signatures"`
 	Bytes      int64        `json:"bytes"`
 	Sources    []PartSource `json:"sources"`
+	Encoding   PartEncoding `json:"encoding,omitempty"`
+	Digests    []Digest     `json:"digests,omitempty"`
 } // creates an ID for the package that is repeatably calculable from the content
 
 // TODO: provide functions to calculate the package ID from a pkg file.
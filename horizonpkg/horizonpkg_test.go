@@ -3,6 +3,7 @@
 package horizonpkg
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -28,7 +29,7 @@ func Test_DockerImagePkgBuilder_Suite(t *testing.T) {
 	})
 
 	t.Run("DockerImagePkgBuilder.AddPart() checks sha1sum for length", func(t *testing.T) {
-		_, err := builder.AddPart("", "1222", "someimage:latest", []string{"foo"}, 33, PartSource{"https://goo.foo"})
+		_, err := builder.AddPart("", "1222", "someimage:latest", []string{"foo"}, 33, PartSource{URL: "https://goo.foo"})
 
 		if err == nil {
 			t.Errorf("Builder failed to check sha1sum for length")
@@ -36,7 +37,7 @@ func Test_DockerImagePkgBuilder_Suite(t *testing.T) {
 	})
 
 	t.Run("DockerImagePkgBuilder.AddPart() checks sha1sum for content", func(t *testing.T) {
-		_, err := builder.AddPart("", "123456789012345678901234567890123456789#", "someimage:latest", []string{"foo"}, 33, PartSource{"https://goo.foo"})
+		_, err := builder.AddPart("", "123456789012345678901234567890123456789#", "someimage:latest", []string{"foo"}, 33, PartSource{URL: "https://goo.foo"})
 
 		if err == nil {
 			t.Errorf("Builder failed to check sha1sum for content")
@@ -44,7 +45,7 @@ func Test_DockerImagePkgBuilder_Suite(t *testing.T) {
 	})
 
 	t.Run("DockerImagePkgBuilder.AddPart() disallows empty signatures if builder is configured with defaults", func(t *testing.T) {
-		_, err := builder.AddPart("", "1234567890123456789012345678901234567890", "someimage:latest", []string{}, 33, PartSource{"https://goo.foo"})
+		_, err := builder.AddPart("", "1234567890123456789012345678901234567890", "someimage:latest", []string{}, 33, PartSource{URL: "https://goo.foo"})
 
 		if err == nil {
 			t.Errorf("Builder allowed empty signatures when adding part and shouldn't have")
@@ -54,7 +55,7 @@ func Test_DockerImagePkgBuilder_Suite(t *testing.T) {
 	t.Run("DockerImagePkgBuilder.AddPart() permits empty signatures for part when builder is so configured", func(t *testing.T) {
 		unsecureBuilder, _ := NewDockerImagePkgBuilder(FILE, author, []string{"someimage:latest"})
 		unsecureBuilder.SetPermitEmptySignatures()
-		_, err := unsecureBuilder.AddPart("", "1234567890123456789012345678901234567890123456789012345678901234", "someimage:latest", []string{}, 33, PartSource{"https://goo.foo"})
+		_, err := unsecureBuilder.AddPart("", "1234567890123456789012345678901234567890123456789012345678901234", "someimage:latest", []string{}, 33, PartSource{URL: "https://goo.foo"})
 
 		if err != nil {
 			t.Logf("%v", err)
@@ -62,3 +63,67 @@ func Test_DockerImagePkgBuilder_Suite(t *testing.T) {
 		}
 	})
 }
+
+func Test_Pkg_Validate_Suite(t *testing.T) {
+	valid := func() *Pkg {
+		return &Pkg{
+			ID: "someid",
+			Meta: &Meta{
+				SpecVersion: specVersion,
+				Provides:    DockerPartsProvides{DOCKER, DockerImagePartNames{"part": "someimage:latest"}},
+			},
+			Parts: DockerImageParts{"part": DockerImagePart{ID: "part"}},
+		}
+	}
+
+	t.Run("Pkg.Validate() accepts complete pkg", func(t *testing.T) {
+		if err := valid().Validate(); err != nil {
+			t.Errorf("Validation rejected valid pkg: %v", err)
+		}
+	})
+
+	t.Run("Pkg.Validate() rejects unknown spec version", func(t *testing.T) {
+		p := valid()
+		p.Meta.SpecVersion = "9.0.0"
+
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validation accepted pkg with unknown spec version")
+		}
+	})
+
+	t.Run("Pkg.Validate() rejects pkg missing required content", func(t *testing.T) {
+		noID := valid()
+		noID.ID = ""
+
+		noMeta := valid()
+		noMeta.Meta = nil
+
+		noParts := valid()
+		noParts.Parts = DockerImageParts{}
+
+		noImages := valid()
+		noImages.Meta.Provides.Images = nil
+
+		for _, p := range []*Pkg{noID, noMeta, noParts, noImages} {
+			if err := p.Validate(); err == nil {
+				t.Errorf("Validation accepted incomplete pkg: %v", p)
+			}
+		}
+	})
+
+	t.Run("Pkg.Validate() rejects ids and part names that aren't safe file names", func(t *testing.T) {
+		for _, name := range []string{"..", ".", "../../etc/something", "/etc/passwd", "a/b", "a\\b"} {
+			badID := valid()
+			badID.ID = name
+
+			badPart := valid()
+			badPart.Parts[name] = DockerImagePart{ID: "part"}
+
+			for _, p := range []*Pkg{badID, badPart} {
+				if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "invalid") {
+					t.Errorf("Validation accepted unsafe name %v, error: %v", name, err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// partRequestKey marks the contexts of requests for part content so that
+// redirects they follow are held to Options.AllowedHosts and DeniedHosts
+type partRequestKey struct{}
+
+// partRequests returns ctx marked as that of requests for part content
+func partRequests(ctx context.Context) context.Context {
+	return context.WithValue(ctx, partRequestKey{}, true)
+}
+
+// constrainsHosts reports whether the session constrains the hosts parts are
+// fetched from
+func (s *fetchSession) constrainsHosts() bool {
+	return len(s.opts.AllowedHosts) > 0 || len(s.opts.DeniedHosts) > 0
+}
+
+// hostMatches reports whether host matches pattern: the same host or, if
+// pattern begins with ".", any subdomain of it
+func hostMatches(host string, pattern string) bool {
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+
+	if strings.HasPrefix(pattern, ".") {
+		return strings.HasSuffix(host, pattern)
+	}
+	return host == pattern
+}
+
+// hostAllowed reports whether parts may be fetched from u: its host must
+// match one of the session's AllowedHosts, if any are configured, and none of
+// its DeniedHosts. Local files are only allowed if the session's
+// AllowLocalSources is set, URLs without a host never are.
+func (s *fetchSession) hostAllowed(u *url.URL) bool {
+	if u.Scheme == "file" {
+		return s.opts.AllowLocalSources
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return false
+	}
+
+	for _, denied := range s.opts.DeniedHosts {
+		if hostMatches(host, denied) {
+			return false
+		}
+	}
+
+	if len(s.opts.AllowedHosts) == 0 {
+		return true
+	}
+
+	for _, allowed := range s.opts.AllowedHosts {
+		if hostMatches(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedSources returns the sources of the part partID whose URLs are on
+// hosts the session allows, in order. An error is returned if the part has
+// sources and none of them are allowed.
+func (s *fetchSession) allowedSources(pkgURLBase string, partID string, sources []horizonpkg.PartSource) ([]horizonpkg.PartSource, error) {
+	if !s.constrainsHosts() {
+		return sources, nil
+	}
+
+	var allowed []horizonpkg.PartSource
+	for _, source := range sources {
+		pURL := partSourceURL(pkgURLBase, source, s)
+
+		u, err := url.Parse(pURL)
+		if err != nil || !s.hostAllowed(u) {
+			s.log.Infof(3, "Skipping source %v of part %v, its host isn't allowed", pURL, partID)
+			continue
+		}
+		allowed = append(allowed, source)
+	}
+
+	if len(allowed) == 0 && len(sources) > 0 {
+		return nil, fetcherrors.PkgSourceDisallowedError{fmt.Sprintf("None of the %v sources of part %v is on an allowed host", len(sources), partID), fmt.Errorf("Refused to fetch part: %v", partID)}
+	}
+
+	return allowed, nil
+}
+
+// withHostPolicy returns a copy of client that refuses to follow redirects of
+// requests for part content to hosts the session doesn't allow
+func withHostPolicy(client *http.Client, session *fetchSession) *http.Client {
+	if !session.constrainsHosts() {
+		return client
+	}
+
+	checkRedirect := client.CheckRedirect
+
+	constrained := *client
+	constrained.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.Context().Value(partRequestKey{}) != nil && !session.hostAllowed(req.URL) {
+			return fetcherrors.PkgSourceDisallowedError{fmt.Sprintf("Source %v redirected to %v, whose host isn't allowed", via[0].URL, req.URL), fmt.Errorf("Refused redirect to host: %v", req.URL.Host)}
+		}
+
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		} else if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+
+	return &constrained
+}
@@ -0,0 +1,148 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_SourceHosts_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-hosts-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := []byte("part content")
+
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.Host+r.URL.Path)
+		lock.Unlock()
+
+		if r.URL.Path == "/tofile" {
+			// content of the part's size, so a fetch following it would succeed
+			http.Redirect(w, r, "file://"+path.Join(tmpDir, "local"), http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1)+"/part", http.StatusFound)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	// the server is reached both as 127.0.0.1 and as localhost
+	local, err := url.Parse(server.URL)
+	assert.Nil(suite, err)
+	port := local.Port()
+
+	requested := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		r := requests
+		requests = nil
+		return r
+	}
+
+	fetch := func(name string, opts Options, sources ...string) error {
+		var partSources []horizonpkg.PartSource
+		for _, source := range sources {
+			partSources = append(partSources, horizonpkg.PartSource{URL: source})
+		}
+
+		session := newFetchSession(opts)
+		client, err := session.configureClient(&http.Client{}, nil)
+		assert.Nil(suite, err)
+
+		return fetchPkgPart(context.Background(), client, nil, "", "part", path.Join(tmpDir, name), int64(len(content)), "", partSources, session)
+	}
+
+	suite.Run("hosts are matched exactly or by domain", func(t *testing.T) {
+		assert.True(t, hostMatches("mirror.example.com", "Mirror.Example.com"))
+		assert.False(t, hostMatches("other.example.com", "mirror.example.com"))
+		assert.True(t, hostMatches("a.mirrors.example.com", ".mirrors.example.com"))
+		assert.False(t, hostMatches("mirrors.example.com", ".mirrors.example.com"))
+		assert.False(t, hostMatches("evilmirrors.example.com", ".mirrors.example.com"))
+	})
+
+	suite.Run("denied hosts take precedence over allowed ones", func(t *testing.T) {
+		session := newFetchSession(Options{AllowedHosts: []string{".example.com"}, DeniedHosts: []string{"bad.example.com"}})
+
+		for u, allowed := range map[string]bool{
+			"https://good.example.com/part":     true,
+			"https://bad.example.com:8443/part": false,
+			"https://example.org/part":          false,
+			"file:///srv/pkgs/part":             false,
+			"/srv/pkgs/part":                    false,
+		} {
+			parsed, err := url.Parse(u)
+			assert.Nil(t, err)
+			assert.Equal(t, allowed, session.hostAllowed(parsed), u)
+		}
+	})
+
+	suite.Run("local sources are only allowed explicitly", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(path.Join(tmpDir, "local"), content, 0600))
+		source := "file://" + path.Join(tmpDir, "local")
+
+		err := fetch("local-denied", Options{AllowedHosts: []string{"127.0.0.1"}}, source)
+		assert.IsType(t, fetcherrors.PkgSourceDisallowedError{}, err)
+
+		assert.Nil(t, fetch("local-allowed", Options{AllowedHosts: []string{"127.0.0.1"}, AllowLocalSources: true}, source))
+	})
+
+	suite.Run("redirect to a local file isn't followed", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(path.Join(tmpDir, "local"), content, 0600))
+
+		for _, opts := range []Options{{}, {AllowedHosts: []string{"127.0.0.1"}, AllowLocalSources: true}} {
+			assert.NotNil(t, fetch("tofile", opts, server.URL+"/tofile"))
+			requested()
+
+			// none of the local file's content was copied into the part
+			fetched, _ := ioutil.ReadFile(path.Join(tmpDir, "tofile"))
+			assert.Empty(t, fetched)
+		}
+
+		// nor by a client that isn't constrained at all
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/tofile", nil)
+		assert.Nil(t, err)
+		_, err = withFileScheme(&http.Client{}).Do(req)
+		assert.NotNil(t, err)
+		requested()
+	})
+
+	suite.Run("sources on hosts that aren't allowed are skipped", func(t *testing.T) {
+		assert.Nil(t, fetch("skipped", Options{AllowedHosts: []string{"127.0.0.1"}}, "http://localhost:"+port+"/part", server.URL+"/part"))
+		assert.Equal(t, []string{"127.0.0.1:" + port + "/part"}, requested())
+	})
+
+	suite.Run("part without an allowed source isn't fetched", func(t *testing.T) {
+		err := fetch("denied", Options{DeniedHosts: []string{"127.0.0.1", "localhost"}}, "http://localhost:"+port+"/part", server.URL+"/part")
+		assert.IsType(t, fetcherrors.PkgSourceDisallowedError{}, err)
+		assert.Empty(t, requested())
+	})
+
+	suite.Run("redirect to a host that isn't allowed isn't followed", func(t *testing.T) {
+		err := fetch("redirected", Options{AllowedHosts: []string{"127.0.0.1"}, Retry: RetryPolicy{MaxAttempts: 3}}, server.URL+"/redirect")
+		assert.NotNil(t, err)
+		assert.Equal(t, []string{"127.0.0.1:" + port + "/redirect"}, requested())
+
+		assert.Nil(t, fetch("followed", Options{AllowedHosts: []string{"127.0.0.1", "localhost"}}, server.URL+"/redirect"))
+		assert.Equal(t, []string{"127.0.0.1:" + port + "/redirect", "localhost:" + port + "/part"}, requested())
+	})
+}
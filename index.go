@@ -0,0 +1,77 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+)
+
+// PartIndex is a signed document listing the digests of a Pkg's parts; see
+// Options.PartIndex.
+type PartIndex struct {
+	// Document is the JSON index, an object whose "parts" member maps part
+	// names to the hex sha256 of their content, e.g.
+	// {"parts": {"layer1.tar.gz": "9f86d0...", "layer2.tar.gz": "60303a..."}}
+	Document []byte
+
+	// Signatures of the SHA-256 digest of Document, verified like those of
+	// parts; any one made with a configured key verifies it
+	Signatures []string
+}
+
+// partIndexDocument is the content of PartIndex.Document
+type partIndexDocument struct {
+	Parts map[string]string `json:"parts"`
+}
+
+// trustIndex verifies the session's PartIndex, if it has one, and records the
+// sha256 of each of parts whose digest in the index matches the Pkg meta so
+// that they're verified without checking their own signatures. A
+// PkgSignatureVerificationError is returned if the index can't be verified.
+func (s *fetchSession) trustIndex(ctx context.Context, primarySigningKey string, userKeysDir string, parts horizonpkg.DockerImageParts) error {
+	index := s.opts.PartIndex
+	if index == nil || s.opts.InsecureSkipSignatureVerification {
+		return nil
+	}
+
+	hasher := sha256.New()
+	hasher.Write(index.Document)
+	if err := verifySignatureWithAnyKey(ctx, primarySigningKey, userKeysDir, hasher, index.Signatures, s); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fetcherrors.PkgSignatureVerificationError{fmt.Sprintf("Part index failed cryptographic verification: %v", err), fmt.Errorf("Part index failed verification")}
+	}
+
+	var document partIndexDocument
+	if err := json.Unmarshal(index.Document, &document); err != nil {
+		return fetcherrors.PkgSignatureVerificationError{"Failed to parse verified part index", err}
+	}
+
+	s.indexedSums = make(map[string]bool)
+	for name, part := range parts {
+		sum, listed := document.Parts[name]
+		if !listed {
+			continue
+		}
+
+		// a part the index and the meta disagree about is verified with its own signatures
+		if sum == "" || sum != part.Sha256sum {
+			s.log.Errorf("Part index lists sha256 %v for part %v and Pkg meta %v, verifying the part with its signatures", sum, name, part.Sha256sum)
+			continue
+		}
+		s.indexedSums[sum] = true
+	}
+
+	s.log.Infof(3, "Verified part index, %v of %v parts need not have their signatures verified", len(s.indexedSums), len(parts))
+	return nil
+}
+
+// indexed reports whether the content with the hex sha256 sum is vouched for
+// by the session's verified PartIndex
+func (s *fetchSession) indexed(sum string) bool {
+	return s.indexedSums[sum]
+}
@@ -0,0 +1,87 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_PartIndex_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-index-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(suite, err)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.Nil(suite, err)
+
+	keyPath := path.Join(tmpDir, "key.pem")
+	assert.Nil(suite, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	sign := func(content []byte) string {
+		digest := sha256.Sum256(content)
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, digest[:]))
+	}
+
+	parts := make(horizonpkg.DockerImageParts)
+	for _, name := range []string{"a", "b", "c"} {
+		content := []byte("content/" + name)
+		assert.Nil(suite, ioutil.WriteFile(path.Join(tmpDir, name), content, 0600))
+		parts[name] = horizonpkg.DockerImagePart{
+			ID:        name,
+			Bytes:     int64(len(content)),
+			Sha256sum: fmt.Sprintf("%x", sha256.Sum256(content)),
+		}
+	}
+
+	// a is listed, b is listed with another digest and c isn't listed
+	document, err := json.Marshal(partIndexDocument{Parts: map[string]string{
+		"a": parts["a"].Sha256sum,
+		"b": fmt.Sprintf("%x", sha256.Sum256([]byte("other content"))),
+	}})
+	assert.Nil(suite, err)
+
+	verify := func(session *fetchSession, name string) error {
+		return verifyPkgPart(context.Background(), keyPath, "", path.Join(tmpDir, name), parts[name].Sha256sum, nil, nil, session)
+	}
+
+	suite.Run("parts in a verified index are verified by their hashes", func(t *testing.T) {
+		session := newFetchSession(Options{PartIndex: &PartIndex{Document: document, Signatures: []string{sign(document)}}})
+		assert.Nil(t, session.trustIndex(context.Background(), keyPath, "", parts))
+
+		assert.Nil(t, verify(session, "a"))
+		assert.Equal(t, parts["a"].Sha256sum, session.verifiedSum(path.Join(tmpDir, "a")))
+
+		// the others still need signatures
+		assert.IsType(t, fetcherrors.PkgSignatureVerificationError{}, verify(session, "b"))
+		assert.IsType(t, fetcherrors.PkgSignatureVerificationError{}, verify(session, "c"))
+	})
+
+	suite.Run("index with a bad signature isn't trusted", func(t *testing.T) {
+		session := newFetchSession(Options{PartIndex: &PartIndex{Document: document, Signatures: []string{sign([]byte("another document"))}}})
+		assert.IsType(t, fetcherrors.PkgSignatureVerificationError{}, session.trustIndex(context.Background(), keyPath, "", parts))
+		assert.IsType(t, fetcherrors.PkgSignatureVerificationError{}, verify(session, "a"))
+	})
+
+	suite.Run("parts are verified with their signatures without an index", func(t *testing.T) {
+		session := newFetchSession(Options{})
+		assert.Nil(t, session.trustIndex(context.Background(), keyPath, "", parts))
+		assert.IsType(t, fetcherrors.PkgSignatureVerificationError{}, verify(session, "a"))
+	})
+}
@@ -0,0 +1,127 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"os"
+	"path"
+)
+
+// PartState is a faux-enum identifying the state of a Pkg part on disk
+type PartState string
+
+const (
+	// VALID indicates the part file is present with the part's size and hash
+	VALID PartState = "VALID"
+
+	// INVALID indicates the part file is present but its size or hash is not
+	// the part's
+	INVALID PartState = "INVALID"
+
+	// MISSING indicates there is no part file
+	MISSING PartState = "MISSING"
+)
+
+// PartInspection reports the state of a single Pkg part on disk
+type PartInspection struct {
+	ID    string    `json:"id"`
+	Path  string    `json:"path"`
+	Bytes int64     `json:"bytes"`
+	State PartState `json:"state"`
+}
+
+// InspectReport reports the state of a Pkg on disk. NeededBytes is the total
+// size of the parts that are missing or invalid.
+type InspectReport struct {
+	Pkg         *horizonpkg.Pkg           `json:"pkg"`
+	Parts       map[string]PartInspection `json:"parts"`
+	NeededBytes int64                     `json:"needed_bytes"`
+}
+
+// PkgInspect reports which of the parts of a Pkg previously fetched into
+// destinationDir (or those selected by opts.PartIDs) are present with their
+// size and hash, which are present but invalid and which are missing, without
+// any network I/O. It reads the Pkg meta file <pkgID>.json, which must match
+// the sha256 recorded when it was fetched, but doesn't verify its signature;
+// use PkgVerify to verify it. Nothing on disk is changed.
+//
+// Deprecated: use PkgInspectContext, which can be canceled.
+func PkgInspect(pkgID string, destinationDir string, opts Options) (*InspectReport, error) {
+	return PkgInspectContext(context.Background(), pkgID, destinationDir, opts)
+}
+
+// PkgInspectContext behaves like PkgInspect but stops hashing parts, and
+// returns ctx's error, once ctx is done.
+func PkgInspectContext(ctx context.Context, pkgID string, destinationDir string, opts Options) (*InspectReport, error) {
+	session := newFetchSession(opts)
+	session.verifyOnly = true
+
+	metaPath := path.Join(destinationDir, fmt.Sprintf("%v.json", pkgID))
+	rawBody, err := readStoredMeta(metaPath, true, session)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg horizonpkg.Pkg
+	if err := json.Unmarshal(rawBody, &pkg); err != nil {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Failed to parse Pkg meta file %v", metaPath), err}
+	}
+
+	if err := pkg.Validate(); err != nil {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Pkg meta file %v is invalid", metaPath), err}
+	}
+	session.pkgID = pkg.ID
+
+	parts, err := selectPkgParts(&pkg, session.opts.PartIDs, session)
+	if err != nil {
+		return nil, fetcherrors.PkgPrecheckError{"Failed to validate Pkg information before inspecting", err}
+	}
+
+	report := &InspectReport{
+		Pkg:   &pkg,
+		Parts: make(map[string]PartInspection),
+	}
+
+	pkgDestinationDir := path.Join(destinationDir, pkg.ID)
+	needed := make(map[string]bool)
+
+	for name, part := range parts {
+		partPath := session.partPath(pkgDestinationDir, name)
+
+		state := MISSING
+		if _, err := session.partSize(partPath); err == nil {
+			present, err := partPresent(ctx, partPath, part, session)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			} else if err != nil {
+				return nil, fetcherrors.PkgSourceError{fmt.Sprintf("Failed inspecting existing part %v", partPath), err}
+			}
+
+			state = INVALID
+			if present {
+				state = VALID
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fetcherrors.PkgSourceError{fmt.Sprintf("Failed inspecting existing part %v", partPath), err}
+		}
+
+		// parts with identical content are only needed once
+		if state != VALID && (!needed[part.Sha256sum] || part.Sha256sum == "") {
+			needed[part.Sha256sum] = true
+			report.NeededBytes += part.Bytes
+		}
+
+		session.log.Infof(4, "Part %v at %v is %v", name, partPath, state)
+		report.Parts[name] = PartInspection{
+			ID:    name,
+			Path:  partPath,
+			Bytes: part.Bytes,
+			State: state,
+		}
+	}
+
+	return report, nil
+}
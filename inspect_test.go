@@ -0,0 +1,107 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_PkgInspect_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-inspect-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := map[string]string{"valid": "valid content", "invalid": "other content", "missing": "missing content", "copy": "missing content"}
+
+	pkg := horizonpkg.Pkg{
+		ID: "pkg",
+		Meta: &horizonpkg.Meta{
+			SpecVersion: "0.1.0",
+			Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{}},
+		},
+		Parts: horizonpkg.DockerImageParts{},
+	}
+	for name, c := range content {
+		pkg.Meta.Provides.Images[name] = name + ":latest"
+		pkg.Parts[name] = horizonpkg.DockerImagePart{ID: name, Bytes: int64(len(c)), Sha256sum: fmt.Sprintf("%x", sha256.Sum256([]byte(c)))}
+	}
+
+	rawBody, err := json.Marshal(pkg)
+	assert.Nil(suite, err)
+	assert.Nil(suite, ioutil.WriteFile(path.Join(tmpDir, "pkg.json"), rawBody, 0600))
+	metaSum := sha256.Sum256(rawBody)
+	assert.Nil(suite, writeMetaSum(path.Join(tmpDir, "pkg.json"), metaSum[:], newFetchSession(Options{})))
+
+	assert.Nil(suite, os.Mkdir(path.Join(tmpDir, "pkg"), 0700))
+	assert.Nil(suite, ioutil.WriteFile(path.Join(tmpDir, "pkg", "valid"), []byte("valid content"), 0600))
+	assert.Nil(suite, ioutil.WriteFile(path.Join(tmpDir, "pkg", "invalid"), []byte("tampered!!!!!"), 0600))
+
+	suite.Run("state of each part is reported", func(t *testing.T) {
+		report, err := PkgInspect("pkg", tmpDir, Options{})
+		assert.Nil(t, err)
+		assert.Equal(t, "pkg", report.Pkg.ID)
+
+		assert.Equal(t, VALID, report.Parts["valid"].State)
+		assert.Equal(t, INVALID, report.Parts["invalid"].State)
+		assert.Equal(t, MISSING, report.Parts["missing"].State)
+		assert.Equal(t, MISSING, report.Parts["copy"].State)
+		assert.Equal(t, path.Join(tmpDir, "pkg", "valid"), report.Parts["valid"].Path)
+
+		// the copy has the same content as the missing part so is only needed once
+		assert.EqualValues(t, len("other content")+len("missing content"), report.NeededBytes)
+
+		// nothing is changed
+		_, err = os.Stat(path.Join(tmpDir, "pkg", "invalid"))
+		assert.Nil(t, err)
+	})
+
+	suite.Run("only selected parts are inspected", func(t *testing.T) {
+		report, err := PkgInspect("pkg", tmpDir, Options{PartIDs: []string{"valid"}})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(report.Parts))
+		assert.EqualValues(t, 0, report.NeededBytes)
+	})
+
+	suite.Run("inspection stops when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := PkgInspectContext(ctx, "pkg", tmpDir, Options{})
+		assert.Equal(t, context.Canceled, err)
+	})
+
+	suite.Run("missing meta is an error", func(t *testing.T) {
+		_, err := PkgInspect("other", tmpDir, Options{})
+		assert.NotNil(t, err)
+	})
+
+	suite.Run("meta changed on disk is an error", func(t *testing.T) {
+		pkg.Parts["invalid"] = pkg.Parts["valid"]
+		tampered, err := json.Marshal(pkg)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(path.Join(tmpDir, "tampered.json"), tampered, 0600))
+		assert.Nil(t, ioutil.WriteFile(path.Join(tmpDir, "tampered.json.sha256"), []byte(fmt.Sprintf("%x\n", sha256.Sum256(rawBody))), 0600))
+
+		_, err = PkgInspect("tampered", tmpDir, Options{})
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+
+		// as is meta whose sha256 wasn't recorded
+		assert.Nil(t, os.Remove(path.Join(tmpDir, "tampered.json.sha256")))
+		_, err = PkgInspect("tampered", tmpDir, Options{})
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+
+		// which is only required to be recorded when the meta's signature isn't verified
+		_, err = readStoredMeta(path.Join(tmpDir, "tampered.json"), false, newFetchSession(Options{}))
+		assert.Nil(t, err)
+	})
+}
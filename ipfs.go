@@ -0,0 +1,34 @@
+package fetch
+
+import (
+	"strings"
+)
+
+const (
+	ipfsScheme     = "ipfs://"
+	ipfsPathPrefix = "/ipfs/"
+)
+
+// ipfsGatewayURL returns the URL on the session's IPFS gateway of a part
+// source addressed by IPFS CID, e.g. ipfs://<cid> or, if a gateway is
+// configured, /ipfs/<cid>, and whether the source is addressed so. The empty
+// string is returned for an ipfs:// source if no gateway is configured.
+func (s *fetchSession) ipfsGatewayURL(sourceURL string) (string, bool) {
+	gateway := strings.TrimSuffix(s.opts.IPFSGateway, "/")
+
+	var contentPath string
+	switch {
+	case strings.HasPrefix(sourceURL, ipfsScheme):
+		contentPath = strings.TrimPrefix(sourceURL, ipfsScheme)
+	case gateway != "" && strings.HasPrefix(sourceURL, ipfsPathPrefix):
+		contentPath = strings.TrimPrefix(sourceURL, ipfsPathPrefix)
+	default:
+		return "", false
+	}
+
+	if gateway == "" {
+		return "", true
+	}
+
+	return gateway + ipfsPathPrefix + contentPath, true
+}
@@ -0,0 +1,58 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_IPFS_Suite(suite *testing.T) {
+	cid := "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+
+	suite.Run("ipfs sources are fetched through the gateway", func(t *testing.T) {
+		session := newFetchSession(Options{IPFSGateway: "https://gateway.example.com/"})
+
+		assert.Equal(t, "https://gateway.example.com/ipfs/"+cid, partSourceURL("https://host/pkgs", horizonpkg.PartSource{URL: "ipfs://" + cid}, session))
+		assert.Equal(t, "https://gateway.example.com/ipfs/"+cid+"/part.tgz", partSourceURL("https://host/pkgs", horizonpkg.PartSource{URL: "/ipfs/" + cid + "/part.tgz"}, session))
+		assert.Equal(t, "https://host/pkgs/part.tgz", partSourceURL("https://host/pkgs", horizonpkg.PartSource{URL: "/part.tgz"}, session))
+	})
+
+	suite.Run("ipfs paths are on the Pkg's domain without a gateway", func(t *testing.T) {
+		session := newFetchSession(Options{})
+
+		assert.Equal(t, "https://host/pkgs/ipfs/"+cid, partSourceURL("https://host/pkgs", horizonpkg.PartSource{URL: "/ipfs/" + cid}, session))
+		assert.Equal(t, "ipfs://"+cid, partSourceURL("https://host/pkgs", horizonpkg.PartSource{URL: "ipfs://" + cid}, session))
+	})
+
+	suite.Run("part falls back from a CDN to its CID", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "fetch-test-ipfs-")
+		assert.Nil(t, err)
+		defer os.RemoveAll(tmpDir)
+
+		var requested []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.Path)
+			if r.URL.Path != "/ipfs/"+cid {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("content"))
+		}))
+		defer server.Close()
+
+		session := newFetchSession(Options{IPFSGateway: server.URL, Retry: RetryPolicy{MaxAttempts: 1}})
+		sources := []horizonpkg.PartSource{{URL: server.URL + "/cdn/part.tgz"}, {URL: "ipfs://" + cid}}
+
+		err = fetchPkgPart(context.Background(), &http.Client{}, nil, "https://host", "part", path.Join(tmpDir, "part"), 7, "", sources, session)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/cdn/part.tgz", "/ipfs/" + cid}, requested)
+	})
+}
@@ -0,0 +1,33 @@
+package fetch
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+)
+
+// Logger receives the log output of a fetch. Implementations must be safe for
+// concurrent use.
+type Logger interface {
+	// Infof logs an informational message at the given verbosity level; higher
+	// levels are more verbose.
+	Infof(level int, format string, args ...interface{})
+
+	// Errorf logs an error message.
+	Errorf(format string, args ...interface{})
+}
+
+// GlogLogger is a Logger that writes to glog, mapping verbosity levels to
+// glog's V levels. It is used if no Logger is configured.
+type GlogLogger struct{}
+
+// Infof logs to glog if glog's verbosity is at least level
+func (GlogLogger) Infof(level int, format string, args ...interface{}) {
+	if glog.V(glog.Level(level)) {
+		glog.InfoDepth(1, fmt.Sprintf(format, args...))
+	}
+}
+
+// Errorf logs to glog's error log
+func (GlogLogger) Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(1, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,267 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// ParseAndVerifyMeta fetches the pkg metadata file at the given URL, verifies
+// its signature and returns the Pkg it describes without fetching any of its
+// parts. If writeMeta is true, the metadata file is written into
+// destinationDir (which is created if necessary) and its path is returned;
+// otherwise nothing is written to disk and the returned path is empty. Other
+// arguments are as for PkgFetchWithOptions.
+//
+// Deprecated: use ParseAndVerifyMetaContext, which can be canceled.
+func ParseAndVerifyMeta(httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, writeMeta bool, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string, opts Options) (*horizonpkg.Pkg, string, error) {
+	return ParseAndVerifyMetaContext(context.Background(), httpClientFactory, pkgURL, pkgURLSignature, destinationDir, writeMeta, primarySigningKey, userKeysDir, authCreds, opts)
+}
+
+// ParseAndVerifyMetaContext behaves like ParseAndVerifyMeta but aborts the
+// request for the meta, returning ctx's error, once ctx is done.
+func ParseAndVerifyMetaContext(ctx context.Context, httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, writeMeta bool, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string, opts Options) (*horizonpkg.Pkg, string, error) {
+	session := newFetchSession(opts)
+	client, err := session.configureClient(httpClientFactory(nil), authCreds)
+	if err != nil {
+		return nil, "", fetcherrors.PkgSourceError{"Failed configuring HTTP client", err}
+	}
+
+	if err := session.requireSignature(pkgURLSignature); err != nil {
+		return nil, "", err
+	}
+
+	if writeMeta {
+		if err := session.fs.MkdirAll(destinationDir, session.dirMode()); err != nil {
+			return nil, "", err
+		}
+	}
+
+	pkgURL = localPkgURL(pkgURL)
+
+	return fetchPkgMeta(ctx, client, authCreds, primarySigningKey, userKeysDir, pkgURL.String(), pkgURLSignature, destinationDir, writeMeta, session)
+}
+
+// metaSniffBytes is the number of bytes at the start of a Pkg meta response
+// that checkMetaJSON examines
+const metaSniffBytes = 512
+
+// checkMetaJSON returns an error if the response from source that body reads
+// clearly isn't Pkg meta JSON, e.g. an HTML error page served by a
+// misconfigured proxy: if it has an HTML Content-Type or doesn't begin with a
+// JSON object. Only the start of body is read; the signature of the meta is
+// verified as ever.
+func checkMetaJSON(body *bufio.Reader, contentType string, source string) error {
+	start, err := body.Peek(metaSniffBytes)
+	if err != nil && err != io.EOF {
+		return fetcherrors.PkgMetaError{fmt.Sprintf("Failed to read Pkg meta from %v", source), err}
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "text/html" {
+		return fetcherrors.PkgMetaError{fmt.Sprintf("Response from %v was not valid Pkg JSON: its Content-Type is %v", source, contentType), fmt.Errorf("Response begins %q", responseStart(start))}
+	}
+
+	trimmed := bytes.TrimLeft(start, " \t\r\n")
+	if len(trimmed) == 0 && len(start) == metaSniffBytes {
+		// nothing but whitespace so far, leave it to the JSON decoder
+		return nil
+	}
+
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return fetcherrors.PkgMetaError{fmt.Sprintf("Response from %v was not valid Pkg JSON", source), fmt.Errorf("Response with Content-Type %q begins %q", contentType, responseStart(start))}
+	}
+
+	return nil
+}
+
+// responseStart returns the first bytes of start, enough to identify a
+// response in an error
+func responseStart(start []byte) []byte {
+	if len(start) > 64 {
+		return start[:64]
+	}
+	return start
+}
+
+// metaDownloadPrefix and metaDownloadSuffix name the temporary files in a
+// destination directory that Pkg meta is streamed into before it's verified
+const (
+	metaDownloadPrefix = ".pkgmeta-"
+	metaDownloadSuffix = ".download"
+)
+
+// streamPkgMeta streams the Pkg meta read from body, which came from source,
+// into a temporary file in destinationDir, hashing it as it's written, and
+// verifies its signature. The Pkg is then decoded from the file, which is
+// renamed <id>.json once the Pkg is valid, so the meta is never held in
+// memory whatever its size. The Pkg and the path of the meta file are
+// returned.
+func streamPkgMeta(ctx context.Context, body io.Reader, primarySigningKey string, userKeysDir string, source string, pkgURLSignature string, destinationDir string, session *fetchSession) (*horizonpkg.Pkg, string, error) {
+	downloadPath := path.Join(destinationDir, fmt.Sprintf("%v%016x%v", metaDownloadPrefix, rand.Uint64(), metaDownloadSuffix))
+
+	file, err := session.fs.OpenFile(downloadPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, session.fileMode())
+	if err != nil {
+		return nil, "", fetcherrors.PkgMetaError{fmt.Sprintf("Failed to write file %v", downloadPath), err}
+	}
+
+	promoted := false
+	defer func() {
+		if !promoted {
+			session.fs.Remove(downloadPath)
+		}
+	}()
+
+	hasher := sha256.New()
+	_, err = io.Copy(file, io.TeeReader(&contextReader{ctx, body}, hasher))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, "", fetcherrors.PkgMetaError{fmt.Sprintf("Failed to write Pkg meta from %v to %v", source, downloadPath), err}
+	}
+
+	if session.dump != nil {
+		if rawBody, err := readAll(session.fs, downloadPath); err == nil {
+			session.dump.setMeta(rawBody)
+		}
+	}
+
+	if err := checkMetaFile(downloadPath, source, session); err != nil {
+		return nil, "", err
+	}
+
+	if err := verifyMetaSignature(ctx, hasher, primarySigningKey, userKeysDir, source, pkgURLSignature, session); err != nil {
+		return nil, "", err
+	}
+
+	sum := hasher.Sum(nil)
+	pkg, err := decodeMetaFile(downloadPath, sum, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := pkg.Validate(); err != nil {
+		return nil, "", fetcherrors.PkgMetaError{fmt.Sprintf("Pkg metadata from %v is invalid", source), err}
+	}
+
+	if err := session.checkFreshness(pkg, source); err != nil {
+		return nil, "", err
+	}
+
+	metaPath := path.Join(destinationDir, fmt.Sprintf("%v.json", pkg.ID))
+	if err := session.fs.Rename(downloadPath, metaPath); err != nil {
+		return nil, "", fetcherrors.PkgMetaError{fmt.Sprintf("Failed to write file %v", metaPath), err}
+	}
+	promoted = true
+
+	if err := writeMetaSum(metaPath, sum, session); err != nil {
+		return nil, "", err
+	}
+	session.log.Infof(2, "Wrote PkgMeta to %v", metaPath)
+
+	session.verifiedMetaPath = metaPath
+	session.verifiedMetaSum = sum
+
+	return pkg, metaPath, nil
+}
+
+// checkMetaFile checks the structure of the Pkg meta from source streamed to
+// metaPath as checkMetaStructure does
+func checkMetaFile(metaPath string, source string, session *fetchSession) error {
+	file, err := session.fs.Open(metaPath)
+	if err != nil {
+		return fetcherrors.PkgMetaError{fmt.Sprintf("Failed to read Pkg meta file %v", metaPath), err}
+	}
+	defer file.Close()
+
+	if err := checkMetaStructure(bufio.NewReader(file), session.maxParts()); err != nil {
+		return fetcherrors.PkgMetaError{fmt.Sprintf("Pkg metadata from %v is not acceptable JSON", source), err}
+	}
+	return nil
+}
+
+// decodeMetaFile decodes the Pkg from the meta file at metaPath, which must
+// have the given sha256, as it's read
+func decodeMetaFile(metaPath string, sum []byte, session *fetchSession) (*horizonpkg.Pkg, error) {
+	file, err := session.fs.Open(metaPath)
+	if err != nil {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Failed to read Pkg meta file %v", metaPath), err}
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	content := io.TeeReader(file, hasher)
+
+	var pkg horizonpkg.Pkg
+	decodeErr := json.NewDecoder(content).Decode(&pkg)
+
+	// the whole file is hashed, whatever follows the JSON
+	if _, err := io.Copy(ioutil.Discard, content); err != nil {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Failed to read Pkg meta file %v", metaPath), err}
+	}
+
+	if !bytes.Equal(hasher.Sum(nil), sum) {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Pkg meta file %v was changed since it was verified", metaPath), fmt.Errorf("Mismatch between verified sha256 %x and actual sha256 %x", sum, hasher.Sum(nil))}
+	}
+
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	return &pkg, nil
+}
+
+// metaDigestSuffix is the suffix of the file, alongside a stored Pkg meta
+// file, recording the sha256 of the verified meta written to it
+const metaDigestSuffix = ".sha256"
+
+// writeMetaSum records the sha256 sum of the verified meta written to
+// metaPath
+func writeMetaSum(metaPath string, sum []byte, session *fetchSession) error {
+	digestPath := metaPath + metaDigestSuffix
+	if err := session.fs.WriteFile(digestPath, []byte(fmt.Sprintf("%x\n", sum)), session.fileMode()); err != nil {
+		return fetcherrors.PkgMetaError{fmt.Sprintf("Failed to write file %v", digestPath), err}
+	}
+
+	return nil
+}
+
+// readStoredMeta reads the Pkg meta file stored at metaPath and checks it
+// against the sha256 recorded when it was written so meta changed on disk
+// isn't trusted. Meta that doesn't match, or if requireDigest, that has no
+// recorded sha256 is an error.
+func readStoredMeta(metaPath string, requireDigest bool, session *fetchSession) ([]byte, error) {
+	rawBody, err := readAll(session.fs, metaPath)
+	if err != nil {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Failed to read Pkg meta file %v", metaPath), err}
+	}
+
+	digestPath := metaPath + metaDigestSuffix
+	recorded, err := readAll(session.fs, digestPath)
+	if os.IsNotExist(err) && !requireDigest {
+		session.log.Infof(3, "No sha256 recorded for Pkg meta file %v, it was written by an older fetch", metaPath)
+		return rawBody, nil
+	} else if err != nil {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Failed to read sha256 of Pkg meta file %v, fetch the Pkg again", metaPath), err}
+	}
+
+	if actual := fmt.Sprintf("%x", sha256.Sum256(rawBody)); actual != strings.TrimSpace(string(recorded)) {
+		return nil, fetcherrors.PkgMetaError{fmt.Sprintf("Pkg meta file %v was changed since it was fetched, fetch the Pkg again", metaPath), fmt.Errorf("Mismatch between recorded sha256 %v and actual sha256 %v", strings.TrimSpace(string(recorded)), actual)}
+	}
+
+	return rawBody, nil
+}
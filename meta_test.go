@@ -0,0 +1,287 @@
+// +build unit
+
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_StreamPkgMeta_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-meta-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	rawBody, err := json.Marshal(horizonpkg.Pkg{
+		ID: "pkg",
+		Meta: &horizonpkg.Meta{
+			SpecVersion: "0.1.0",
+			Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"part": "part:latest"}},
+		},
+		Parts: horizonpkg.DockerImageParts{"part": {ID: "part", Sha256sum: "sum", Sources: []horizonpkg.PartSource{{URL: "/part"}}}},
+	})
+	assert.Nil(suite, err)
+
+	// no partially downloaded meta is left in dir
+	assertNoDownloads := func(t *testing.T, dir string) {
+		files, err := ioutil.ReadDir(dir)
+		assert.Nil(t, err)
+		for _, file := range files {
+			assert.False(t, strings.HasSuffix(file.Name(), metaDownloadSuffix), file.Name())
+		}
+	}
+
+	suite.Run("meta is streamed to its file and its sha256 recorded", func(t *testing.T) {
+		dir := path.Join(tmpDir, "streamed")
+		assert.Nil(t, os.Mkdir(dir, 0700))
+		session := newFetchSession(Options{InsecureSkipSignatureVerification: true})
+
+		pkg, metaPath, err := streamPkgMeta(context.Background(), bytes.NewReader(rawBody), "", "", "test", "", dir, session)
+		assert.Nil(t, err)
+		assert.Equal(t, "pkg", pkg.ID)
+		assert.Equal(t, path.Join(dir, "pkg.json"), metaPath)
+		assertNoDownloads(t, dir)
+
+		written, err := ioutil.ReadFile(metaPath)
+		assert.Nil(t, err)
+		assert.Equal(t, rawBody, written)
+
+		recorded, err := ioutil.ReadFile(metaPath + metaDigestSuffix)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("%x\n", sha256.Sum256(rawBody)), string(recorded))
+
+		parts, err := precheckPkgParts(pkg, nil, session)
+		assert.Nil(t, err)
+		assert.Equal(t, "sum", parts["part"].Sha256sum)
+	})
+
+	suite.Run("meta changed on disk after verification isn't trusted", func(t *testing.T) {
+		dir := path.Join(tmpDir, "tampered")
+		assert.Nil(t, os.Mkdir(dir, 0700))
+		session := newFetchSession(Options{InsecureSkipSignatureVerification: true})
+
+		pkg, metaPath, err := streamPkgMeta(context.Background(), bytes.NewReader(rawBody), "", "", "test", "", dir, session)
+		assert.Nil(t, err)
+
+		assert.Nil(t, ioutil.WriteFile(metaPath, bytes.Replace(rawBody, []byte(`"sum"`), []byte(`"bad"`), 1), 0600))
+		_, err = precheckPkgParts(pkg, nil, session)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "changed since it was verified")
+	})
+
+	suite.Run("unverified meta isn't kept", func(t *testing.T) {
+		dir := path.Join(tmpDir, "unverified")
+		assert.Nil(t, os.Mkdir(dir, 0700))
+
+		_, _, err := streamPkgMeta(context.Background(), bytes.NewReader(rawBody), "", path.Join(tmpDir, "nokeys"), "test", "c2lnbmF0dXJl", dir, newFetchSession(Options{}))
+		assert.NotNil(t, err)
+
+		files, err := ioutil.ReadDir(dir)
+		assert.Nil(t, err)
+		assert.Empty(t, files)
+	})
+
+	suite.Run("invalid meta isn't kept", func(t *testing.T) {
+		dir := path.Join(tmpDir, "invalid")
+		assert.Nil(t, os.Mkdir(dir, 0700))
+
+		_, _, err := streamPkgMeta(context.Background(), strings.NewReader(`{"id": "pkg", "parts": `), "", "", "test", "", dir, newFetchSession(Options{InsecureSkipSignatureVerification: true}))
+		assert.NotNil(t, err)
+
+		files, err := ioutil.ReadDir(dir)
+		assert.Nil(t, err)
+		assert.Empty(t, files)
+	})
+}
+
+func Test_MetaFreshness_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-freshness-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	meta := func(sequence uint64, createTS int64) []byte {
+		rawBody, err := json.Marshal(horizonpkg.Pkg{
+			ID: "pkg",
+			Meta: &horizonpkg.Meta{
+				SpecVersion: "0.1.0",
+				Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"part": "part:latest"}},
+				CreateTS:    createTS,
+				Sequence:    sequence,
+			},
+			Parts: horizonpkg.DockerImageParts{"part": {ID: "part"}},
+		})
+		assert.Nil(suite, err)
+		return rawBody
+	}
+
+	parse := func(rawBody []byte, opts Options) error {
+		opts.InsecureSkipSignatureVerification = true
+		_, err := parsePkgMeta(context.Background(), rawBody, "", "", "test", "", newFetchSession(opts))
+		return err
+	}
+
+	suite.Run("meta isn't checked for freshness by default", func(t *testing.T) {
+		assert.Nil(t, parse(meta(0, 0), Options{}))
+	})
+
+	suite.Run("meta older than the last seen sequence is refused", func(t *testing.T) {
+		assert.Nil(t, parse(meta(7, 0), Options{MinSequence: 7}))
+		assert.Nil(t, parse(meta(8, 0), Options{MinSequence: 7}))
+
+		err := parse(meta(6, 0), Options{MinSequence: 7})
+		assert.IsType(t, fetcherrors.PkgRollbackError{}, err)
+
+		// meta without a sequence can't be shown to be fresh
+		assert.IsType(t, fetcherrors.PkgRollbackError{}, parse(meta(0, 0), Options{MinSequence: 7}))
+	})
+
+	suite.Run("meta created before the last seen is refused", func(t *testing.T) {
+		assert.Nil(t, parse(meta(0, 1000), Options{MinCreateTS: 1000}))
+		assert.IsType(t, fetcherrors.PkgRollbackError{}, parse(meta(0, 999), Options{MinCreateTS: 1000}))
+	})
+
+	suite.Run("refused meta isn't written", func(t *testing.T) {
+		session := newFetchSession(Options{InsecureSkipSignatureVerification: true, MinSequence: 7})
+
+		_, _, err := streamPkgMeta(context.Background(), bytes.NewReader(meta(6, 0)), "", "", "test", "", tmpDir, session)
+		assert.IsType(t, fetcherrors.PkgRollbackError{}, err)
+
+		files, err := ioutil.ReadDir(tmpDir)
+		assert.Nil(t, err)
+		assert.Empty(t, files)
+	})
+}
+
+func Test_GzipMeta_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-gzip-meta-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(suite, err)
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.Nil(suite, err)
+
+	keysDir := path.Join(tmpDir, "keys")
+	assert.Nil(suite, os.Mkdir(keysDir, 0700))
+	assert.Nil(suite, ioutil.WriteFile(path.Join(keysDir, "ed25519.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	rawBody, err := json.Marshal(horizonpkg.Pkg{
+		ID: "pkg",
+		Meta: &horizonpkg.Meta{
+			SpecVersion: "0.1.0",
+			Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"part": "part:latest"}},
+		},
+		Parts: horizonpkg.DockerImageParts{"part": {ID: "part", Sha256sum: "sum", Sources: []horizonpkg.PartSource{{URL: "/part"}}}},
+	})
+	assert.Nil(suite, err)
+
+	// the signature is of the decompressed meta
+	digest := sha256.Sum256(rawBody)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, digest[:]))
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write(rawBody)
+	assert.Nil(suite, err)
+	assert.Nil(suite, writer.Close())
+
+	// serves the compressed meta to clients accepting gzip
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/compressed.json" && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed.Bytes())
+			return
+		}
+		w.Write(rawBody)
+	}))
+	defer server.Close()
+
+	fetch := func(name string, writeMeta bool) (*horizonpkg.Pkg, string, error) {
+		return fetchPkgMeta(context.Background(), &http.Client{}, nil, "", keysDir, server.URL+"/"+name, signature, tmpDir, writeMeta, newFetchSession(Options{}))
+	}
+
+	suite.Run("compressed meta is decompressed and verified", func(t *testing.T) {
+		pkg, _, err := fetch("compressed.json", false)
+		assert.Nil(t, err)
+		assert.Equal(t, "pkg", pkg.ID)
+	})
+
+	suite.Run("written compressed meta is decompressed", func(t *testing.T) {
+		pkg, metaPath, err := fetch("compressed.json", true)
+		assert.Nil(t, err)
+		assert.Equal(t, "pkg", pkg.ID)
+
+		written, err := ioutil.ReadFile(metaPath)
+		assert.Nil(t, err)
+		assert.Equal(t, rawBody, written)
+	})
+
+	suite.Run("uncompressed meta is verified as before", func(t *testing.T) {
+		pkg, _, err := fetch("plain.json", false)
+		assert.Nil(t, err)
+		assert.Equal(t, "pkg", pkg.ID)
+	})
+}
+
+func Test_MetaJSONCheck_Suite(suite *testing.T) {
+	check := func(body string, contentType string) error {
+		return checkMetaJSON(bufio.NewReader(strings.NewReader(body)), contentType, "test")
+	}
+
+	suite.Run("JSON object is accepted", func(t *testing.T) {
+		assert.Nil(t, check(`{"id": "pkg"}`, "application/json"))
+		assert.Nil(t, check("\n  {\"id\": \"pkg\"}", ""))
+		assert.Nil(t, check(`{"id": "pkg"}`, "application/octet-stream"))
+	})
+
+	suite.Run("HTML error page is reported as not Pkg JSON", func(t *testing.T) {
+		err := check("<html><body>Proxy Error</body></html>", "text/html; charset=utf-8")
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+		assert.Contains(t, err.Error(), "was not valid Pkg JSON")
+		assert.Contains(t, err.Error(), "Proxy Error")
+
+		// without a Content-Type too
+		err = check("<!DOCTYPE html><html></html>", "")
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+		assert.Contains(t, err.Error(), "was not valid Pkg JSON")
+	})
+
+	suite.Run("empty response is reported as not Pkg JSON", func(t *testing.T) {
+		assert.IsType(t, fetcherrors.PkgMetaError{}, check("", "application/json"))
+	})
+
+	suite.Run("HTML response fails before signature verification", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>Sign in to continue</body></html>"))
+		}))
+		defer server.Close()
+
+		_, _, err := fetchPkgMeta(context.Background(), &http.Client{}, nil, "", "", server.URL+"/pkg.json", "signature", "", false, newFetchSession(Options{}))
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+		assert.Contains(t, err.Error(), "was not valid Pkg JSON")
+		assert.NotContains(t, err.Error(), "cryptographic")
+	})
+}
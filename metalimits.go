@@ -0,0 +1,130 @@
+package fetch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// defaultMaxMetadataBytes and defaultMaxParts bound the Pkg meta read if
+// Options.MaxMetadataBytes and Options.MaxParts aren't set
+const (
+	defaultMaxMetadataBytes = 16 * 1024 * 1024
+	defaultMaxParts         = 10000
+)
+
+// maxMetaDepth is how deeply the objects and arrays of Pkg meta may be
+// nested; that of a valid Pkg is far less
+const maxMetaDepth = 32
+
+// metaLimitReader fails reads of Pkg meta once more than limit bytes have
+// been read
+type metaLimitReader struct {
+	reader io.Reader
+	limit  int64
+	read   int64
+}
+
+func (r *metaLimitReader) Read(p []byte) (int, error) {
+	if r.read > r.limit {
+		return 0, r.err()
+	}
+
+	// one byte past the limit is read to tell meta of exactly limit bytes from larger
+	if remaining := r.limit + 1 - r.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.limit {
+		return n, r.err()
+	}
+	return n, err
+}
+
+func (r *metaLimitReader) err() error {
+	return fmt.Errorf("Pkg meta is larger than the limit of %v bytes", r.limit)
+}
+
+// checkMetaStructure reads the JSON of Pkg meta, which is untrusted until its
+// signature is verified, and returns an error if it is malformed or
+// pathological: nested more than maxMetaDepth deep, with an object with
+// duplicate keys, or with more than maxParts parts. It only tokenizes the
+// JSON so it holds no more of it in memory than one token at a time.
+func checkMetaStructure(reader io.Reader, maxParts int) error {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+
+	// the keys of each open object, nil for open arrays
+	var open []map[string]bool
+
+	// set while the value of an object member is expected
+	var key string
+	expectValue := false
+
+	// members of the top level "parts" object, -1 outside it
+	parts := -1
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			if len(open) != 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		inObject := len(open) > 0 && open[len(open)-1] != nil
+
+		// a string in an object where a key is expected is a key
+		if name, ok := token.(string); ok && inObject && !expectValue {
+			if open[len(open)-1][name] {
+				return fmt.Errorf("Duplicate key %q in Pkg meta", name)
+			}
+			open[len(open)-1][name] = true
+
+			if parts >= 0 && len(open) == 2 {
+				if parts++; parts > maxParts {
+					return fmt.Errorf("Pkg meta has more than the limit of %v parts", maxParts)
+				}
+			}
+
+			key = name
+			expectValue = true
+			continue
+		}
+		expectValue = false
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			if len(open) == maxMetaDepth {
+				return fmt.Errorf("Pkg meta is nested more than the limit of %v deep", maxMetaDepth)
+			}
+
+			if token == json.Delim('{') {
+				if len(open) == 1 && inObject && key == "parts" {
+					parts = 0
+				}
+				open = append(open, make(map[string]bool))
+			} else {
+				open = append(open, nil)
+			}
+		case json.Delim('}'), json.Delim(']'):
+			open = open[:len(open)-1]
+			if len(open) == 1 {
+				parts = -1
+			}
+		}
+
+		// a value at the top level ends the document
+		if len(open) == 0 {
+			if _, err := decoder.Token(); err != io.EOF {
+				return fmt.Errorf("Pkg meta has content after its JSON object")
+			}
+			return nil
+		}
+	}
+}
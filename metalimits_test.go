@@ -0,0 +1,127 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_MetaLimits_Suite(suite *testing.T) {
+	suite.Run("pathological meta is refused", func(t *testing.T) {
+		for _, meta := range []string{
+			strings.Repeat("[", maxMetaDepth+1) + strings.Repeat("]", maxMetaDepth+1),
+			`{"id": "pkg", "parts": {"a": {"id": "a", "id": "b"}}}`,
+			`{"id": "pkg", "id": "other"}`,
+			`{"id": "pkg", "parts": {"a": {}, "b": {}, "c": {}}}`,
+			`{"id": "pkg"}{"id": "other"}`,
+			`{"id": "pkg", "parts": {`,
+			`{"id": }`,
+		} {
+			assert.NotNil(t, checkMetaStructure(strings.NewReader(meta), 2), meta)
+		}
+	})
+
+	suite.Run("reasonable meta is accepted", func(t *testing.T) {
+		for _, meta := range []string{
+			`{"id": "pkg", "parts": {"a": {"id": "a", "sources": [{"url": "/a"}]}, "b": {"id": "b"}}}`,
+			`{"id": "pkg", "meta": {"provides": {"images": {"a": "image"}}}, "parts": {}}  `,
+			`{"id": "parts", "meta": {"parts": {"a": 1, "b": 2, "c": 3}}}`,
+			strings.Repeat("[", maxMetaDepth) + strings.Repeat("]", maxMetaDepth),
+		} {
+			assert.Nil(t, checkMetaStructure(strings.NewReader(meta), 2), meta)
+		}
+	})
+
+	suite.Run("meta is read up to the limit", func(t *testing.T) {
+		content, err := ioutil.ReadAll(&metaLimitReader{reader: strings.NewReader("0123456789"), limit: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, "0123456789", string(content))
+
+		_, err = ioutil.ReadAll(&metaLimitReader{reader: strings.NewReader("0123456789"), limit: 9})
+		assert.NotNil(t, err)
+	})
+
+	suite.Run("fetch refuses meta over the limits", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "fetch-test-metalimits-")
+		assert.Nil(t, err)
+		defer os.RemoveAll(tmpDir)
+
+		parts := make(horizonpkg.DockerImageParts)
+		for ix := 0; ix < 3; ix++ {
+			name := fmt.Sprintf("part%v", ix)
+			parts[name] = horizonpkg.DockerImagePart{ID: name}
+		}
+		meta, err := json.Marshal(horizonpkg.Pkg{
+			ID: "pkg",
+			Meta: &horizonpkg.Meta{
+				SpecVersion: "0.1.0",
+				Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"part0": "image:latest"}},
+			},
+			Parts: parts,
+		})
+		assert.Nil(t, err)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/chunked" {
+				// no Content-Length
+				w.Write(meta[:10])
+				w.(http.Flusher).Flush()
+				w.Write(meta[10:])
+				return
+			}
+			w.Write(meta)
+		}))
+		defer server.Close()
+
+		fetch := func(source string, writeMeta bool, opts Options) error {
+			opts.InsecureSkipSignatureVerification = true
+			_, _, err := fetchPkgMeta(context.Background(), &http.Client{}, nil, "", "", server.URL+source, "", tmpDir, writeMeta, newFetchSession(opts))
+			return err
+		}
+
+		for _, writeMeta := range []bool{false, true} {
+			assert.Nil(t, fetch("/pkg.json", writeMeta, Options{}))
+
+			for _, source := range []string{"/pkg.json", "/chunked"} {
+				err := fetch(source, writeMeta, Options{MaxMetadataBytes: int64(len(meta) - 1)})
+				assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+			}
+
+			err := fetch("/pkg.json", writeMeta, Options{MaxParts: 2})
+			if assert.IsType(t, fetcherrors.PkgMetaError{}, err) {
+				assert.Contains(t, err.(fetcherrors.PkgMetaError).InternalError.Error(), "limit of 2 parts")
+			}
+		}
+	})
+}
+
+func FuzzCheckMetaStructure(f *testing.F) {
+	f.Add([]byte(`{"id": "pkg", "parts": {"a": {"id": "a", "sources": [{"url": "/a"}]}}}`))
+	f.Add([]byte(`{"id": "pkg", "id": "other"}`))
+	f.Add([]byte(strings.Repeat("[", 100)))
+	f.Add([]byte(`{"parts": {"a": {}, "b": {}, "c": {}}}`))
+
+	f.Fuzz(func(t *testing.T, meta []byte) {
+		if checkMetaStructure(bytes.NewReader(meta), 2) != nil {
+			return
+		}
+
+		// meta that passes is parsed without exceeding the limits
+		var pkg horizonpkg.Pkg
+		if err := json.Unmarshal(meta, &pkg); err == nil && len(pkg.Parts) > 2 {
+			t.Errorf("Meta with %v parts passed the limit of 2: %s", len(pkg.Parts), meta)
+		}
+	})
+}
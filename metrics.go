@@ -0,0 +1,49 @@
+package fetch
+
+import (
+	"time"
+)
+
+// MetricsSink receives measurements of fetch operations so they can be
+// adapted to a metrics system like prometheus or statsd. Implementations must
+// be safe for concurrent use; they are called from each part's fetch
+// goroutine.
+type MetricsSink interface {
+	// ObserveFetch records a part successfully downloaded with the given size
+	// and download duration.
+	ObserveFetch(pkgID string, partID string, bytes int64, duration time.Duration)
+
+	// IncSkipped records a part not downloaded because it already existed on
+	// disk.
+	IncSkipped(pkgID string, partID string)
+
+	// IncFailure records a part that failed to be fetched or verified.
+	IncFailure(pkgID string, partID string)
+
+	// IncRetry records a subsequent attempt to fetch a part after a failed
+	// one.
+	IncRetry(pkgID string, partID string)
+
+	// IncVerificationFailure records a failure to verify a part's signature.
+	IncVerificationFailure(pkgID string)
+}
+
+// NoopMetricsSink is a MetricsSink that discards all measurements. It is used
+// if no sink is configured.
+type NoopMetricsSink struct{}
+
+// ObserveFetch does nothing
+func (NoopMetricsSink) ObserveFetch(pkgID string, partID string, bytes int64, duration time.Duration) {
+}
+
+// IncSkipped does nothing
+func (NoopMetricsSink) IncSkipped(pkgID string, partID string) {}
+
+// IncFailure does nothing
+func (NoopMetricsSink) IncFailure(pkgID string, partID string) {}
+
+// IncRetry does nothing
+func (NoopMetricsSink) IncRetry(pkgID string, partID string) {}
+
+// IncVerificationFailure does nothing
+func (NoopMetricsSink) IncVerificationFailure(pkgID string) {}
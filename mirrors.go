@@ -0,0 +1,124 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// maxMirrorIndexBytes bounds the size of a mirror index read
+const maxMirrorIndexBytes = 1024 * 1024
+
+// mirrorIndex is the document served by a part source that is a
+// MirrorIndex: the sources of the part currently available. The URLs of the
+// mirrors are relative to that of the index.
+type mirrorIndex struct {
+	Mirrors []horizonpkg.PartSource `json:"mirrors"`
+}
+
+// expandMirrorIndexes returns sources with each source that is a mirror index
+// replaced by the mirrors it lists, in their place. An index that can't be
+// fetched or parsed is logged and contributes no mirrors; the others are
+// tried as ever.
+func expandMirrorIndexes(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, pkgURLBase string, partID string, sources []horizonpkg.PartSource, session *fetchSession) []horizonpkg.PartSource {
+	var expanded []horizonpkg.PartSource
+	for _, source := range sources {
+		if !source.MirrorIndex {
+			expanded = append(expanded, source)
+			continue
+		}
+
+		indexURL := partSourceURL(pkgURLBase, source, session)
+		mirrors, err := session.mirrors(ctx, client, authCreds, partID, indexURL)
+		if err != nil {
+			session.log.Errorf("Failed to discover mirrors of part %v from index %v, skipping it. Error: %v", partID, indexURL, err)
+			continue
+		}
+
+		session.log.Infof(3, "Discovered %v mirrors of part %v from index %v", len(mirrors), partID, indexURL)
+		expanded = append(expanded, mirrors...)
+	}
+
+	return expanded
+}
+
+// mirrors returns the mirrors listed by the mirror index at indexURL, which
+// is fetched once per session
+func (s *fetchSession) mirrors(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, partID string, indexURL string) ([]horizonpkg.PartSource, error) {
+	s.mirrorsLock.Lock()
+	mirrors, cached := s.mirrorIndexes[indexURL]
+	s.mirrorsLock.Unlock()
+	if cached {
+		return mirrors, nil
+	}
+
+	mirrors, err := fetchMirrorIndex(ctx, client, authCreds, partID, indexURL, s)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mirrorsLock.Lock()
+	defer s.mirrorsLock.Unlock()
+
+	if s.mirrorIndexes == nil {
+		s.mirrorIndexes = make(map[string][]horizonpkg.PartSource)
+	}
+	s.mirrorIndexes[indexURL] = mirrors
+	return mirrors, nil
+}
+
+// fetchMirrorIndex fetches the mirror index at indexURL and returns the
+// mirrors it lists with their URLs resolved against it. Only HTTP mirrors are
+// returned, an index can't point at local files or other schemes.
+func fetchMirrorIndex(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, partID string, indexURL string, session *fetchSession) ([]horizonpkg.PartSource, error) {
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := requestWithRetries(ctx, client, authCreds, partID, indexURL, 0, nil, session)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, statusError{response.StatusCode, fmt.Errorf("Unexpected status code in response to mirror index fetch: %v", response.StatusCode), ""}
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxMirrorIndexBytes+1))
+	if err != nil {
+		return nil, err
+	} else if len(content) > maxMirrorIndexBytes {
+		return nil, fmt.Errorf("Mirror index is larger than the limit of %v bytes", maxMirrorIndexBytes)
+	}
+
+	var index mirrorIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, err
+	}
+
+	var mirrors []horizonpkg.PartSource
+	for _, mirror := range index.Mirrors {
+		mirrorURL, err := base.Parse(mirror.URL)
+		if err != nil || mirror.URL == "" {
+			session.log.Errorf("Skipping mirror %q of index %v, it isn't a valid URL", mirror.URL, indexURL)
+			continue
+		} else if mirrorURL.Scheme != "http" && mirrorURL.Scheme != "https" {
+			session.log.Errorf("Skipping mirror %v of index %v, its scheme %q isn't http or https", mirrorURL, indexURL, mirrorURL.Scheme)
+			continue
+		}
+
+		// indexes aren't nested
+		mirror.URL = mirrorURL.String()
+		mirror.MirrorIndex = false
+		mirrors = append(mirrors, mirror)
+	}
+
+	return mirrors, nil
+}
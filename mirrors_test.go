@@ -0,0 +1,102 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func Test_MirrorIndex_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-mirrors-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := []byte("mirrored content")
+
+	var lock sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requested = append(requested, r.URL.Path)
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case "/mirrors/index.json":
+			w.Write([]byte(`{"mirrors": [{"url": "/missing"}, {"url": "current/part", "mirror_index": true}]}`))
+		case "/local/index.json":
+			w.Write([]byte(`{"mirrors": [{"url": "file://` + path.Join(tmpDir, "local") + `"}, {"url": "ftp://127.0.0.1/part"}, {"url": "/fallback"}]}`))
+		case "/broken/index.json":
+			w.Write([]byte(`not json`))
+		case "/mirrors/current/part", "/fallback":
+			w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetch := func(name string, session *fetchSession, sources ...horizonpkg.PartSource) error {
+		lock.Lock()
+		requested = nil
+		lock.Unlock()
+
+		partPath := path.Join(tmpDir, name)
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", partPath, int64(len(content)), "", sources, session)
+		if err == nil {
+			written, readErr := ioutil.ReadFile(partPath)
+			assert.Nil(suite, readErr)
+			assert.Equal(suite, content, written)
+		}
+		return err
+	}
+
+	suite.Run("mirrors listed by an index are tried in its place", func(t *testing.T) {
+		session := newFetchSession(Options{})
+
+		assert.Nil(t, fetch("indexed", session, horizonpkg.PartSource{URL: "/mirrors/index.json", MirrorIndex: true}))
+		assert.Equal(t, []string{"/mirrors/index.json", "/missing", "/mirrors/current/part"}, requested)
+
+		// the index is fetched once per session
+		assert.Nil(t, fetch("indexed-again", session, horizonpkg.PartSource{URL: "/mirrors/index.json", MirrorIndex: true}))
+		assert.Equal(t, []string{"/missing", "/mirrors/current/part"}, requested)
+	})
+
+	suite.Run("unusable index falls back to the other sources", func(t *testing.T) {
+		session := newFetchSession(Options{})
+
+		assert.Nil(t, fetch("fallback", session, horizonpkg.PartSource{URL: "/broken/index.json", MirrorIndex: true}, horizonpkg.PartSource{URL: "/fallback"}))
+		assert.Equal(t, []string{"/broken/index.json", "/fallback"}, requested)
+
+		assert.NotNil(t, fetch("unavailable", session, horizonpkg.PartSource{URL: "/unavailable/index.json", MirrorIndex: true}))
+	})
+
+	suite.Run("mirrors must be http or https", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(path.Join(tmpDir, "local"), []byte("local content"), 0600))
+		session := newFetchSession(Options{})
+
+		mirrors, err := fetchMirrorIndex(context.Background(), &http.Client{}, nil, "part", server.URL+"/local/index.json", session)
+		assert.Nil(t, err)
+		assert.Equal(t, []horizonpkg.PartSource{{URL: server.URL + "/fallback"}}, mirrors)
+
+		assert.Nil(t, fetch("local", session, horizonpkg.PartSource{URL: "/local/index.json", MirrorIndex: true}))
+	})
+
+	suite.Run("mirrors must be on allowed hosts", func(t *testing.T) {
+		session := newFetchSession(Options{DeniedHosts: []string{"127.0.0.1"}})
+		mirrors := []horizonpkg.PartSource{{URL: "http://127.0.0.1/part"}, {URL: "http://localhost/part"}}
+		session.mirrorIndexes = map[string][]horizonpkg.PartSource{"http://localhost/index.json": mirrors}
+
+		allowed, err := session.allowedSources("", "part", expandMirrorIndexes(context.Background(), &http.Client{}, nil, "", "part", []horizonpkg.PartSource{{URL: "http://localhost/index.json", MirrorIndex: true}}, session))
+		assert.Nil(t, err)
+		assert.Equal(t, []horizonpkg.PartSource{{URL: "http://localhost/part"}}, allowed)
+	})
+}
@@ -0,0 +1,91 @@
+package fetch
+
+import (
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"path"
+	"path/filepath"
+)
+
+// partFileNames returns the names of the files the given parts are written
+// to, by part name: the session's PartFileName of each part or else its name.
+// Names that aren't safe file names, and parts with different content that
+// would be written to the same file, are errors.
+func (s *fetchSession) partFileNames(parts horizonpkg.DockerImageParts) (map[string]string, error) {
+	files := make(map[string]string)
+	written := make(map[string]string)
+
+	for name, part := range parts {
+		file := name
+		if s.opts.PartFileName != nil {
+			file = s.opts.PartFileName(part)
+		}
+
+		if err := horizonpkg.CheckFileName(file); err != nil {
+			return nil, fmt.Errorf("File name %q of part %v is invalid: %v", file, name, err)
+		}
+
+		if other, exists := written[file]; exists && (part.Sha256sum == "" || parts[other].Sha256sum != part.Sha256sum) {
+			return nil, fmt.Errorf("Parts %v and %v with different content would both be written to %v", other, name, file)
+		}
+
+		files[name] = file
+		written[file] = name
+	}
+
+	return files, nil
+}
+
+// partPath returns the path in destinationDir of the named part's file. The
+// session's parts must have been prechecked.
+func (s *fetchSession) partPath(destinationDir string, name string) string {
+	if file, ok := s.partFiles[name]; ok {
+		return path.Join(destinationDir, file)
+	}
+
+	return path.Join(destinationDir, name)
+}
+
+// PartPath returns the absolute path of the file into which the named part of
+// the Pkg with ID pkgID is fetched in destinationDir with the default Options.
+// Pkgs fetched with Options.PartFileName should use PartPathWithOptions.
+func PartPath(destinationDir string, pkgID string, partName string) (string, error) {
+	if err := horizonpkg.CheckFileName(pkgID); err != nil {
+		return "", fmt.Errorf("Pkg ID %q is invalid: %v", pkgID, err)
+	}
+
+	if err := horizonpkg.CheckFileName(partName); err != nil {
+		return "", fmt.Errorf("Part name %q is invalid: %v", partName, err)
+	}
+
+	return filepath.Abs(path.Join(destinationDir, pkgID, partName))
+}
+
+// PartPathWithOptions behaves like PartPath for the named part of pkg fetched
+// with the given Options, naming its file with opts.PartFileName if set. The
+// names of parts written to a Destination are returned as they are.
+func PartPathWithOptions(destinationDir string, pkg *horizonpkg.Pkg, partName string, opts Options) (string, error) {
+	if err := horizonpkg.CheckFileName(pkg.ID); err != nil {
+		return "", fmt.Errorf("Pkg ID %q is invalid: %v", pkg.ID, err)
+	}
+
+	if _, exists := pkg.Parts[partName]; !exists {
+		return "", fmt.Errorf("Pkg %v has no part with id %v", pkg.ID, partName)
+	}
+
+	session := newFetchSession(opts)
+
+	// all parts are named so those that would share a file are found
+	files, err := session.partFileNames(pkg.Parts)
+	if err != nil {
+		return "", err
+	}
+	session.partFiles = files
+
+	partPath := session.partPath(path.Join(destinationDir, pkg.ID), partName)
+	if opts.Destination != nil {
+		return partPath, nil
+	}
+
+	return filepath.Abs(partPath)
+}
@@ -0,0 +1,109 @@
+// +build unit
+
+package fetch
+
+import (
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_PartFileNames_Suite(suite *testing.T) {
+	parts := horizonpkg.DockerImageParts{
+		"a": {ID: "a", Sha256sum: "aaa"},
+		"b": {ID: "b", Sha256sum: "bbb"},
+		"c": {ID: "c", Sha256sum: "bbb"},
+	}
+
+	suite.Run("parts are named by their names by default", func(t *testing.T) {
+		session := newFetchSession(Options{})
+
+		files, err := session.partFileNames(parts)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"a": "a", "b": "b", "c": "c"}, files)
+
+		session.partFiles = files
+		assert.Equal(t, "/dest/pkg/b", session.partPath("/dest/pkg", "b"))
+	})
+
+	suite.Run("parts with the same content may share a file", func(t *testing.T) {
+		session := newFetchSession(Options{PartFileName: func(part horizonpkg.DockerImagePart) string {
+			return part.Sha256sum + ".tgz"
+		}})
+
+		files, err := session.partFileNames(parts)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"a": "aaa.tgz", "b": "bbb.tgz", "c": "bbb.tgz"}, files)
+
+		session.partFiles = files
+		assert.Equal(t, "/dest/pkg/aaa.tgz", session.partPath("/dest/pkg", "a"))
+	})
+
+	suite.Run("parts with different content may not share a file", func(t *testing.T) {
+		session := newFetchSession(Options{PartFileName: func(part horizonpkg.DockerImagePart) string {
+			return "part.tgz"
+		}})
+
+		_, err := session.partFileNames(parts)
+		assert.NotNil(t, err)
+	})
+
+	suite.Run("names that aren't file names are refused", func(t *testing.T) {
+		for _, name := range []string{"", "..", "../a", "sub/a"} {
+			session := newFetchSession(Options{PartFileName: func(part horizonpkg.DockerImagePart) string {
+				return name
+			}})
+
+			_, err := session.partFileNames(horizonpkg.DockerImageParts{"a": parts["a"]})
+			assert.NotNil(t, err, name)
+		}
+	})
+}
+
+func Test_PartPath_Suite(suite *testing.T) {
+	pkg := &horizonpkg.Pkg{
+		ID: "pkg",
+		Parts: horizonpkg.DockerImageParts{
+			"a": {ID: "a", Sha256sum: "aaa"},
+		},
+	}
+
+	suite.Run("part path is in the Pkg's directory", func(t *testing.T) {
+		partPath, err := PartPath("/dest", "pkg", "a")
+		assert.Nil(t, err)
+		assert.Equal(t, "/dest/pkg/a", partPath)
+
+		partPath, err = PartPathWithOptions("/dest", pkg, "a", Options{})
+		assert.Nil(t, err)
+		assert.Equal(t, "/dest/pkg/a", partPath)
+	})
+
+	suite.Run("relative destination is made absolute", func(t *testing.T) {
+		wd, err := os.Getwd()
+		assert.Nil(t, err)
+
+		partPath, err := PartPath("dest", "pkg", "a")
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(wd, "dest", "pkg", "a"), partPath)
+	})
+
+	suite.Run("part is named by the PartFileName", func(t *testing.T) {
+		partPath, err := PartPathWithOptions("/dest", pkg, "a", Options{PartFileName: func(part horizonpkg.DockerImagePart) string {
+			return part.Sha256sum + ".tgz"
+		}})
+		assert.Nil(t, err)
+		assert.Equal(t, "/dest/pkg/aaa.tgz", partPath)
+	})
+
+	suite.Run("names escaping the destination and unknown parts are refused", func(t *testing.T) {
+		for _, names := range [][]string{{"..", "a"}, {"pkg", "../a"}, {"pkg", ""}} {
+			_, err := PartPath("/dest", names[0], names[1])
+			assert.NotNil(t, err, names)
+		}
+
+		_, err := PartPathWithOptions("/dest", pkg, "b", Options{})
+		assert.NotNil(t, err)
+	})
+}
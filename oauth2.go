@@ -0,0 +1,226 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keys of the credentials of a source requiring OAuth2 bearer tokens obtained
+// with the client credentials grant
+const (
+	oauth2TokenURL     = "oauth2_token_url"
+	oauth2ClientID     = "oauth2_client_id"
+	oauth2ClientSecret = "oauth2_client_secret"
+	oauth2Scope        = "oauth2_scope"
+)
+
+// tokens are refreshed this long before they expire so that they don't expire
+// in flight
+const tokenExpiryMargin = 30 * time.Second
+
+// oauth2Credentials identifies the client and token endpoint used to obtain
+// bearer tokens
+type oauth2Credentials struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+}
+
+// oauth2CredentialsFrom returns the OAuth2 client credentials in creds, if it
+// has any
+func oauth2CredentialsFrom(creds map[string]string) (*oauth2Credentials, bool) {
+	if creds[oauth2TokenURL] == "" || creds[oauth2ClientID] == "" {
+		return nil, false
+	}
+
+	return &oauth2Credentials{
+		tokenURL:     creds[oauth2TokenURL],
+		clientID:     creds[oauth2ClientID],
+		clientSecret: creds[oauth2ClientSecret],
+		scope:        creds[oauth2Scope],
+	}, true
+}
+
+func (c *oauth2Credentials) key() string {
+	return c.tokenURL + " " + c.clientID + " " + c.scope
+}
+
+type oauth2Token struct {
+	value   string
+	expires time.Time
+}
+
+// tokenCache obtains bearer tokens lazily and caches them until they expire
+// or are rejected. It is shared by the sessions of Pkgs fetched together.
+type tokenCache struct {
+	lock   sync.Mutex
+	client *http.Client
+	tokens map[string]oauth2Token
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		tokens: make(map[string]oauth2Token),
+	}
+}
+
+// token returns a current token for creds, requesting one from its token
+// endpoint if none is cached
+func (c *tokenCache) token(ctx context.Context, creds *oauth2Credentials, session *fetchSession) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if cached, ok := c.tokens[creds.key()]; ok && (cached.expires.IsZero() || time.Now().Before(cached.expires)) {
+		return cached.value, nil
+	}
+
+	if c.client == nil {
+		return "", fmt.Errorf("No HTTP client configured to request OAuth2 token from %v", creds.tokenURL)
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if creds.scope != "" {
+		form.Set("scope", creds.scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(creds.clientID), url.QueryEscape(creds.clientSecret))
+
+	session.log.Infof(3, "Requesting OAuth2 token for client %v from %v", creds.clientID, creds.tokenURL)
+
+	response, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to request OAuth2 token from %v. Error: %v", creds.tokenURL, err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read OAuth2 token response from %v. Error: %v", creds.tokenURL, err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OAuth2 token request to %v failed with HTTP status code %v", creds.tokenURL, response.StatusCode)
+	}
+
+	var granted struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &granted); err != nil {
+		return "", fmt.Errorf("Unable to parse OAuth2 token response from %v. Error: %v", creds.tokenURL, err)
+	}
+
+	if granted.AccessToken == "" || (granted.TokenType != "" && !strings.EqualFold(granted.TokenType, "bearer")) {
+		return "", fmt.Errorf("OAuth2 token response from %v has no bearer token", creds.tokenURL)
+	}
+
+	token := oauth2Token{value: granted.AccessToken}
+	if granted.ExpiresIn > 0 {
+		token.expires = time.Now().Add(time.Duration(granted.ExpiresIn)*time.Second - tokenExpiryMargin)
+	}
+	c.tokens[creds.key()] = token
+
+	return token.value, nil
+}
+
+// invalidate forgets the cached token for creds if it is rejected, unless it
+// has already been replaced
+func (c *tokenCache) invalidate(creds *oauth2Credentials, rejected string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if cached, ok := c.tokens[creds.key()]; ok && cached.value == rejected {
+		delete(c.tokens, creds.key())
+	}
+}
+
+// matchingOAuth2Credentials returns the OAuth2 credentials in authCreds used
+// for requests to reqURL, if any; as with other credentials, the first
+// matching prefix wins
+func matchingOAuth2Credentials(reqURL string, authCreds map[string]map[string]string) (*oauth2Credentials, bool) {
+	for prefix, creds := range authCreds {
+		if oauth2Creds, ok := oauth2CredentialsFrom(creds); ok && strings.HasPrefix(reqURL, prefix) {
+			return oauth2Creds, true
+		}
+	}
+
+	return nil, false
+}
+
+// tokenRefreshTransport retries requests whose OAuth2 bearer token is
+// rejected with a 401 once, with a newly obtained token
+type tokenRefreshTransport struct {
+	authCreds map[string]map[string]string
+	session   *fetchSession
+	next      http.RoundTripper
+}
+
+func (t *tokenRefreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, err := t.next.RoundTrip(req)
+	if err != nil || response.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return response, err
+	}
+
+	creds, ok := matchingOAuth2Credentials(req.URL.String(), t.authCreds)
+	if !ok {
+		return response, err
+	}
+
+	t.session.log.Infof(3, "OAuth2 token rejected by %v, refreshing it", req.URL.String())
+	t.session.tokens.invalidate(creds, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+
+	token, tokenErr := t.session.tokens.token(req.Context(), creds, t.session)
+	if tokenErr != nil {
+		t.session.log.Errorf("Failed to refresh OAuth2 token for %v. Error: %v", req.URL.String(), tokenErr)
+		return response, err
+	}
+	response.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+
+	return t.next.RoundTrip(retry)
+}
+
+// withTokenRefresh returns client with a transport that refreshes rejected
+// OAuth2 tokens of sources configured in authCreds
+func withTokenRefresh(client *http.Client, authCreds map[string]map[string]string, session *fetchSession) *http.Client {
+	configured := false
+	for _, creds := range authCreds {
+		if _, ok := oauth2CredentialsFrom(creds); ok {
+			configured = true
+		}
+	}
+
+	if !configured {
+		return client
+	}
+
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	refreshing := *client
+	refreshing.Transport = &tokenRefreshTransport{authCreds, session, next}
+	return &refreshing
+}
@@ -0,0 +1,124 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_OAuth2_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-oauth2-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	var grants int32
+	var lock sync.Mutex
+	var current string
+	var expiresIn int
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		current = fmt.Sprintf("token-%d", atomic.AddInt32(&grants, 1))
+		fmt.Fprintf(w, `{"access_token": %q, "token_type": "Bearer", "expires_in": %d}`, current, expiresIn)
+	}))
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer "+current {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	authCreds := map[string]map[string]string{
+		server.URL: {oauth2TokenURL: tokenServer.URL, oauth2ClientID: "client", oauth2ClientSecret: "secret"},
+	}
+
+	reset := func(expires int) {
+		atomic.StoreInt32(&grants, 0)
+		lock.Lock()
+		current, expiresIn = "", expires
+		lock.Unlock()
+	}
+
+	fetch := func(client *http.Client, session *fetchSession, name string) error {
+		return fetchPkgPart(context.Background(), client, authCreds, server.URL, name, path.Join(tmpDir, name), 7, "", []horizonpkg.PartSource{{URL: "/" + name}}, session)
+	}
+
+	suite.Run("token is obtained once and reused", func(t *testing.T) {
+		session := newFetchSession(Options{})
+		client, err := session.configureClient(&http.Client{}, authCreds)
+		assert.Nil(t, err)
+		reset(3600)
+
+		assert.Nil(t, fetch(client, session, "a"))
+		assert.Nil(t, fetch(client, session, "b"))
+		assert.EqualValues(t, 1, atomic.LoadInt32(&grants))
+	})
+
+	suite.Run("expired token is refreshed", func(t *testing.T) {
+		session := newFetchSession(Options{})
+		client, err := session.configureClient(&http.Client{}, authCreds)
+		assert.Nil(t, err)
+
+		// tokens expiring within the margin are never reused
+		reset(1)
+
+		assert.Nil(t, fetch(client, session, "c"))
+		assert.Nil(t, fetch(client, session, "d"))
+		assert.EqualValues(t, 2, atomic.LoadInt32(&grants))
+	})
+
+	suite.Run("rejected token is refreshed and the request retried", func(t *testing.T) {
+		session := newFetchSession(Options{})
+		client, err := session.configureClient(&http.Client{}, authCreds)
+		assert.Nil(t, err)
+		reset(3600)
+
+		assert.Nil(t, fetch(client, session, "e"))
+
+		// revoke the token
+		lock.Lock()
+		current = "revoked"
+		lock.Unlock()
+
+		assert.Nil(t, fetch(client, session, "f"))
+		assert.EqualValues(t, 2, atomic.LoadInt32(&grants))
+	})
+
+	suite.Run("failure to obtain a token is an auth error", func(t *testing.T) {
+		badCreds := map[string]map[string]string{
+			server.URL: {oauth2TokenURL: tokenServer.URL, oauth2ClientID: "client", oauth2ClientSecret: "wrong"},
+		}
+		session := newFetchSession(Options{})
+		_, err := session.configureClient(&http.Client{}, badCreds)
+		assert.Nil(t, err)
+
+		_, err = authenticatedRequest(context.Background(), server.URL+"/g", badCreds, session)
+		assert.IsType(t, fetcherrors.PkgSourceFetchAuthError{}, err)
+	})
+}
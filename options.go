@@ -0,0 +1,593 @@
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"golang.org/x/time/rate"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Options holds optional configuration for a Pkg fetch. The zero value
+// preserves the default fetch behavior.
+type Options struct {
+	// RaceSources is the number of a part's sources (taken in order) to
+	// request concurrently; the first to respond successfully is used and the
+	// others are canceled. Values less than 2 disable racing so sources are
+	// tried one after another.
+	RaceSources int
+
+	// SourceSelection is how the order in which a part's sources are tried is
+	// chosen; if empty, it is ORDERED.
+	SourceSelection SourceSelection
+
+	// BytesPerSecond caps the aggregate download rate of all parts fetched
+	// concurrently in a Pkg fetch. 0 means unlimited.
+	BytesPerSecond int64
+
+	// Metrics receives measurements of the fetch; if nil, measurements are
+	// discarded.
+	Metrics MetricsSink
+
+	// Logger receives the fetch's log output; if nil, output is written to
+	// glog.
+	Logger Logger
+
+	// UserAgent is sent as the User-Agent header of all requests; if empty,
+	// DefaultUserAgent is sent.
+	UserAgent string
+
+	// ProxyURL is the proxy through which Pkg meta and part requests are
+	// sent. If nil, clients that don't configure a proxy use the one named by
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	ProxyURL *url.URL
+
+	// StallTimeout is the longest a part download may go without receiving
+	// any bytes; a stalled download is aborted and the part's next source is
+	// tried. 0 disables stall detection.
+	StallTimeout time.Duration
+
+	// DNSCacheTTL is how long the addresses to which a host resolves are
+	// cached by a fetch (and the others of a FetchMany) so that parts fetched
+	// while DNS is briefly unavailable reuse them rather than each failing.
+	// Keep it short so stale records aren't used for long. 0 disables
+	// caching.
+	DNSCacheTTL time.Duration
+
+	// ResolveHosts pins hosts to fixed IP addresses, e.g. for sites without
+	// reliable DNS: requests to a pinned host are dialed to its address
+	// without resolving it. TLS certificates are still verified against the
+	// host name.
+	ResolveHosts map[string]string
+
+	// AllowedHosts, if set, are the only hosts parts may be fetched from,
+	// whatever sources the Pkg meta lists: sources on other hosts are skipped
+	// and a part none of whose sources are allowed fails to fetch. A host
+	// beginning with "." matches any of its subdomains, e.g.
+	// ".mirrors.example.com". Redirects to other hosts aren't followed. Local
+	// file sources are refused unless AllowLocalSources is set.
+	AllowedHosts []string
+
+	// DeniedHosts are hosts parts are never fetched from, matched as
+	// AllowedHosts are; a host that is both allowed and denied is denied
+	DeniedHosts []string
+
+	// AllowLocalSources permits file:// part sources when AllowedHosts or
+	// DeniedHosts constrain the hosts parts are fetched from. Redirects to
+	// file:// URLs are never followed.
+	AllowLocalSources bool
+
+	// UnixSockets maps hosts to the paths of Unix domain sockets, e.g. of a
+	// local Pkg proxy, over which requests to them are sent rather than TCP:
+	// with {"pkg-proxy": "/run/pkg-proxy.sock"} a pkgURL of
+	// http://pkg-proxy/pkgs/pkg.json is fetched through the socket. Requests
+	// to other hosts are unaffected.
+	UnixSockets map[string]string
+
+	// MaxIdleConnsPerHost is the number of idle connections to each host
+	// kept for reuse by the part fetches of a Pkg. If 0, 16 are kept.
+	MaxIdleConnsPerHost int
+
+	// Retry decides retries of part sources whose requests fail, e.g. a
+	// RetryPolicy; if nil, they aren't retried.
+	Retry Retrier
+
+	// StatusClasses classifies the failed responses of part sources with the
+	// given HTTP statuses, overriding their default classes, e.g. to have a
+	// registry's 418 retried as TRANSIENT or its 451 fail the fetch as AUTH.
+	// Other statuses keep their defaults.
+	StatusClasses map[int]StatusClass
+
+	// PartIDs selects the parts of the Pkg to fetch by ID; each must exist in
+	// the Pkg. If empty, all parts are fetched.
+	PartIDs []string
+
+	// HeadPrecheck enables a HEAD request to the first source tried for each
+	// part before any part is downloaded, confirming that the source is
+	// reachable and reports the part's expected size. Problems are returned together in
+	// a single PkgPrecheckError.
+	HeadPrecheck bool
+
+	// PartTimeout returns the time allowed to fetch a part of the given size
+	// from all of its sources. If nil, or if it returns a timeout that isn't
+	// positive for a part, DefaultPartTimeout is used.
+	PartTimeout func(bytes int64) time.Duration
+
+	// KeepFailedArtifacts keeps part files that fail size or hash checks for
+	// debugging: rather than being deleted they are renamed with the suffix
+	// ".corrupt" (replacing any earlier such file).
+	KeepFailedArtifacts bool
+
+	// MaxConcurrentParts is the most part downloads run at once; it is shared
+	// by all Pkgs fetched by a Fetcher's FetchMany. 0 means unlimited. Each
+	// download holds at most one part file and one response open at a time,
+	// so with VerifyConcurrency it caps the file descriptors a fetch uses.
+	MaxConcurrentParts int
+
+	// VerifyConcurrency is the most parts of a Pkg verified at once. If 0,
+	// GOMAXPROCS parts are.
+	VerifyConcurrency int
+
+	// FileMode is the permissions of the part, meta and bookkeeping files a
+	// fetch creates and DirMode those of the directories it creates, before
+	// the process's umask is applied; existing files and directories are left
+	// as they are. If 0, they are 0600 and 0700 so that only the fetching
+	// user can read what was fetched. Wider modes, e.g. 0640 and 0750 for a
+	// group of services that use the parts, let those users read parts and
+	// meta; any that can also write to them can replace what was verified,
+	// so write permission should never be granted to others.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// FileSystem is the filesystem into which Pkgs are fetched; if nil, the
+	// operating system's is used.
+	FileSystem FileSystem
+
+	// ConditionalRequests records the ETag and Last-Modified validators of
+	// each part's response in a "<part>.fetch" manifest alongside the part
+	// file. An existing part file with a manifest is then revalidated with a
+	// conditional request to its source rather than trusted for its size: it
+	// is reused if the source responds 304 Not Modified and its content
+	// matches the Pkg meta, and fetched again otherwise. Parts written to a
+	// Destination are never revalidated.
+	ConditionalRequests bool
+
+	// ExistingParts is how an existing part file of the expected size is
+	// checked before it's reused; if empty, it is CHECK_DIGEST.
+	ExistingParts ExistingPartCheck
+
+	// Destination, if set, is the target into which parts are written in
+	// place of the FileSystem, e.g. an object store; Pkg meta is still
+	// written to the FileSystem. Parts written to a Destination are never
+	// resumed, linked or quarantined.
+	Destination Destination
+
+	// StagingDir, if set, is the directory parts are downloaded into, e.g. a
+	// tmpfs, before they're moved into the Pkg's directory of the destination:
+	// each part is downloaded and verified in a directory of the Pkg's name
+	// under StagingDir, then renamed into place or, if StagingDir is on
+	// another filesystem, copied and removed. Only verified parts reach the
+	// destination. If empty, parts are downloaded into the destination
+	// directly. Parts written to a Destination are never staged.
+	StagingDir string
+
+	// ResumeDownloads downloads parts into ".part" files that are kept if a
+	// download stalls or the fetch is interrupted, and resumes them from the
+	// next source or fetch with HTTP Range requests. Encoded parts are always
+	// downloaded whole. The parts verified by a fetch are recorded in the
+	// Pkg's directory until it completes so that if it's interrupted, e.g. by
+	// a restart of the process, the next fetch of the Pkg neither fetches nor
+	// verifies them again unless their files have changed.
+	ResumeDownloads bool
+
+	// HashCheckpointBytes is how often, in bytes downloaded, the hash state of
+	// a resumable download is saved alongside its ".part" file so resuming it
+	// doesn't require re-reading what was already downloaded. If 0, it is
+	// saved every 64 MiB.
+	HashCheckpointBytes int64
+
+	// ParallelChunks and ChunkBytes download each part larger than ChunkBytes
+	// in chunks of ChunkBytes, up to ParallelChunks of them at once, with
+	// range requests to its first source, e.g. to saturate a high-latency
+	// link that a single stream doesn't. The assembled part is verified like
+	// any other. Parts whose first source doesn't advertise Accept-Ranges, or
+	// that are encoded, resumed or written to a Destination, are downloaded
+	// in a single stream, as are all parts if ParallelChunks is less than 2
+	// or ChunkBytes is 0.
+	ParallelChunks int
+	ChunkBytes     int64
+
+	// Timeout caps the time to fetch a Pkg: its meta, parts and their
+	// verification. When it passes, outstanding work is canceled and a
+	// PkgFetchDeadlineError is returned; each part's PartTimeout is cut short
+	// to the time remaining. 0 means no limit.
+	Timeout time.Duration
+
+	// DebugDump enables a structured dump of each Pkg fetch for debugging: its
+	// raw meta and each part's sources, expected size and hash and the outcome
+	// of its fetch. Credentials and URL userinfo are redacted. The dump is
+	// logged at verbosity 9 unless DebugDumpWriter is set.
+	DebugDump bool
+
+	// DebugDumpWriter receives the debug dump of each Pkg fetch, if enabled,
+	// as a JSON document followed by a newline.
+	DebugDumpWriter io.Writer
+
+	// Hooks are called at points in the lifecycle of each part fetched; if
+	// nil, none are.
+	Hooks *PartHooks
+
+	// BeforeFetch, if set, is called with the Pkg once its meta is verified
+	// and its parts prechecked, before any part is downloaded, e.g. to check
+	// the image tags it provides against an allowlist. If it returns an
+	// error the fetch is aborted with a PkgRejectedError wrapping it.
+	BeforeFetch func(pkg *horizonpkg.Pkg) error
+
+	// MinSourcesPerPart is the fewest sources with distinct URLs each part
+	// of a Pkg must have; Pkgs with parts that have fewer are refused with a
+	// PkgPrecheckError naming them before any part is downloaded. Values less
+	// than 2 disable the check.
+	MinSourcesPerPart int
+
+	// RequireImageParts refuses Pkgs whose meta provides images that have no
+	// part, which would never be fetched, with a PkgPrecheckError naming them
+	// before any part is downloaded.
+	RequireImageParts bool
+
+	// MaxTotalBytes is the largest total size of the parts of a Pkg that will
+	// be fetched; Pkgs whose parts are larger are refused before any part is
+	// downloaded. 0 means unlimited.
+	MaxTotalBytes int64
+
+	// BytesBudget is the most bytes of part content a fetch may download,
+	// e.g. what remains of a metered device's data allowance. A fetch whose
+	// parts not already in the destination total more is refused before any
+	// part is downloaded, and one whose sources serve more than expected is
+	// aborted once it has downloaded more, with a PkgBudgetExceededError
+	// reporting the bytes downloaded. 0 means unlimited.
+	BytesBudget int64
+
+	// PartFileName returns the name of the file in the Pkg's directory that a
+	// part is written to, e.g. one named by its sha256sum for a content
+	// addressed store. It must be a file name, not a path, and parts with
+	// different content must have different names. If nil, parts are written
+	// to files named by their names in the Pkg.
+	PartFileName func(part horizonpkg.DockerImagePart) string
+
+	// IPFSGateway is the base URL of the HTTP gateway, e.g.
+	// https://ipfs.example.com, through which part sources addressed by IPFS
+	// CID are fetched: those with URLs ipfs://<cid>[/<path>] and, if it's set,
+	// /ipfs/<cid>[/<path>], which would otherwise be paths on the Pkg's domain.
+	// Their content is verified like that of any other source. If empty, such
+	// ipfs:// sources fail and are skipped.
+	IPFSGateway string
+
+	// MetaSignatures are detached signatures of the Pkg meta supplied out of
+	// band, e.g. from a signed index served separately from the Pkg. The meta
+	// is verified if any of them or the pkgURLSignature given to the fetch
+	// matches a key; with them the pkgURLSignature may be empty.
+	MetaSignatures []string
+
+	// PartSignatures are detached signatures of the Pkg's parts supplied out
+	// of band, by part name. A part with signatures here is verified with
+	// them in place of the signatures in the Pkg meta.
+	PartSignatures map[string][]string
+
+	// PartIndex, if set, is a signed index of the digests of the Pkg's parts
+	// whose signatures are verified once in place of those of each part: a
+	// part whose sha256 in the index matches the Pkg meta is verified by
+	// hashing it alone, e.g. to spare constrained CPUs an RSA verification
+	// per part. Parts the index doesn't list are verified with their own
+	// signatures. A fetch with an index that fails verification fails.
+	PartIndex *PartIndex
+
+	// MinSequence and MinCreateTS guard against rollback to older Pkg meta
+	// replayed with a valid signature. If MinSequence is set, meta whose
+	// Meta.Sequence is lower, or which has none, is refused with a
+	// PkgRollbackError; likewise, if MinCreateTS is set, meta whose
+	// Meta.CreateTS is earlier. Callers typically pass the Sequence or
+	// CreateTS of the last Pkg fetched. 0 disables each check.
+	MinSequence uint64
+	MinCreateTS int64
+
+	// MaxMetadataBytes and MaxParts bound the Pkg meta read from a source,
+	// which is untrusted until its signature is verified: meta larger than
+	// MaxMetadataBytes once decompressed, that describes more than MaxParts
+	// parts, or that is nested too deeply or has duplicate keys is refused
+	// with a PkgMetaError. If 0, they are 16 MiB and 10000 parts.
+	MaxMetadataBytes int64
+	MaxParts         int
+
+	// PrimarySigningKeys are the paths of further primary signing keys with
+	// which Pkg meta, part indexes and parts are verified, e.g. both the old
+	// and new keys while they're rotated. Keys are tried in order: the
+	// primarySigningKey given to the fetch, then these, then the .pem files of
+	// the user keys directory, ed25519 keys before RSA ones; content signed by
+	// any of them verifies. Each key file is parsed once per fetch.
+	PrimarySigningKeys []string
+
+	// ValidateSigningKeys checks the signing keys before a fetch makes any
+	// request or writes anything: each primary signing key and every .pem
+	// file in the user keys directory, if one is given, must hold an ed25519
+	// or RSA public key, and, unless signature verification is skipped,
+	// there must be at least one key. A PkgSigningKeyError is returned
+	// otherwise. By default keys are loaded when a signature is
+	// first verified and unusable ones are skipped.
+	ValidateSigningKeys bool
+
+	// InsecureSkipSignatureVerification DISABLES verification of the
+	// signatures of Pkg meta and parts: anyone able to serve or alter them
+	// can have arbitrary content fetched and trusted. It permits fetching
+	// without a Pkg signature for local development and testing on trusted
+	// networks; parts are still checked against their hashes. Never set it
+	// in production.
+	InsecureSkipSignatureVerification bool
+}
+
+const defaultMaxIdleConnsPerHost = 16
+
+// fetchSession holds the Options of a single Pkg fetch and the state shared
+// by all of its part fetches.
+type fetchSession struct {
+	opts    Options
+	limiter *rate.Limiter
+	metrics MetricsSink
+	log     Logger
+	fs      FileSystem
+
+	// shared by the sessions of Pkgs fetched together
+	partSlots chan struct{}
+	content   *contentRegistry
+	dumpLock  *sync.Mutex
+	tokens    *tokenCache
+	resolver  *dnsCache
+
+	// public keys, parsed once per fetch
+	keys *keyCache
+
+	// set if the caller verified the Pkg's meta, which isn't fetched
+	trustedPkg *horizonpkg.Pkg
+
+	// set once the Pkg meta is fetched and verified: the verified meta or, if
+	// it was streamed to disk, the path of its file and its sha256
+	pkgID            string
+	verifiedMeta     []byte
+	verifiedMetaPath string
+	verifiedMetaSum  []byte
+
+	// set once the Pkg's parts are prechecked, by part name
+	parts     horizonpkg.DockerImageParts
+	partFiles map[string]string
+
+	// absolute paths of the parts fetched and verified, by part name
+	fetchedParts map[string]string
+
+	// hex sha256 of the content of the parts fetched and verified, by part name
+	fetchedSums map[string]string
+
+	// set if the session's Pkg fetch is dumped for debugging
+	dump *debugDump
+
+	// tracks parts for the Hooks
+	lifecycle *partLifecycle
+
+	// called with the outcome of each part of a streamed fetch
+	onPart func(PartResult)
+
+	// set if existing files are only verified, never changed
+	verifyOnly bool
+
+	// part bytes received from sources and satisfied by files already on
+	// disk, updated atomically
+	downloadedBytes int64
+	reusedBytes     int64
+
+	// hashes of parts computed as they were downloaded, by part path
+	hashesLock sync.Mutex
+	hashes     map[string]map[horizonpkg.DigestAlgorithm]hash.Hash
+
+	// hex sha256 of the content of verified parts, by part path
+	verifiedSums map[string]string
+
+	// hex sha256 of the parts vouched for by the verified PartIndex
+	indexedSums map[string]bool
+
+	// signatures of parts fetched from their sources' sidecars, by part name
+	sidecarLock       sync.Mutex
+	sidecarSignatures map[string][]string
+
+	// transports created for the session's client, whose idle connections
+	// are closed once it's done
+	transportsLock sync.Mutex
+	transports     []*http.Transport
+
+	// mirrors listed by the mirror indexes fetched, by index URL
+	mirrorsLock   sync.Mutex
+	mirrorIndexes map[string][]horizonpkg.PartSource
+}
+
+func newFetchSession(opts Options) *fetchSession {
+	metrics := opts.Metrics
+	if metrics == nil {
+		metrics = NoopMetricsSink{}
+	}
+
+	log := opts.Logger
+	if log == nil {
+		log = GlogLogger{}
+	}
+
+	fs := opts.FileSystem
+	if fs == nil {
+		fs = OSFileSystem{}
+	}
+
+	var partSlots chan struct{}
+	if opts.MaxConcurrentParts > 0 {
+		partSlots = make(chan struct{}, opts.MaxConcurrentParts)
+	}
+
+	return &fetchSession{
+		opts:      opts,
+		limiter:   newBandwidthLimiter(opts.BytesPerSecond),
+		metrics:   metrics,
+		log:       log,
+		fs:        fs,
+		partSlots: partSlots,
+		content:   newContentRegistry(),
+		dumpLock:  &sync.Mutex{},
+		tokens:    newTokenCache(),
+		resolver:  newDNSCache(),
+		keys:      newKeyCache(),
+		lifecycle: newPartLifecycle(),
+	}
+}
+
+// fileMode returns the permissions of the files the session creates
+func (s *fetchSession) fileMode() os.FileMode {
+	if s.opts.FileMode != 0 {
+		return s.opts.FileMode
+	}
+
+	return 0600
+}
+
+// dirMode returns the permissions of the directories the session creates
+func (s *fetchSession) dirMode() os.FileMode {
+	if s.opts.DirMode != 0 {
+		return s.opts.DirMode
+	}
+
+	return 0700
+}
+
+// verifyConcurrency returns the number of parts of a Pkg to verify at once
+func (s *fetchSession) verifyConcurrency() int {
+	if s.opts.VerifyConcurrency > 0 {
+		return s.opts.VerifyConcurrency
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+// maxMetadataBytes returns the size of the largest Pkg meta that is read
+func (s *fetchSession) maxMetadataBytes() int64 {
+	if s.opts.MaxMetadataBytes > 0 {
+		return s.opts.MaxMetadataBytes
+	}
+
+	return defaultMaxMetadataBytes
+}
+
+// maxParts returns the most parts Pkg meta may describe
+func (s *fetchSession) maxParts() int {
+	if s.opts.MaxParts > 0 {
+		return s.opts.MaxParts
+	}
+
+	return defaultMaxParts
+}
+
+// hashCheckpointBytes returns the interval at which the hash state of a
+// resumable download is checkpointed
+func (s *fetchSession) hashCheckpointBytes() int64 {
+	if s.opts.HashCheckpointBytes > 0 {
+		return s.opts.HashCheckpointBytes
+	}
+
+	return defaultHashCheckpointBytes
+}
+
+// recordHashes records the hashes of the part at partPath computed as it was
+// downloaded
+func (s *fetchSession) recordHashes(partPath string, hashers map[horizonpkg.DigestAlgorithm]hash.Hash) {
+	s.hashesLock.Lock()
+	defer s.hashesLock.Unlock()
+
+	if s.hashes == nil {
+		s.hashes = make(map[string]map[horizonpkg.DigestAlgorithm]hash.Hash)
+	}
+	s.hashes[partPath] = hashers
+}
+
+// takeHashes returns and forgets the recorded hashes of the part at
+// partPath, or nil if there are none. Hashes of parts in a Destination are
+// kept since they can't be computed again for the other parts sharing the
+// part's file.
+func (s *fetchSession) takeHashes(partPath string) map[horizonpkg.DigestAlgorithm]hash.Hash {
+	s.hashesLock.Lock()
+	defer s.hashesLock.Unlock()
+
+	hashers := s.hashes[partPath]
+	if s.opts.Destination == nil {
+		delete(s.hashes, partPath)
+	}
+	return hashers
+}
+
+// recordVerifiedSum records the sha256 of the content of the part at partPath
+// once it's verified
+func (s *fetchSession) recordVerifiedSum(partPath string, sum string) {
+	s.hashesLock.Lock()
+	defer s.hashesLock.Unlock()
+
+	if s.verifiedSums == nil {
+		s.verifiedSums = make(map[string]string)
+	}
+	s.verifiedSums[partPath] = sum
+}
+
+// verifiedSum returns the sha256 recorded for the part at partPath, or "" if
+// none was
+func (s *fetchSession) verifiedSum(partPath string) string {
+	s.hashesLock.Lock()
+	defer s.hashesLock.Unlock()
+
+	return s.verifiedSums[partPath]
+}
+
+// forPkg returns a session for fetching another Pkg that shares this
+// session's limits and downloaded content
+func (s *fetchSession) forPkg() *fetchSession {
+	return &fetchSession{
+		opts:      s.opts,
+		limiter:   s.limiter,
+		metrics:   s.metrics,
+		log:       s.log,
+		fs:        s.fs,
+		partSlots: s.partSlots,
+		content:   s.content,
+		dumpLock:  s.dumpLock,
+		tokens:    s.tokens,
+		resolver:  s.resolver,
+		keys:      newKeyCache(),
+		lifecycle: newPartLifecycle(),
+	}
+}
+
+// acquirePart waits for a part download slot, returning ctx's error if it is
+// done first. Each successful call must be followed by a call to releasePart.
+func (s *fetchSession) acquirePart(ctx context.Context) error {
+	if s.partSlots == nil {
+		return nil
+	}
+
+	select {
+	case s.partSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *fetchSession) releasePart() {
+	if s.partSlots != nil {
+		<-s.partSlots
+	}
+}
@@ -0,0 +1,137 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+)
+
+// PartAction is a faux-enum identifying what a fetch would do with a Pkg part
+type PartAction string
+
+const (
+	// SKIP indicates the part is already present and valid on disk
+	SKIP PartAction = "SKIP"
+
+	// DOWNLOAD indicates the part is missing or invalid on disk and would be
+	// downloaded
+	DOWNLOAD PartAction = "DOWNLOAD"
+)
+
+// PartPlan describes what a fetch would do with a single Pkg part
+type PartPlan struct {
+	ID     string     `json:"id"`
+	Path   string     `json:"path"`
+	Bytes  int64      `json:"bytes"`
+	Action PartAction `json:"action"`
+}
+
+// Plan describes what a fetch of a Pkg would do. DownloadBytes is the total
+// size of the parts that would be downloaded.
+type Plan struct {
+	Pkg           *horizonpkg.Pkg     `json:"pkg"`
+	Parts         map[string]PartPlan `json:"parts"`
+	DownloadBytes int64               `json:"download_bytes"`
+}
+
+// PkgPlan fetches and verifies the pkg metadata file at the given URL, prechecks
+// it and reports which of its parts PkgFetchWithOptions would skip and which
+// it would download into destinationDir. No part is downloaded and nothing is
+// written to disk. Arguments are as for PkgFetchWithOptions.
+//
+// Deprecated: use PkgPlanContext, which can be canceled.
+func PkgPlan(httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string, opts Options) (*Plan, error) {
+	return PkgPlanContext(context.Background(), httpClientFactory, pkgURL, pkgURLSignature, destinationDir, primarySigningKey, userKeysDir, authCreds, opts)
+}
+
+// PkgPlanContext behaves like PkgPlan but aborts fetching the meta and
+// hashing existing parts, returning ctx's error, once ctx is done.
+func PkgPlanContext(ctx context.Context, httpClientFactory func(overrideTimeoutS *uint) *http.Client, pkgURL url.URL, pkgURLSignature string, destinationDir string, primarySigningKey string, userKeysDir string, authCreds map[string]map[string]string, opts Options) (*Plan, error) {
+	session := newFetchSession(opts)
+	client, err := session.configureClient(httpClientFactory(nil), authCreds)
+	if err != nil {
+		return nil, fetcherrors.PkgSourceError{"Failed configuring HTTP client", err}
+	}
+
+	if err := session.requireSignature(pkgURLSignature); err != nil {
+		return nil, err
+	}
+
+	pkgURL = localPkgURL(pkgURL)
+
+	pkg, _, err := fetchPkgMeta(ctx, client, authCreds, primarySigningKey, userKeysDir, pkgURL.String(), pkgURLSignature, destinationDir, false, session)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := precheckPkgParts(pkg, session.opts.PartIDs, session)
+	if err != nil {
+		return nil, fetcherrors.PkgPrecheckError{"Failed to validate Pkg information before fetching", err}
+	}
+
+	plan := &Plan{
+		Pkg:   pkg,
+		Parts: make(map[string]PartPlan),
+	}
+
+	pkgDestinationDir := path.Join(destinationDir, pkg.ID)
+	downloading := make(map[string]bool)
+
+	for name, part := range parts {
+		partPath := session.partPath(pkgDestinationDir, name)
+
+		present, err := partPresent(ctx, partPath, part, session)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		} else if err != nil {
+			return nil, fetcherrors.PkgSourceError{fmt.Sprintf("Failed inspecting existing part %v", partPath), err}
+		}
+
+		action := DOWNLOAD
+		if present {
+			action = SKIP
+		} else if !downloading[part.Sha256sum] || part.Sha256sum == "" {
+			// parts with identical content are only downloaded once
+			downloading[part.Sha256sum] = true
+			plan.DownloadBytes += part.Bytes
+		}
+
+		session.log.Infof(4, "Planned action %v for part %v at %v", action, name, partPath)
+		plan.Parts[name] = PartPlan{
+			ID:     name,
+			Path:   partPath,
+			Bytes:  part.Bytes,
+			Action: action,
+		}
+	}
+
+	return plan, nil
+}
+
+// partPresent reports whether the file at partPath exists with the part's
+// expected size and content. Parts in a Destination that can't be read back
+// can't be shown to have it.
+func partPresent(ctx context.Context, partPath string, part horizonpkg.DockerImagePart, session *fetchSession) (bool, error) {
+	size, err := session.partSize(partPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if size != part.Bytes || !session.partsReadable() {
+		return false, nil
+	}
+
+	hashers, err := hashPart(ctx, partPath, part.Digests, session)
+	if err != nil {
+		return false, err
+	}
+
+	return digestMatches(part.Sha256sum, part.Digests, hashers), nil
+}
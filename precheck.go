@@ -0,0 +1,80 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// headPrecheckParts issues a HEAD request to the first source tried for each
+// part to confirm that it is reachable and that the Content-Length it reports
+// matches the part's expected size (the size of encoded parts can't be
+// checked). All problems found are returned in a single error.
+func headPrecheckParts(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, pkgURLBase string, parts horizonpkg.DockerImageParts, session *fetchSession) error {
+	var problems []string
+	var lock sync.Mutex
+
+	addProblem := func(format string, args ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	var group sync.WaitGroup
+
+	for name, part := range parts {
+		if len(part.Sources) == 0 {
+			addProblem("part %v has no sources", name)
+			continue
+		}
+
+		group.Add(1)
+
+		go func(name string, part horizonpkg.DockerImagePart) {
+			defer group.Done()
+
+			sources, err := session.allowedSources(pkgURLBase, name, prioritizedSources(part.Sources))
+			if err != nil {
+				addProblem("part %v: %v", name, err)
+				return
+			}
+			pURL := partSourceURL(pkgURLBase, sources[0], session)
+
+			req, err := authenticatedRequest(session.withStatusClasses(partRequests(ctx)), pURL, authCreds, session)
+			if err != nil {
+				addProblem("part %v: %v", name, err)
+				return
+			}
+			req.Method = http.MethodHead
+
+			session.log.Infof(5, "Prechecking part %v with HEAD request to %v", name, pURL)
+
+			response, err := client.Do(req)
+			if err != nil {
+				addProblem("part %v: source %v is unreachable: %v", name, pURL, err)
+				return
+			}
+			response.Body.Close()
+
+			if response.StatusCode != http.StatusOK {
+				addProblem("part %v: source %v responded with HTTP status code %v", name, pURL, response.StatusCode)
+			} else if part.Encoding == "" && response.ContentLength >= 0 && response.ContentLength != part.Bytes {
+				addProblem("part %v: source %v reports Content-Length %v and part should be %v bytes", name, pURL, response.ContentLength, part.Bytes)
+			}
+		}(name, part)
+	}
+
+	group.Wait()
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("HEAD precheck of part sources failed: %v", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
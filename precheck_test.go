@@ -0,0 +1,116 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_HeadPrecheck_Suite(suite *testing.T) {
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("0123456789"))
+		}
+	}))
+	defer server.Close()
+
+	session := newFetchSession(Options{})
+
+	part := func(bytes int64, sourcePath string) horizonpkg.DockerImagePart {
+		return horizonpkg.DockerImagePart{
+			Bytes:   bytes,
+			Sources: []horizonpkg.PartSource{{URL: sourcePath}, {URL: "/unused"}},
+		}
+	}
+
+	suite.Run("matching sources pass with only HEAD requests", func(t *testing.T) {
+		err := headPrecheckParts(context.Background(), &http.Client{}, nil, server.URL, horizonpkg.DockerImageParts{"a": part(10, "/a"), "b": part(10, "/b")}, session)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 0, atomic.LoadInt32(&gets))
+	})
+
+	suite.Run("all problems are reported together", func(t *testing.T) {
+		err := headPrecheckParts(context.Background(), &http.Client{}, nil, server.URL, horizonpkg.DockerImageParts{
+			"sized":   part(11, "/sized"),
+			"missing": part(10, "/missing"),
+			"nosrc":   {Bytes: 10},
+		}, session)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "part sized")
+		assert.Contains(t, err.Error(), "part missing")
+		assert.Contains(t, err.Error(), "part nosrc")
+	})
+}
+
+func Test_MinSources_Suite(suite *testing.T) {
+	sources := func(urls ...string) []horizonpkg.PartSource {
+		var sources []horizonpkg.PartSource
+		for _, url := range urls {
+			sources = append(sources, horizonpkg.PartSource{URL: url})
+		}
+		return sources
+	}
+
+	parts := horizonpkg.DockerImageParts{
+		"mirrored": {ID: "mirrored", Sources: sources("/a", "https://mirror/a")},
+		"single":   {ID: "single", Sources: sources("/b")},
+		"repeated": {ID: "repeated", Sources: sources("/c", "/c")},
+	}
+
+	suite.Run("parts with fewer distinct sources than required are named", func(t *testing.T) {
+		err := checkMinSources(parts, 2)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "parts repeated, single have fewer than the required 2")
+		assert.NotContains(t, err.Error(), "mirrored")
+	})
+
+	suite.Run("parts with enough sources pass", func(t *testing.T) {
+		assert.Nil(t, checkMinSources(horizonpkg.DockerImageParts{"mirrored": parts["mirrored"]}, 2))
+	})
+
+	suite.Run("the check is disabled by default", func(t *testing.T) {
+		assert.Nil(t, checkMinSources(parts, 0))
+		assert.Nil(t, checkMinSources(horizonpkg.DockerImageParts{"nosrc": {ID: "nosrc"}}, 1))
+	})
+}
+
+func Test_ImageParts_Suite(suite *testing.T) {
+	pkg := &horizonpkg.Pkg{
+		ID: "pkg",
+		Meta: &horizonpkg.Meta{
+			Provides: horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{
+				"a": "image-a:latest",
+				"b": "image-b:latest",
+				"c": "image-c:latest",
+			}},
+		},
+		Parts: horizonpkg.DockerImageParts{"a": {ID: "a"}},
+	}
+
+	suite.Run("images without parts are named", func(t *testing.T) {
+		err := checkImageParts(pkg)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "images image-b:latest (part b), image-c:latest (part c) provided in Meta.Provides have no parts")
+	})
+
+	suite.Run("Pkg with a part for each image passes", func(t *testing.T) {
+		complete := *pkg
+		complete.Parts = horizonpkg.DockerImageParts{"a": {ID: "a"}, "b": {ID: "b"}, "c": {ID: "c"}}
+		assert.Nil(t, checkImageParts(&complete))
+	})
+}
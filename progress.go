@@ -0,0 +1,156 @@
+package fetch
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+)
+
+// fetchProgressName is the name of the file in a Pkg's directory recording
+// the parts verified by a resumable fetch that hasn't yet completed
+const fetchProgressName = ".fetch-progress"
+
+// verifiedPart records a part file that passed verification: the content it
+// was verified against and the size and modification time of the file when
+// it was, so that a file changed since isn't trusted
+type verifiedPart struct {
+	Sha256sum         string              `json:"sha256sum,omitempty"`
+	Digests           []horizonpkg.Digest `json:"digests,omitempty"`
+	Bytes             int64               `json:"bytes"`
+	ModTime           int64               `json:"mod_time"`
+	SignatureVerified bool                `json:"signature_verified"`
+
+	// ActualSha256sum is the sha256 of the file, which may differ from
+	// Sha256sum if the part was verified with one of its Digests
+	ActualSha256sum string `json:"actual_sha256sum,omitempty"`
+}
+
+// fetchProgress is the record of the parts of a Pkg verified by an
+// interrupted fetch with Options.ResumeDownloads. Parts it records are
+// neither fetched nor verified again by the next fetch of the Pkg; the record
+// is removed once the fetch completes.
+type fetchProgress struct {
+	lock     sync.Mutex
+	session  *fetchSession
+	path     string
+	Verified map[string]verifiedPart `json:"verified"`
+}
+
+// loadFetchProgress reads the progress of an earlier fetch into
+// pkgDestinationDir, if the session resumes downloads. nil is returned if it
+// doesn't; an unreadable record is ignored.
+func loadFetchProgress(pkgDestinationDir string, session *fetchSession) *fetchProgress {
+	if !session.opts.ResumeDownloads || session.opts.Destination != nil {
+		return nil
+	}
+
+	progress := &fetchProgress{
+		session:  session,
+		path:     path.Join(pkgDestinationDir, fetchProgressName),
+		Verified: make(map[string]verifiedPart),
+	}
+
+	content, err := readAll(session.fs, progress.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			session.log.Errorf("Unable to read fetch progress %v, verifying all parts. Error: %v", progress.path, err)
+		}
+		return progress
+	}
+
+	if err := json.Unmarshal(content, progress); err != nil {
+		session.log.Errorf("Ignoring unusable fetch progress %v. Error: %v", progress.path, err)
+		progress.Verified = make(map[string]verifiedPart)
+	}
+
+	return progress
+}
+
+// verified reports whether the part file at partPath was verified by an
+// earlier fetch and is unchanged since
+func (p *fetchProgress) verified(name string, part horizonpkg.DockerImagePart, partPath string) bool {
+	if p == nil {
+		return false
+	}
+
+	p.lock.Lock()
+	recorded, exists := p.Verified[name]
+	p.lock.Unlock()
+
+	if !exists || recorded.Sha256sum != part.Sha256sum || !reflect.DeepEqual(recorded.Digests, part.Digests) || recorded.Bytes != part.Bytes {
+		return false
+	}
+
+	// a part verified without its signatures isn't trusted by a fetch that checks them
+	if !recorded.SignatureVerified && !p.session.opts.InsecureSkipSignatureVerification {
+		return false
+	}
+
+	info, err := p.session.fs.Stat(partPath)
+	return err == nil && info.Size() == recorded.Bytes && info.ModTime().UnixNano() == recorded.ModTime
+}
+
+// sum returns the sha256 of the named part recorded when it was verified, ""
+// if it wasn't recorded
+func (p *fetchProgress) sum(name string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.Verified[name].ActualSha256sum
+}
+
+// record records that the part file at partPath passed verification and
+// saves the progress
+func (p *fetchProgress) record(name string, part horizonpkg.DockerImagePart, partPath string) {
+	if p == nil {
+		return
+	}
+
+	info, err := p.session.fs.Stat(partPath)
+	if err != nil {
+		p.session.log.Errorf("Unable to record verified part %v in fetch progress. Error: %v", partPath, err)
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.Verified[name] = verifiedPart{
+		Sha256sum:         part.Sha256sum,
+		Digests:           part.Digests,
+		Bytes:             info.Size(),
+		ModTime:           info.ModTime().UnixNano(),
+		SignatureVerified: !p.session.opts.InsecureSkipSignatureVerification,
+		ActualSha256sum:   p.session.verifiedSum(partPath),
+	}
+
+	content, err := json.Marshal(p)
+	if err != nil {
+		p.session.log.Errorf("Unable to save fetch progress %v. Error: %v", p.path, err)
+		return
+	}
+
+	// written aside and renamed so an interrupted write doesn't lose the record
+	if err := p.session.fs.WriteFile(p.path+".tmp", content, p.session.fileMode()); err != nil {
+		p.session.log.Errorf("Unable to save fetch progress %v. Error: %v", p.path, err)
+		return
+	}
+
+	if err := p.session.fs.Rename(p.path+".tmp", p.path); err != nil {
+		p.session.log.Errorf("Unable to save fetch progress %v. Error: %v", p.path, err)
+	}
+}
+
+// complete removes the record of a fetch that verified all of its parts
+func (p *fetchProgress) complete() {
+	if p == nil {
+		return
+	}
+
+	if err := p.session.fs.Remove(p.path); err != nil && !os.IsNotExist(err) {
+		p.session.log.Errorf("Failed to remove fetch progress %v. Error: %v", p.path, err)
+	}
+}
@@ -0,0 +1,144 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_FetchProgress_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-progress-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	// b is unavailable until the fetch is "restarted"
+	var lock sync.Mutex
+	requests := make(map[string]int)
+	available := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		requests[r.URL.Path]++
+		if r.URL.Path == "/b" && !available {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("content" + r.URL.Path))
+	}))
+	defer server.Close()
+
+	serveB := func(serve bool) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		available = serve
+		requests = make(map[string]int)
+	}
+
+	requested := func() map[string]int {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return requests
+	}
+
+	parts := make(horizonpkg.DockerImageParts)
+	for _, name := range []string{"a", "b"} {
+		content := []byte("content/" + name)
+		parts[name] = horizonpkg.DockerImagePart{
+			ID:        name,
+			Bytes:     int64(len(content)),
+			Sha256sum: fmt.Sprintf("%x", sha256.Sum256(content)),
+			Sources:   []horizonpkg.PartSource{{URL: "/" + name}},
+		}
+	}
+
+	fetch := func(pkgDir string) error {
+		session := newFetchSession(Options{ResumeDownloads: true, InsecureSkipSignatureVerification: true})
+		_, err := fetchAndVerify(context.Background(), &http.Client{}, nil, server.URL, parts, pkgDir, "", "", session)
+		return err
+	}
+
+	suite.Run("parts verified by an interrupted fetch are skipped by the next", func(t *testing.T) {
+		pkgDir := path.Join(tmpDir, "interrupted")
+		assert.Nil(t, os.Mkdir(pkgDir, 0700))
+
+		assert.NotNil(t, fetch(pkgDir))
+		_, err := os.Stat(path.Join(pkgDir, fetchProgressName))
+		assert.Nil(t, err)
+
+		serveB(true)
+		assert.Nil(t, fetch(pkgDir))
+		assert.Equal(t, map[string]int{"/b": 1}, requested())
+
+		// the end state is that of an uninterrupted fetch
+		files, err := ioutil.ReadDir(pkgDir)
+		assert.Nil(t, err)
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		assert.Equal(t, []string{"a", "b"}, names)
+	})
+
+	suite.Run("sha256 of parts verified by an interrupted fetch is kept", func(t *testing.T) {
+		serveB(false)
+		pkgDir := path.Join(tmpDir, "sums")
+		assert.Nil(t, os.Mkdir(pkgDir, 0700))
+		assert.NotNil(t, fetch(pkgDir))
+
+		serveB(true)
+		session := newFetchSession(Options{ResumeDownloads: true, InsecureSkipSignatureVerification: true})
+		_, err := fetchAndVerify(context.Background(), &http.Client{}, nil, server.URL, parts, pkgDir, "", "", session)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]int{"/b": 1}, requested())
+		assert.Equal(t, map[string]string{"a": parts["a"].Sha256sum, "b": parts["b"].Sha256sum}, session.fetchedSums)
+	})
+
+	suite.Run("part changed since it was verified is verified again", func(t *testing.T) {
+		pkgDir := path.Join(tmpDir, "changed")
+		assert.Nil(t, os.Mkdir(pkgDir, 0700))
+
+		serveB(false)
+		assert.NotNil(t, fetch(pkgDir))
+
+		partPath := path.Join(pkgDir, "a")
+		assert.Nil(t, ioutil.WriteFile(partPath, []byte("altered/a"), 0600))
+		assert.Nil(t, os.Chtimes(partPath, time.Now(), time.Now().Add(time.Minute)))
+
+		serveB(true)
+		err := fetch(pkgDir)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "Mismatch between expected hash")
+	})
+
+	suite.Run("part verified without its signatures isn't trusted by a fetch checking them", func(t *testing.T) {
+		pkgDir := path.Join(tmpDir, "insecure")
+		assert.Nil(t, os.Mkdir(pkgDir, 0700))
+
+		session := newFetchSession(Options{ResumeDownloads: true, InsecureSkipSignatureVerification: true})
+		partPath := path.Join(pkgDir, "a")
+		assert.Nil(t, ioutil.WriteFile(partPath, []byte("content/a"), 0600))
+		loadFetchProgress(pkgDir, session).record("a", parts["a"], partPath)
+
+		assert.True(t, loadFetchProgress(pkgDir, session).verified("a", parts["a"], partPath))
+		assert.False(t, loadFetchProgress(pkgDir, newFetchSession(Options{ResumeDownloads: true})).verified("a", parts["a"], partPath))
+	})
+
+	suite.Run("progress isn't recorded without resumable downloads", func(t *testing.T) {
+		assert.Nil(t, loadFetchProgress(tmpDir, newFetchSession(Options{})))
+	})
+}
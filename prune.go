@@ -0,0 +1,147 @@
+package fetch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// strayPartSuffixes are the suffixes of files left in a Pkg directory by failed
+// part fetches
+var strayPartSuffixes = []string{".part", ".hashstate", ".corrupt", stagedSuffix, fetchProgressName}
+
+// PruneResult reports what Prune removed
+type PruneResult struct {
+	// Removed is the number of Pkg directories, meta files and stray part
+	// files removed
+	Removed int
+
+	// BytesReclaimed is the total size of the removed files
+	BytesReclaimed int64
+}
+
+// Prune removes the Pkg directories and meta files in destinationDir of Pkgs
+// whose IDs aren't in keep, meta left partially downloaded, and stray partial
+// or quarantined part files from the directories of those that are. Only entries of destinationDir are
+// removed; symlinks are removed, never followed. Prune must not be run while a
+// Pkg is being fetched into destinationDir.
+//
+// Deprecated: use PruneContext, which can be canceled.
+func Prune(destinationDir string, keep []string) (*PruneResult, error) {
+	return PruneContext(context.Background(), destinationDir, keep)
+}
+
+// PruneContext behaves like Prune but stops removing entries, returning ctx's
+// error, once ctx is done; entries already removed stay removed.
+func PruneContext(ctx context.Context, destinationDir string, keep []string) (*PruneResult, error) {
+	kept := make(map[string]bool)
+	for _, id := range keep {
+		kept[id] = true
+	}
+
+	result := &PruneResult{}
+
+	remove := func(entryPath string) error {
+		bytes, err := diskUsage(entryPath)
+		if err != nil {
+			return err
+		}
+
+		if err := os.RemoveAll(entryPath); err != nil {
+			return err
+		}
+
+		result.Removed++
+		result.BytesReclaimed += bytes
+		return nil
+	}
+
+	dir, err := os.Open(destinationDir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		entryPath := filepath.Join(destinationDir, entry.Name())
+
+		switch {
+		case entry.IsDir() && kept[entry.Name()]:
+			if err := pruneStrayParts(entryPath, remove); err != nil {
+				return nil, err
+			}
+
+		case entry.IsDir(), entry.Mode()&os.ModeSymlink != 0 && !kept[entry.Name()]:
+			if err := remove(entryPath); err != nil {
+				return nil, err
+			}
+
+		case strings.HasSuffix(entry.Name(), ".json") && !kept[strings.TrimSuffix(entry.Name(), ".json")],
+			strings.HasSuffix(entry.Name(), ".json"+metaDigestSuffix) && !kept[strings.TrimSuffix(entry.Name(), ".json"+metaDigestSuffix)],
+			strings.HasPrefix(entry.Name(), metaDownloadPrefix) && strings.HasSuffix(entry.Name(), metaDownloadSuffix):
+			if err := remove(entryPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// pruneStrayParts removes the stray part files in a kept Pkg directory
+func pruneStrayParts(pkgDir string, remove func(string) error) error {
+	dir, err := os.Open(pkgDir)
+	if err != nil {
+		return err
+	}
+	entries, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		for _, suffix := range strayPartSuffixes {
+			if strings.HasSuffix(entry.Name(), suffix) {
+				if err := remove(filepath.Join(pkgDir, entry.Name())); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// diskUsage returns the total size of the regular files at or under p,
+// without following symlinks
+func diskUsage(p string) (int64, error) {
+	var bytes int64
+
+	err := filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			bytes += info.Size()
+		}
+		return nil
+	})
+
+	return bytes, err
+}
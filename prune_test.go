@@ -0,0 +1,73 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_Prune_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-prune-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	destinationDir := path.Join(tmpDir, "destination")
+	outside := path.Join(tmpDir, "outside")
+
+	write := func(p string, size int) {
+		assert.Nil(suite, os.MkdirAll(path.Dir(p), 0700))
+		assert.Nil(suite, ioutil.WriteFile(p, make([]byte, size), 0600))
+	}
+
+	write(path.Join(destinationDir, "kept.json"), 10)
+	write(path.Join(destinationDir, "kept.json.sha256"), 65)
+	write(path.Join(destinationDir, "kept", "part"), 100)
+	write(path.Join(destinationDir, "kept", "other.part"), 20)
+	write(path.Join(destinationDir, "kept", "bad.corrupt"), 30)
+	write(path.Join(destinationDir, "stale.json"), 10)
+	write(path.Join(destinationDir, "stale.json.sha256"), 65)
+	write(path.Join(destinationDir, "stale", "part"), 200)
+	write(path.Join(destinationDir, ".pkgmeta-0123456789abcdef.download"), 5)
+	write(path.Join(outside, "part"), 1000)
+	assert.Nil(suite, os.Symlink(outside, path.Join(destinationDir, "linked")))
+
+	suite.Run("nothing is removed once the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := PruneContext(ctx, destinationDir, []string{"kept"})
+		assert.Equal(t, context.Canceled, err)
+
+		_, err = os.Stat(path.Join(destinationDir, "stale.json.sha256"))
+		assert.Nil(t, err)
+	})
+
+	suite.Run("stale Pkgs and stray part files are removed", func(t *testing.T) {
+		result, err := Prune(destinationDir, []string{"kept"})
+		assert.Nil(t, err)
+
+		// stale.json, its sha256, stale/, other.part, bad.corrupt, the partial meta and the linked symlink
+		assert.Equal(t, 7, result.Removed)
+		assert.EqualValues(t, 330, result.BytesReclaimed)
+
+		for _, p := range []string{"kept.json", "kept.json.sha256", "kept/part"} {
+			_, err := os.Stat(path.Join(destinationDir, p))
+			assert.Nil(t, err, p)
+		}
+
+		for _, p := range []string{"stale.json", "stale.json.sha256", "stale", "kept/other.part", "kept/bad.corrupt", ".pkgmeta-0123456789abcdef.download", "linked"} {
+			_, err := os.Lstat(path.Join(destinationDir, p))
+			assert.True(t, os.IsNotExist(err), p)
+		}
+	})
+
+	suite.Run("nothing outside destinationDir is touched", func(t *testing.T) {
+		_, err := os.Stat(path.Join(outside, "part"))
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,88 @@
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"net/http"
+	"time"
+)
+
+type raceResult struct {
+	index    int
+	source   horizonpkg.PartSource
+	pURL     string
+	response *http.Response
+	err      error
+	cancel   context.CancelFunc
+}
+
+// raceSources requests each of the given sources concurrently and returns the
+// result from the first that answers with a 200. Requests to the other
+// sources are canceled. On success the caller must close the response body and
+// then call the result's cancel func; on failure the last recorded failure is
+// returned instead. The outcomes of the sources that failed before the race was
+// won, in the order they failed, are returned either way.
+func raceSources(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, pkgURLBase string, sources []horizonpkg.PartSource, session *fetchSession) (*raceResult, *partFetchFailure, []fetcherrors.SourceOutcome) {
+	started := time.Now()
+	results := make(chan raceResult, len(sources))
+	cancels := make([]context.CancelFunc, len(sources))
+
+	for ix, source := range sources {
+		sourceCtx, cancel := context.WithCancel(ctx)
+		cancels[ix] = cancel
+
+		go func(ix int, ctx context.Context, source horizonpkg.PartSource) {
+			pURL := partSourceURL(pkgURLBase, source, session)
+
+			req, err := authenticatedRequest(ctx, pURL, authCreds, session)
+			if err != nil {
+				results <- raceResult{ix, source, pURL, nil, err, nil}
+				return
+			}
+
+			response, err := client.Do(req)
+			results <- raceResult{ix, source, pURL, response, err, nil}
+		}(ix, sourceCtx, source)
+	}
+
+	var failure *partFetchFailure
+	var outcomes []fetcherrors.SourceOutcome
+
+	for received := 0; received < len(sources); received++ {
+		result := <-results
+
+		if result.err == nil && result.response.StatusCode == http.StatusOK {
+			session.log.Infof(3, "Source %v won race among %v sources", result.pURL, len(sources))
+
+			for ix, cancel := range cancels {
+				if ix != result.index {
+					cancel()
+				}
+			}
+
+			// drain the losers so their connections are released
+			go func(outstanding int) {
+				for ; outstanding > 0; outstanding-- {
+					if loser := <-results; loser.response != nil {
+						loser.response.Body.Close()
+					}
+				}
+			}(len(sources) - received - 1)
+
+			result.cancel = cancels[result.index]
+			return &result, nil, outcomes
+		}
+
+		session.log.Errorf("Failed to download part from %v in race. Response: %v. Error: %v", result.pURL, result.response, result.err)
+		failure = &partFetchFailure{0, result.pURL, result.err}
+		if result.response != nil {
+			failure.HTTPStatusCode = result.response.StatusCode
+			result.response.Body.Close()
+		}
+		outcomes = append(outcomes, failure.outcome(time.Since(started)))
+		cancels[result.index]()
+	}
+
+	return nil, failure, outcomes
+}
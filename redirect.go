@@ -0,0 +1,54 @@
+package fetch
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// same limit as the net/http default redirect policy
+const maxRedirects = 10
+
+// withRedirectCredentials returns a copy of client that re-applies matching
+// credentials from authCreds when following a redirect to another host. The
+// net/http client copies the headers of the original request onto every
+// redirect, dropping only the Authorization header on redirects to other
+// hosts; this removes the credentials of every host in the redirect chain,
+// including configured headers, and only sets those configured for the
+// redirect target's URL prefix.
+func withRedirectCredentials(client *http.Client, authCreds map[string]map[string]string, session *fetchSession) *http.Client {
+	checkRedirect := client.CheckRedirect
+
+	redirecting := *client
+	redirecting.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if checkRedirect != nil {
+			if err := checkRedirect(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+
+		// the headers of the redirect are copied from the original request, so it's its host they're for
+		if origin := via[0]; req.URL.Host != origin.URL.Host {
+			session.log.Infof(4, "Following redirect from host %v to %v, applying credentials for the new host", via[len(via)-1].URL.Host, req.URL.Host)
+			stripSigV4Headers(req.Header)
+			for _, hop := range via {
+				for k, v := range authCreds {
+					if strings.HasPrefix(hop.URL.String(), k) {
+						for name := range credentialHeaders(v) {
+							req.Header.Del(name)
+						}
+					}
+				}
+			}
+			if err := applyCredentials(req, req.URL.String(), authCreds, session); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return &redirecting
+}
@@ -0,0 +1,94 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_RedirectCredentials_Suite(suite *testing.T) {
+	var targetAuth, targetAPIKey string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targetAuth = r.Header.Get("Authorization")
+		targetAPIKey = r.Header.Get("X-Api-Key")
+	}))
+	defer target.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+"/blob", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	session := newFetchSession(Options{})
+
+	fetch := func(t *testing.T, authCreds map[string]map[string]string) {
+		targetAuth = "unset"
+
+		req, err := authenticatedRequest(context.Background(), origin.URL+"/part", authCreds, session)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, req.Header.Get("Authorization"))
+
+		response, err := withRedirectCredentials(&http.Client{}, authCreds, session).Do(req)
+		assert.Nil(t, err)
+		response.Body.Close()
+	}
+
+	suite.Run("origin credentials are not sent to redirect target", func(t *testing.T) {
+		fetch(t, map[string]map[string]string{
+			origin.URL: {"username": "origin", "password": "secret", "header:X-Api-Key": "origin-key"},
+		})
+
+		assert.Equal(t, "", targetAuth)
+		assert.Equal(t, "", targetAPIKey)
+	})
+
+	suite.Run("redirect target credentials are applied", func(t *testing.T) {
+		fetch(t, map[string]map[string]string{
+			origin.URL: {"username": "origin", "password": "secret"},
+			target.URL: {"username": "target", "password": "other"},
+		})
+
+		expected, _ := http.NewRequest(http.MethodGet, target.URL, nil)
+		expected.SetBasicAuth("target", "other")
+		assert.Equal(t, expected.Header.Get("Authorization"), targetAuth)
+	})
+
+	suite.Run("origin credentials are not sent along a chain of redirects", func(t *testing.T) {
+		var finalHeader http.Header
+		final := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			finalHeader = r.Header
+		}))
+		defer final.Close()
+
+		hop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, final.URL+"/blob", http.StatusFound)
+		}))
+		defer hop.Close()
+
+		start := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, hop.URL+"/blob", http.StatusFound)
+		}))
+		defer start.Close()
+
+		authCreds := map[string]map[string]string{
+			start.URL: {"username": "origin", "password": "secret", "header:X-Api-Key": "secret"},
+			hop.URL:   {"header:X-Hop-Key": "hop-secret"},
+		}
+		req, err := authenticatedRequest(context.Background(), start.URL+"/part", authCreds, session)
+		assert.Nil(t, err)
+
+		response, err := withRedirectCredentials(&http.Client{}, authCreds, session).Do(req)
+		assert.Nil(t, err)
+		response.Body.Close()
+
+		if assert.NotNil(t, finalHeader) {
+			assert.Equal(t, "", finalHeader.Get("Authorization"))
+			assert.Equal(t, "", finalHeader.Get("X-Api-Key"))
+			assert.Equal(t, "", finalHeader.Get("X-Hop-Key"))
+		}
+	})
+}
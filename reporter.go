@@ -0,0 +1,99 @@
+package fetch
+
+import (
+	"context"
+	"io"
+)
+
+// Reporter receives events of a fetch as it progresses. It is carried in the
+// fetch's context, set with WithReporter, so that code called deep within a
+// fetch, e.g. hooks or a Destination, can report events too with
+// ContextReporter rather than be handed it. Implementations must be safe for
+// concurrent use; they are called from each part's fetch goroutine.
+type Reporter interface {
+	// FetchStarted is called when the fetch of a Pkg's parts starts with the
+	// number of parts and how many of them are downloaded concurrently at most
+	FetchStarted(parts int, concurrency int)
+
+	// PartStarted is called when the fetch of a part of the given size
+	// starts, including that of a part already on disk
+	PartStarted(partID string, bytes int64)
+
+	// PartProgress is called as a part is downloaded with the number of its
+	// bytes downloaded so far, including any resumed
+	PartProgress(partID string, downloaded int64, bytes int64)
+
+	// PartCompleted is called when a part has been fetched and verified, or
+	// with the error failing it
+	PartCompleted(partID string, err error)
+
+	// FetchCompleted is called when the download of a Pkg's parts is done,
+	// with the error failing it if any
+	FetchCompleted(err error)
+}
+
+// NoopReporter is a Reporter that discards all events. It is used if no
+// Reporter is carried in a fetch's context.
+type NoopReporter struct{}
+
+// FetchStarted does nothing
+func (NoopReporter) FetchStarted(parts int, concurrency int) {}
+
+// PartStarted does nothing
+func (NoopReporter) PartStarted(partID string, bytes int64) {}
+
+// PartProgress does nothing
+func (NoopReporter) PartProgress(partID string, downloaded int64, bytes int64) {}
+
+// PartCompleted does nothing
+func (NoopReporter) PartCompleted(partID string, err error) {}
+
+// FetchCompleted does nothing
+func (NoopReporter) FetchCompleted(err error) {}
+
+type reporterKey struct{}
+
+// WithReporter returns a copy of ctx carrying reporter; fetches made with it
+// report their events to reporter
+func WithReporter(ctx context.Context, reporter Reporter) context.Context {
+	return context.WithValue(ctx, reporterKey{}, reporter)
+}
+
+// ContextReporter returns the Reporter carried in ctx, a NoopReporter if
+// there is none
+func ContextReporter(ctx context.Context) Reporter {
+	if reporter, ok := ctx.Value(reporterKey{}).(Reporter); ok && reporter != nil {
+		return reporter
+	}
+
+	return NoopReporter{}
+}
+
+// progressReader reports the progress of a part's download as its content
+// is read
+type progressReader struct {
+	reader     io.Reader
+	reporter   Reporter
+	partID     string
+	downloaded int64
+	bytes      int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.downloaded += int64(n)
+		r.reporter.PartProgress(r.partID, r.downloaded, r.bytes)
+	}
+	return n, err
+}
+
+// concurrency returns how many of the given number of parts are downloaded
+// at once at most
+func (s *fetchSession) concurrency(parts int) int {
+	if s.partSlots != nil && cap(s.partSlots) < parts {
+		return cap(s.partSlots)
+	}
+
+	return parts
+}
@@ -0,0 +1,99 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+// recordingReporter records the events reported to it
+type recordingReporter struct {
+	lock       sync.Mutex
+	events     []string
+	downloaded map[string]int64
+}
+
+func (r *recordingReporter) record(event string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingReporter) FetchStarted(parts int, concurrency int) {
+	r.record(fmt.Sprintf("fetch started %v %v", parts, concurrency))
+}
+
+func (r *recordingReporter) PartStarted(partID string, bytes int64) {
+	r.record(fmt.Sprintf("%v started %v", partID, bytes))
+}
+
+func (r *recordingReporter) PartProgress(partID string, downloaded int64, bytes int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.downloaded[partID] = downloaded
+}
+
+func (r *recordingReporter) PartCompleted(partID string, err error) {
+	r.record(fmt.Sprintf("%v completed %v", partID, err != nil))
+}
+
+func (r *recordingReporter) FetchCompleted(err error) {
+	r.record(fmt.Sprintf("fetch completed %v", err != nil))
+}
+
+func Test_Reporter_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-reporter-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	part := horizonpkg.DockerImagePart{
+		ID:        "a",
+		Bytes:     int64(len(content)),
+		Sha256sum: fmt.Sprintf("%x", sha256.Sum256(content)),
+		Sources:   []horizonpkg.PartSource{{URL: "/a"}},
+	}
+
+	suite.Run("events are reported to the reporter in the context", func(t *testing.T) {
+		reporter := &recordingReporter{downloaded: make(map[string]int64)}
+		corrupt := part
+		corrupt.Sha256sum = fmt.Sprintf("%x", sha256.Sum256([]byte("something else")))
+
+		session := newFetchSession(Options{InsecureSkipSignatureVerification: true, MaxConcurrentParts: 1})
+		_, err := fetchAndVerify(WithReporter(context.Background(), reporter), &http.Client{}, nil, server.URL, horizonpkg.DockerImageParts{"a": part, "b": corrupt}, tmpDir, "", "", session)
+		assert.NotNil(t, err)
+
+		events := reporter.events
+		assert.Equal(t, "fetch started 2 1", events[0])
+		assert.Equal(t, "fetch completed true", events[len(events)-1])
+		assert.Contains(t, events, "a started 1000")
+		assert.Contains(t, events, "b started 1000")
+		assert.Contains(t, events, "b completed true")
+		assert.Equal(t, map[string]int64{"a": 1000, "b": 1000}, reporter.downloaded)
+	})
+
+	suite.Run("context without a reporter reports nothing", func(t *testing.T) {
+		assert.Equal(t, NoopReporter{}, ContextReporter(context.Background()))
+
+		session := newFetchSession(Options{InsecureSkipSignatureVerification: true})
+		fetched, err := fetchAndVerify(context.Background(), &http.Client{}, nil, server.URL, horizonpkg.DockerImageParts{"a": part}, tmpDir, "", "", session)
+		assert.Nil(t, err)
+		assert.Len(t, fetched, 1)
+	})
+}
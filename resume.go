@@ -0,0 +1,340 @@
+package fetch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// defaultHashCheckpointBytes is the checkpoint interval if
+// Options.HashCheckpointBytes isn't set
+const defaultHashCheckpointBytes = 64 * 1024 * 1024
+
+// resumeOverlapBytes is how many of the last bytes of a partial download are
+// requested again when it's resumed so that a source whose content changed
+// since is detected before the rest of the part is appended
+const resumeOverlapBytes = 4096
+
+// partDownload is the file a part is downloaded into. If resumable, the part
+// is downloaded into a ".part" file that is kept when a download is
+// interrupted so that it can be resumed from its current size; the content's
+// sha256 is computed as it is written and checkpointed periodically so that
+// neither resuming nor verifying the part requires re-reading it. A part
+// written to a Destination is hashed with every supported algorithm as it is
+// written since it may not be readable.
+type partDownload struct {
+	session   *fetchSession
+	partPath  string
+	path      string
+	resumable bool
+
+	file   io.WriteCloser
+	offset int64
+
+	// hasher is the sha256 of hashers
+	hasher         hash.Hash
+	hashers        map[horizonpkg.DigestAlgorithm]hash.Hash
+	checkpointed   int64
+	checkpointPath string
+}
+
+// openPartDownload opens the download of the part at partPath, resuming a
+// previous resumable download of up to expectedBytes if one exists
+func openPartDownload(partPath string, expectedBytes int64, resumable bool, session *fetchSession) (*partDownload, error) {
+	download := &partDownload{
+		session:   session,
+		partPath:  partPath,
+		path:      partPath,
+		resumable: resumable,
+	}
+
+	if !resumable {
+		if session.opts.Destination != nil {
+			download.newHashers()
+		}
+		return download, download.open(os.O_RDWR | os.O_CREATE | os.O_EXCL)
+	}
+
+	download.path = partPath + ".part"
+	download.checkpointPath = download.path + ".hashstate"
+
+	if info, err := session.fs.Stat(download.path); err == nil {
+		if info.Size() > expectedBytes {
+			session.log.Errorf("Partial download %v is larger (%v bytes) than part (%v bytes), discarding it", download.path, info.Size(), expectedBytes)
+			return download, download.reset()
+		}
+
+		if err := download.restoreHash(info.Size()); err != nil {
+			session.log.Errorf("Unable to restore hash of partial download %v, discarding it. Error: %v", download.path, err)
+			return download, download.reset()
+		}
+
+		if info.Size() > 0 {
+			session.log.Infof(3, "Resuming download of %v from byte %v", partPath, info.Size())
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		download.newHashers()
+	}
+
+	return download, download.open(os.O_WRONLY | os.O_CREATE | os.O_APPEND)
+}
+
+// open opens the download file with flag, or creates the part in the
+// session's Destination if it has one
+func (d *partDownload) open(flag int) error {
+	var file io.WriteCloser
+	var err error
+
+	if d.session.opts.Destination != nil {
+		file, err = d.session.opts.Destination.Create(d.path)
+	} else {
+		file, err = d.session.fs.OpenFile(d.path, flag, d.session.fileMode())
+	}
+	if err != nil {
+		return err
+	}
+
+	d.file = file
+	return nil
+}
+
+// newHashers starts the download's hashes over: sha256 and, if the part is
+// written to a Destination, every other supported algorithm
+func (d *partDownload) newHashers() {
+	d.hasher = sha256.New()
+	d.hashers = map[horizonpkg.DigestAlgorithm]hash.Hash{horizonpkg.SHA256: d.hasher}
+
+	if d.session.opts.Destination != nil {
+		d.hashers[horizonpkg.SHA512] = newDigestHasher(horizonpkg.SHA512)
+	}
+}
+
+// Write writes to the download file, checkpointing the hash of a resumable
+// download at the session's interval
+func (d *partDownload) Write(p []byte) (int, error) {
+	n, err := d.file.Write(p)
+	d.offset += int64(n)
+
+	for _, hasher := range d.hashers {
+		hasher.Write(p[:n])
+	}
+
+	if d.resumable && d.offset-d.checkpointed >= d.session.hashCheckpointBytes() {
+		if cpErr := d.checkpoint(); cpErr != nil {
+			d.session.log.Errorf("Failed to checkpoint hash of %v. Error: %v", d.path, cpErr)
+		}
+	}
+
+	return n, err
+}
+
+// reset discards what has been downloaded and starts over
+func (d *partDownload) reset() error {
+	if d.file != nil {
+		d.file.Close()
+		d.file = nil
+	}
+
+	if err := d.session.discard(d.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if d.hashers != nil {
+		d.newHashers()
+	}
+
+	flag := os.O_RDWR | os.O_CREATE | os.O_EXCL
+	if d.resumable {
+		d.session.fs.Remove(d.checkpointPath)
+		d.checkpointed = 0
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	d.offset = 0
+	return d.open(flag)
+}
+
+// complete closes the download and moves a resumable download to the part's
+// path. The hashes computed during download, if any, are recorded in the
+// session for verification.
+func (d *partDownload) complete() error {
+	if err := d.file.Close(); err != nil {
+		return err
+	}
+	d.file = nil
+
+	if d.resumable {
+		if err := d.session.fs.Rename(d.path, d.partPath); err != nil {
+			return err
+		}
+		d.session.fs.Remove(d.checkpointPath)
+	}
+
+	if d.hashers != nil {
+		d.session.recordHashes(d.partPath, d.hashers)
+	}
+	return nil
+}
+
+// abandon closes a download that failed from all of the part's sources and
+// discards its file, unless it is resumable and may be resumed by a later
+// fetch
+func (d *partDownload) abandon() error {
+	if err := d.Close(); err != nil || d.resumable {
+		return err
+	}
+
+	if err := d.session.discard(d.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Close closes the download file if it is open
+func (d *partDownload) Close() error {
+	if d.file == nil {
+		return nil
+	}
+
+	err := d.file.Close()
+	d.file = nil
+	return err
+}
+
+// checkpoint persists the download's offset and hash state
+func (d *partDownload) checkpoint() error {
+	marshaler, ok := d.hasher.(encoding.BinaryMarshaler)
+	if !ok {
+		return errors.New("hash state can't be marshaled")
+	}
+
+	state, err := marshaler.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	content := make([]byte, 8, 8+len(state))
+	binary.BigEndian.PutUint64(content, uint64(d.offset))
+	content = append(content, state...)
+
+	if err := d.session.fs.WriteFile(d.checkpointPath, content, d.session.fileMode()); err != nil {
+		return err
+	}
+
+	d.checkpointed = d.offset
+	return nil
+}
+
+// restoreHash restores the hash of the first size bytes of the partial
+// download from its checkpoint, hashing only the bytes written after the
+// checkpoint. Without a usable checkpoint the whole prefix is hashed.
+func (d *partDownload) restoreHash(size int64) error {
+	d.newHashers()
+	var from int64
+
+	if content, err := readAll(d.session.fs, d.checkpointPath); err == nil && len(content) > 8 {
+		checkpointed := int64(binary.BigEndian.Uint64(content[:8]))
+		unmarshaler, ok := d.hasher.(encoding.BinaryUnmarshaler)
+
+		if ok && checkpointed <= size && unmarshaler.UnmarshalBinary(content[8:]) == nil {
+			from = checkpointed
+		} else {
+			d.session.log.Infof(3, "Ignoring unusable hash checkpoint %v", d.checkpointPath)
+			d.newHashers()
+		}
+	}
+
+	if from < size {
+		partial, err := d.session.fs.Open(d.path)
+		if err != nil {
+			return err
+		}
+		defer partial.Close()
+
+		if _, err := io.CopyN(ioutil.Discard, partial, from); err != nil {
+			return err
+		}
+
+		if _, err := io.CopyN(d.hasher, partial, size-from); err != nil {
+			return fmt.Errorf("Unable to hash partial download %v. Error: %v", d.path, err)
+		}
+	}
+
+	d.offset = size
+	d.checkpointed = from
+	return nil
+}
+
+// overlap returns how many of the last bytes of the download are requested
+// again when it's resumed, 0 if it isn't
+func (d *partDownload) overlap() int64 {
+	if !d.resumable || d.offset == 0 {
+		return 0
+	}
+
+	if d.offset < resumeOverlapBytes {
+		return d.offset
+	}
+	return resumeOverlapBytes
+}
+
+// matchesTail reports whether the download ends with tail
+func (d *partDownload) matchesTail(tail []byte) (bool, error) {
+	partial, err := d.session.fs.Open(d.path)
+	if err != nil {
+		return false, err
+	}
+	defer partial.Close()
+
+	start := d.offset - int64(len(tail))
+	if seeker, ok := partial.(io.Seeker); ok {
+		_, err = seeker.Seek(start, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, partial, start)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	written := make([]byte, len(tail))
+	if _, err := io.ReadFull(partial, written); err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(written, tail), nil
+}
+
+// readAll reads the named file from fs
+func readAll(fs FileSystem, name string) ([]byte, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
+
+// contentRangeStart returns the first byte position of a partial content
+// response's Content-Range
+func contentRangeStart(response *http.Response) (int64, bool) {
+	var start, end int64
+	var total string
+
+	if _, err := fmt.Sscanf(response.Header.Get("Content-Range"), "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		return 0, false
+	}
+
+	return start, true
+}
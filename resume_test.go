@@ -0,0 +1,234 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ResumeDownloads_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-resume-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	sources := []horizonpkg.PartSource{{URL: "/part"}}
+
+	var ranged int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranged, 1)
+		}
+		http.ServeContent(w, r, "part", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	ignoresRange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer ignoresRange.Close()
+
+	suite.Run("partial download is resumed and its hash recorded", func(t *testing.T) {
+		atomic.StoreInt32(&ranged, 0)
+		session := newFetchSession(Options{ResumeDownloads: true})
+
+		partPath := path.Join(tmpDir, "resumed")
+		assert.Nil(t, ioutil.WriteFile(partPath+".part", content[:300], 0600))
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", partPath, int64(len(content)), "", sources, session)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&ranged))
+		assert.Equal(t, int64(300), session.reusedBytes)
+
+		written, err := ioutil.ReadFile(partPath)
+		assert.Nil(t, err)
+		assert.Equal(t, content, written)
+
+		_, err = os.Stat(partPath + ".part")
+		assert.True(t, os.IsNotExist(err))
+
+		expected := sha256.Sum256(content)
+		hasher := session.takeHashes(partPath)[horizonpkg.SHA256]
+		if assert.NotNil(t, hasher) {
+			assert.Equal(t, expected[:], hasher.Sum(nil))
+		}
+	})
+
+	suite.Run("source ignoring the range restarts the download", func(t *testing.T) {
+		session := newFetchSession(Options{ResumeDownloads: true})
+
+		partPath := path.Join(tmpDir, "restarted")
+		assert.Nil(t, ioutil.WriteFile(partPath+".part", content[:300], 0600))
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, ignoresRange.URL, "part", partPath, int64(len(content)), "", sources, session)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), session.reusedBytes)
+
+		written, err := ioutil.ReadFile(partPath)
+		assert.Nil(t, err)
+		assert.Equal(t, content, written)
+	})
+
+	suite.Run("partial download differing from the source restarts the download", func(t *testing.T) {
+		atomic.StoreInt32(&ranged, 0)
+		session := newFetchSession(Options{ResumeDownloads: true})
+
+		partPath := path.Join(tmpDir, "drifted")
+		assert.Nil(t, ioutil.WriteFile(partPath+".part", bytes.Repeat([]byte("x"), 300), 0600))
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", partPath, int64(len(content)), "", sources, session)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&ranged))
+		assert.Equal(t, int64(0), session.reusedBytes)
+
+		written, err := ioutil.ReadFile(partPath)
+		assert.Nil(t, err)
+		assert.Equal(t, content, written)
+	})
+
+	suite.Run("only the last bytes of a long partial download are requested again", func(t *testing.T) {
+		download := &partDownload{resumable: true, offset: 300}
+		assert.Equal(t, int64(300), download.overlap())
+
+		download.offset = 1024 * 1024
+		assert.Equal(t, int64(resumeOverlapBytes), download.overlap())
+
+		download.resumable = false
+		assert.Equal(t, int64(0), download.overlap())
+	})
+
+	suite.Run("hash state is restored from its checkpoint", func(t *testing.T) {
+		session := newFetchSession(Options{ResumeDownloads: true, HashCheckpointBytes: 100})
+		partPath := path.Join(tmpDir, "checkpointed")
+
+		download, err := openPartDownload(partPath, int64(len(content)), true, session)
+		assert.Nil(t, err)
+		for offset := 0; offset < 250; offset += 50 {
+			_, err = download.Write(content[offset : offset+50])
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, download.Close())
+		assert.Equal(t, int64(200), download.checkpointed)
+
+		// overwrite the checkpointed prefix; only bytes after the checkpoint
+		// should be re-read
+		partial, err := ioutil.ReadFile(download.path)
+		assert.Nil(t, err)
+		copy(partial, bytes.Repeat([]byte("x"), 200))
+		assert.Nil(t, ioutil.WriteFile(download.path, partial, 0600))
+
+		resumed, err := openPartDownload(partPath, int64(len(content)), true, session)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(250), resumed.offset)
+		_, err = resumed.Write(content[250:])
+		assert.Nil(t, err)
+		assert.Nil(t, resumed.complete())
+
+		expected := sha256.Sum256(content)
+		assert.Equal(t, expected[:], session.takeHashes(partPath)[horizonpkg.SHA256].Sum(nil))
+
+		_, err = os.Stat(download.checkpointPath)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	suite.Run("prefix is re-read without a checkpoint", func(t *testing.T) {
+		session := newFetchSession(Options{ResumeDownloads: true})
+		partPath := path.Join(tmpDir, "uncheckpointed")
+		assert.Nil(t, ioutil.WriteFile(partPath+".part", content[:400], 0600))
+
+		download, err := openPartDownload(partPath, int64(len(content)), true, session)
+		assert.Nil(t, err)
+		_, err = download.Write(content[400:])
+		assert.Nil(t, err)
+		assert.Nil(t, download.complete())
+
+		expected := sha256.Sum256(content)
+		assert.Equal(t, expected[:], session.takeHashes(partPath)[horizonpkg.SHA256].Sum(nil))
+	})
+}
+
+func Test_InterruptedDownload_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-interrupted-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	// the first request for /cut is cut short after 400 bytes
+	var lock sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ranges = append(ranges, r.URL.Path+" "+r.Header.Get("Range"))
+		first := len(ranges) == 1
+		lock.Unlock()
+
+		if first && r.URL.Path == "/cut" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:400])
+			return
+		}
+		http.ServeContent(w, r, "part", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	requested := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		requests := ranges
+		ranges = nil
+		return requests
+	}
+
+	fetch := func(name string, opts Options, sources ...string) error {
+		var partSources []horizonpkg.PartSource
+		for _, source := range sources {
+			partSources = append(partSources, horizonpkg.PartSource{URL: source})
+		}
+
+		err := fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", path.Join(tmpDir, name), int64(len(content)), "", partSources, newFetchSession(opts))
+		if err == nil {
+			written, readErr := ioutil.ReadFile(path.Join(tmpDir, name))
+			assert.Nil(suite, readErr)
+			assert.Equal(suite, content, written)
+		}
+		return err
+	}
+
+	suite.Run("interrupted download is resumed from the same source", func(t *testing.T) {
+		assert.Nil(t, fetch("resumed", Options{ResumeDownloads: true, Retry: RetryPolicy{MaxAttempts: 2}}, "/cut", "/other"))
+		assert.Equal(t, []string{"/cut ", "/cut bytes=0-"}, requested())
+	})
+
+	suite.Run("interrupted download is restarted from the same source", func(t *testing.T) {
+		assert.Nil(t, fetch("restarted", Options{Retry: RetryPolicy{MaxAttempts: 2}}, "/cut", "/other"))
+		assert.Equal(t, []string{"/cut ", "/cut "}, requested())
+	})
+
+	suite.Run("interrupted download falls back to the next source", func(t *testing.T) {
+		assert.Nil(t, fetch("next", Options{}, "/cut", "/other"))
+		assert.Equal(t, []string{"/cut ", "/other "}, requested())
+	})
+
+	suite.Run("interrupted download of the only source is a transient error", func(t *testing.T) {
+		err := fetch("failed", Options{}, "/cut")
+		assert.NotNil(t, err)
+		assert.True(t, IsTransient(err), err)
+		requested()
+	})
+}
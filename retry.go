@@ -0,0 +1,202 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// cap on retry waits if the MaxWait of a RetryPolicy or FixedRetry isn't set
+const defaultMaxRetryWait = time.Minute
+
+// Jitter is a faux-enum identifying how retry waits are randomized
+type Jitter string
+
+const (
+	// FULL waits a random time between 0 and the computed backoff so that
+	// clients whose requests fail together don't all retry together
+	FULL Jitter = "FULL"
+
+	// NONE waits the computed backoff
+	NONE Jitter = "NONE"
+)
+
+// Retrier decides whether and when a failed request to a part source is
+// retried. RetryPolicy, FixedRetry and NoRetry are provided; others may
+// distinguish sources by the URL of the response's Request.
+type Retrier interface {
+	// NextBackoff returns how long to wait before retrying a request that
+	// failed with lastErr or, if it is nil, the response resp, and false if
+	// the request shouldn't be retried. attempt is the number of requests
+	// made, the first is attempt 1. Canceled requests are never retried.
+	NextBackoff(attempt int, lastErr error, resp *http.Response) (time.Duration, bool)
+}
+
+// RetryPolicy is a Retrier with exponential backoff of a part source whose
+// requests fail transiently: with a network error or a 408, 429, 500, 502,
+// 503 or 504 response. The zero value disables retries.
+type RetryPolicy struct {
+	// MaxAttempts is the number of requests made to a source, including the
+	// first, before moving on to the next source.
+	MaxAttempts int
+
+	// Backoff is the wait before the first retry; it is doubled for each
+	// subsequent retry. A Retry-After header in the response takes precedence.
+	Backoff time.Duration
+
+	// MaxWait caps the wait before any retry, including one requested by a
+	// Retry-After header. If 0, waits are capped at one minute.
+	MaxWait time.Duration
+
+	// Jitter is how the backoff, once capped, is randomized; if empty, it is
+	// FULL. Waits requested by a Retry-After header aren't randomized.
+	Jitter Jitter
+}
+
+// NextBackoff returns the wait before retrying a transient failure of the
+// given attempt, if fewer than MaxAttempts requests were made
+func (p RetryPolicy) NextBackoff(attempt int, lastErr error, resp *http.Response) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || !transientResponse(resp, lastErr) {
+		return 0, false
+	}
+
+	return p.wait(attempt, resp, time.Now()), true
+}
+
+// wait returns how long to wait before the given retry attempt (the first
+// retry is attempt 1), honoring the response's Retry-After header if present
+// and otherwise jittering the backoff
+func (p RetryPolicy) wait(attempt int, response *http.Response, now time.Time) time.Duration {
+	maxWait := retryWaitCap(p.MaxWait)
+
+	wait, ok := retryAfter(response, now)
+	if ok {
+		if wait > maxWait {
+			return maxWait
+		}
+		return wait
+	}
+
+	wait = p.Backoff
+	for ix := 1; ix < attempt && wait < maxWait; ix++ {
+		wait *= 2
+	}
+
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	if p.Jitter == NONE || wait <= 0 {
+		return wait
+	}
+	return time.Duration(rand.Int63n(int64(wait) + 1))
+}
+
+// FixedRetry is a Retrier that waits the same time before each retry of a
+// part source whose requests fail transiently, as RetryPolicy does, unless a
+// Retry-After header requests another
+type FixedRetry struct {
+	// MaxAttempts is the number of requests made to a source, including the
+	// first, before moving on to the next source.
+	MaxAttempts int
+
+	// Wait is the wait before each retry
+	Wait time.Duration
+
+	// MaxWait caps the wait before any retry, including one requested by a
+	// Retry-After header. If 0, waits are capped at one minute.
+	MaxWait time.Duration
+}
+
+// NextBackoff returns Wait or the wait requested by resp, capped at MaxWait,
+// before retrying a transient failure, if fewer than MaxAttempts requests
+// were made
+func (f FixedRetry) NextBackoff(attempt int, lastErr error, resp *http.Response) (time.Duration, bool) {
+	if attempt >= f.MaxAttempts || !transientResponse(resp, lastErr) {
+		return 0, false
+	}
+
+	wait, ok := retryAfter(resp, time.Now())
+	if !ok {
+		wait = f.Wait
+	}
+
+	if maxWait := retryWaitCap(f.MaxWait); wait > maxWait {
+		return maxWait, true
+	}
+	return wait, true
+}
+
+// NoRetry is a Retrier that never retries
+type NoRetry struct{}
+
+// NextBackoff returns false
+func (NoRetry) NextBackoff(attempt int, lastErr error, resp *http.Response) (time.Duration, bool) {
+	return 0, false
+}
+
+// retrier returns the session's Retrier, NoRetry if none is configured
+func (s *fetchSession) retrier() Retrier {
+	if s.opts.Retry == nil {
+		return NoRetry{}
+	}
+
+	return s.opts.Retry
+}
+
+// retryWaitCap returns the cap on retry waits given a Retrier's MaxWait
+func retryWaitCap(maxWait time.Duration) time.Duration {
+	if maxWait == 0 {
+		return defaultMaxRetryWait
+	}
+
+	return maxWait
+}
+
+// retryAfter parses the Retry-After header of a response, which may be a
+// number of seconds or an HTTP date. A number of seconds too large for a
+// time.Duration saturates to the longest one; callers cap it.
+func retryAfter(response *http.Response, now time.Time) (time.Duration, bool) {
+	if response == nil {
+		return 0, false
+	}
+
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); (err == nil || errors.Is(err, strconv.ErrRange)) && seconds >= 0 {
+		if seconds > math.MaxInt64/int64(time.Second) {
+			return time.Duration(math.MaxInt64), true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// sleep waits for the given duration or until ctx is done, returning ctx's
+// error in the latter case
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,201 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRetrier retries every failure with no wait, up to limit times, and
+// counts the failures it was consulted about
+type countingRetrier struct {
+	limit     int
+	consulted int32
+}
+
+func (r *countingRetrier) NextBackoff(attempt int, lastErr error, resp *http.Response) (time.Duration, bool) {
+	atomic.AddInt32(&r.consulted, 1)
+	return 0, attempt <= r.limit
+}
+
+func Test_RetryPolicy_Suite(suite *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	withRetryAfter := func(value string) *http.Response {
+		response := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		if value != "" {
+			response.Header.Set("Retry-After", value)
+		}
+		return response
+	}
+
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: time.Second, MaxWait: 30 * time.Second, Jitter: NONE}
+
+	suite.Run("backoff doubles per attempt without Retry-After", func(t *testing.T) {
+		assert.Equal(t, time.Second, policy.wait(1, withRetryAfter(""), now))
+		assert.Equal(t, 4*time.Second, policy.wait(3, withRetryAfter(""), now))
+	})
+
+	suite.Run("Retry-After in seconds is honored", func(t *testing.T) {
+		assert.Equal(t, 7*time.Second, policy.wait(1, withRetryAfter("7"), now))
+	})
+
+	suite.Run("Retry-After as HTTP date is honored", func(t *testing.T) {
+		date := now.Add(12 * time.Second).Format(http.TimeFormat)
+		assert.Equal(t, 12*time.Second, policy.wait(1, withRetryAfter(date), now))
+	})
+
+	suite.Run("waits are capped", func(t *testing.T) {
+		assert.Equal(t, 30*time.Second, policy.wait(1, withRetryAfter("86400"), now))
+		assert.Equal(t, 30*time.Second, policy.wait(10, withRetryAfter(""), now))
+	})
+
+	suite.Run("huge Retry-After doesn't overflow", func(t *testing.T) {
+		for _, value := range []string{"9223372036", "9223372037", "9223372036854775807", "99999999999999999999"} {
+			wait, ok := retryAfter(withRetryAfter(value), now)
+			assert.True(t, ok, value)
+			assert.True(t, wait > 0, "Retry-After %v overflowed to %v", value, wait)
+
+			assert.Equal(t, 30*time.Second, policy.wait(1, withRetryAfter(value), now), value)
+
+			wait, _ = FixedRetry{MaxAttempts: 3}.NextBackoff(1, nil, withRetryAfter(value))
+			assert.Equal(t, time.Minute, wait, value)
+		}
+
+		_, ok := retryAfter(withRetryAfter("-99999999999999999999"), now)
+		assert.False(t, ok)
+	})
+
+	suite.Run("full jitter keeps waits within the cap and grows them in expectation", func(t *testing.T) {
+		jittered := RetryPolicy{MaxAttempts: 10, Backoff: time.Second, MaxWait: 30 * time.Second}
+		samples := 2000
+
+		var previousMean time.Duration
+		for attempt := 1; attempt <= 4; attempt++ {
+			var total time.Duration
+			for ix := 0; ix < samples; ix++ {
+				wait := jittered.wait(attempt, withRetryAfter(""), now)
+				assert.True(t, wait >= 0 && wait <= 30*time.Second, "wait %v out of range", wait)
+				total += wait
+			}
+
+			mean := total / time.Duration(samples)
+			assert.True(t, mean > previousMean, "mean wait %v of attempt %v isn't longer than %v", mean, attempt, previousMean)
+			previousMean = mean
+		}
+
+		for ix := 0; ix < samples; ix++ {
+			wait := jittered.wait(10, withRetryAfter(""), now)
+			assert.True(t, wait >= 0 && wait <= 30*time.Second, "wait %v out of range", wait)
+		}
+	})
+
+	suite.Run("Retry-After isn't jittered", func(t *testing.T) {
+		jittered := RetryPolicy{MaxAttempts: 5, Backoff: time.Second, MaxWait: 30 * time.Second}
+		assert.Equal(t, 7*time.Second, jittered.wait(1, withRetryAfter("7"), now))
+	})
+
+	suite.Run("RetryPolicy retries transient failures up to MaxAttempts", func(t *testing.T) {
+		wait, retry := policy.NextBackoff(1, nil, withRetryAfter("3"))
+		assert.True(t, retry)
+		assert.Equal(t, 3*time.Second, wait)
+
+		_, retry = policy.NextBackoff(1, errors.New("connection reset"), nil)
+		assert.True(t, retry)
+
+		_, retry = policy.NextBackoff(5, errors.New("connection reset"), nil)
+		assert.False(t, retry)
+
+		_, retry = policy.NextBackoff(1, nil, &http.Response{StatusCode: http.StatusNotFound})
+		assert.False(t, retry)
+
+		_, retry = RetryPolicy{}.NextBackoff(1, errors.New("connection reset"), nil)
+		assert.False(t, retry)
+	})
+
+	suite.Run("FixedRetry waits the same before each retry", func(t *testing.T) {
+		fixed := FixedRetry{MaxAttempts: 3, Wait: 2 * time.Second}
+
+		for attempt := 1; attempt < 3; attempt++ {
+			wait, retry := fixed.NextBackoff(attempt, errors.New("connection reset"), nil)
+			assert.True(t, retry)
+			assert.Equal(t, 2*time.Second, wait)
+		}
+
+		_, retry := fixed.NextBackoff(3, errors.New("connection reset"), nil)
+		assert.False(t, retry)
+
+		wait, retry := fixed.NextBackoff(1, nil, withRetryAfter("5"))
+		assert.True(t, retry)
+		assert.Equal(t, 5*time.Second, wait)
+
+		_, retry = fixed.NextBackoff(1, nil, &http.Response{StatusCode: http.StatusForbidden})
+		assert.False(t, retry)
+	})
+
+	suite.Run("FixedRetry waits are capped", func(t *testing.T) {
+		wait, retry := FixedRetry{MaxAttempts: 3, Wait: time.Second}.NextBackoff(1, nil, withRetryAfter("86400"))
+		assert.True(t, retry)
+		assert.Equal(t, time.Minute, wait)
+
+		capped := FixedRetry{MaxAttempts: 3, Wait: time.Hour, MaxWait: 30 * time.Second}
+		wait, _ = capped.NextBackoff(1, errors.New("connection reset"), nil)
+		assert.Equal(t, 30*time.Second, wait)
+
+		wait, _ = capped.NextBackoff(1, nil, withRetryAfter("86400"))
+		assert.Equal(t, 30*time.Second, wait)
+	})
+
+	suite.Run("NoRetry never retries", func(t *testing.T) {
+		_, retry := NoRetry{}.NextBackoff(1, errors.New("connection reset"), nil)
+		assert.False(t, retry)
+	})
+
+	suite.Run("custom Retrier is consulted for each failed attempt", func(t *testing.T) {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) < 3 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("part"))
+		}))
+		defer server.Close()
+
+		// unlike the built-in Retriers it retries a 404
+		retrier := &countingRetrier{limit: 5}
+		session := newFetchSession(Options{Retry: retrier})
+
+		response, err := requestWithRetries(context.Background(), &http.Client{}, nil, "part", server.URL, 0, nil, session)
+		assert.Nil(t, err)
+		response.Body.Close()
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&retrier.consulted))
+	})
+
+	suite.Run("canceled request isn't retried", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cancel()
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		retrier := &countingRetrier{limit: 5}
+		session := newFetchSession(Options{Retry: retrier})
+
+		if response, _ := requestWithRetries(ctx, &http.Client{}, nil, "part", server.URL, 0, nil, session); response != nil {
+			response.Body.Close()
+		}
+		assert.Equal(t, int32(0), atomic.LoadInt32(&retrier.consulted))
+	})
+}
@@ -0,0 +1,83 @@
+package fetch
+
+import (
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"math/rand"
+)
+
+// SourceSelection is a faux-enum identifying how the order in which a part's
+// sources are tried is chosen
+type SourceSelection string
+
+const (
+	// ORDERED tries sources by descending Priority and then in the order
+	// they're listed; it is the default
+	ORDERED SourceSelection = "ORDERED"
+
+	// FASTEST requests a part's sources concurrently and uses the first to
+	// respond; Options.RaceSources, if set, limits how many are requested
+	FASTEST SourceSelection = "FASTEST"
+
+	// WEIGHTED starts with a source chosen at random, weighted by Weight,
+	// from those of the highest Priority and then falls back to the others
+	// in ORDERED order, spreading load across equivalent mirrors
+	WEIGHTED SourceSelection = "WEIGHTED"
+)
+
+// orderedSources returns a copy of sources in the order they're tried with
+// the session's SourceSelection
+func (s *fetchSession) orderedSources(sources []horizonpkg.PartSource) []horizonpkg.PartSource {
+	ordered := prioritizedSources(sources)
+	if s.opts.SourceSelection != WEIGHTED || len(ordered) < 2 {
+		return ordered
+	}
+
+	// the candidates are the sources of the highest Priority
+	var candidates int
+	var total int
+	for _, source := range ordered {
+		if source.Priority != ordered[0].Priority {
+			break
+		}
+		candidates++
+		total += sourceWeight(source)
+	}
+
+	pick := rand.Intn(total)
+	for ix := 0; ix < candidates; ix++ {
+		pick -= sourceWeight(ordered[ix])
+		if pick < 0 {
+			chosen := ordered[ix]
+			copy(ordered[1:ix+1], ordered[:ix])
+			ordered[0] = chosen
+			break
+		}
+	}
+
+	return ordered
+}
+
+// racedSources returns the number of sources, of n, requested concurrently
+// with the session's SourceSelection and RaceSources; fewer than 2 means
+// sources are tried one after another
+func (s *fetchSession) racedSources(n int) int {
+	race := s.opts.RaceSources
+	if s.opts.SourceSelection == FASTEST && (race < 2 || race > n) {
+		race = n
+	}
+
+	if race > n {
+		return n
+	}
+
+	return race
+}
+
+// sourceWeight returns the Weight of source; weights less than 1 count as 1
+func sourceWeight(source horizonpkg.PartSource) int {
+	if source.Weight < 1 {
+		return 1
+	}
+
+	return source.Weight
+}
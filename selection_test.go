@@ -0,0 +1,60 @@
+// +build unit
+
+package fetch
+
+import (
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_SourceSelection_Suite(suite *testing.T) {
+	sources := []horizonpkg.PartSource{
+		{URL: "/archive", Priority: -1},
+		{URL: "/mirror-a", Weight: 3},
+		{URL: "/mirror-b"},
+	}
+
+	urls := func(sources []horizonpkg.PartSource) []string {
+		var urls []string
+		for _, source := range sources {
+			urls = append(urls, source.URL)
+		}
+		return urls
+	}
+
+	suite.Run("sources are strictly ordered by default", func(t *testing.T) {
+		for _, selection := range []SourceSelection{"", ORDERED, FASTEST} {
+			session := newFetchSession(Options{SourceSelection: selection})
+			assert.Equal(t, []string{"/mirror-a", "/mirror-b", "/archive"}, urls(session.orderedSources(sources)))
+		}
+	})
+
+	suite.Run("weighted selection spreads the first source across equivalent mirrors", func(t *testing.T) {
+		session := newFetchSession(Options{SourceSelection: WEIGHTED})
+
+		first := make(map[string]int)
+		for ix := 0; ix < 1000; ix++ {
+			ordered := session.orderedSources(sources)
+			first[ordered[0].URL]++
+
+			// the others remain to fall back on, lower priorities last
+			assert.Equal(t, 3, len(ordered))
+			assert.Equal(t, "/archive", ordered[2].URL)
+		}
+
+		assert.Equal(t, 0, first["/archive"])
+		assert.InDelta(t, 750, first["/mirror-a"], 100)
+		assert.InDelta(t, 250, first["/mirror-b"], 100)
+
+		// the sources given aren't reordered
+		assert.Equal(t, "/archive", sources[0].URL)
+	})
+
+	suite.Run("fastest selection races all sources unless limited", func(t *testing.T) {
+		assert.Equal(t, 3, newFetchSession(Options{SourceSelection: FASTEST}).racedSources(3))
+		assert.Equal(t, 2, newFetchSession(Options{SourceSelection: FASTEST, RaceSources: 2}).racedSources(3))
+		assert.Equal(t, 2, newFetchSession(Options{RaceSources: 5}).racedSources(2))
+		assert.Equal(t, 0, newFetchSession(Options{}).racedSources(3))
+	})
+}
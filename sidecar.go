@@ -0,0 +1,122 @@
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// sidecarSuffix is appended to the path of a part source with a signature
+// sidecar to get the URL of the sidecar
+const sidecarSuffix = ".sig"
+
+// maxSidecarBytes bounds the size of a signature sidecar read
+const maxSidecarBytes = 64 * 1024
+
+// fetchSidecarSignature fetches the signature sidecar of the part partID
+// downloaded from pURL and records its signature so that the part is
+// verified with it as well as its signatures in the meta; it returns whether
+// it did. A sidecar that can't be fetched is logged and skipped, the part
+// then fails verification unless one of its other signatures is valid.
+func fetchSidecarSignature(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, partID string, pURL string, session *fetchSession) bool {
+	if session.opts.InsecureSkipSignatureVerification {
+		return false
+	}
+
+	sidecarURL, err := sidecarURLOf(pURL)
+	if err != nil {
+		session.log.Errorf("Failed to get the signature sidecar URL of part %v from %v. Error: %v", partID, pURL, err)
+		return false
+	}
+
+	response, err := requestWithRetries(ctx, client, authCreds, partID, sidecarURL, 0, nil, session)
+	if err != nil {
+		session.log.Errorf("Failed to fetch signature sidecar %v of part %v. Error: %v", sidecarURL, partID, err)
+		return false
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		session.log.Errorf("Failed to fetch signature sidecar %v of part %v. HTTP Status code: %v", sidecarURL, partID, response.StatusCode)
+		return false
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxSidecarBytes))
+	if err != nil {
+		session.log.Errorf("Failed to read signature sidecar %v of part %v. Error: %v", sidecarURL, partID, err)
+		return false
+	}
+
+	signature := strings.TrimSpace(string(content))
+	if signature == "" {
+		session.log.Errorf("Signature sidecar %v of part %v is empty, skipping it", sidecarURL, partID)
+		return false
+	}
+
+	session.log.Infof(3, "Fetched signature sidecar %v of part %v", sidecarURL, partID)
+	session.recordSidecarSignature(partID, signature)
+	return true
+}
+
+// sidecarURLOf returns the URL of the signature sidecar of the part source at
+// pURL: that of the source with sidecarSuffix appended to its path, keeping
+// its query
+func sidecarURLOf(pURL string) (string, error) {
+	u, err := url.Parse(pURL)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path += sidecarSuffix
+	if u.RawPath != "" {
+		u.RawPath += sidecarSuffix
+	}
+	return u.String(), nil
+}
+
+// fetchSidecarSignatures fetches the signature sidecar of the first of a
+// part's sources with one that can be fetched, for a part that wasn't
+// downloaded from any of them
+func fetchSidecarSignatures(ctx context.Context, client *http.Client, authCreds map[string]map[string]string, pkgURLBase string, partID string, sources []horizonpkg.PartSource, session *fetchSession) {
+	for _, source := range sources {
+		if source.SignatureSidecar && fetchSidecarSignature(ctx, client, authCreds, partID, partSourceURL(pkgURLBase, source, session), session) {
+			return
+		}
+	}
+}
+
+// recordSidecarSignature records a signature of the named part fetched from
+// a sidecar
+func (s *fetchSession) recordSidecarSignature(name string, signature string) {
+	s.sidecarLock.Lock()
+	defer s.sidecarLock.Unlock()
+
+	if s.sidecarSignatures == nil {
+		s.sidecarSignatures = make(map[string][]string)
+	}
+	s.sidecarSignatures[name] = append(s.sidecarSignatures[name], signature)
+}
+
+// shareSidecarSignatures records the sidecar signatures of the named part
+// for duplicate, a part with the same content that wasn't downloaded itself
+func (s *fetchSession) shareSidecarSignatures(name string, duplicate string) {
+	s.sidecarLock.Lock()
+	defer s.sidecarLock.Unlock()
+
+	if signatures := s.sidecarSignatures[name]; len(signatures) > 0 {
+		s.sidecarSignatures[duplicate] = append(s.sidecarSignatures[duplicate], signatures...)
+	}
+}
+
+// sidecarSignaturesOf returns the signatures of the named part fetched from
+// sidecars
+func (s *fetchSession) sidecarSignaturesOf(name string) []string {
+	s.sidecarLock.Lock()
+	defer s.sidecarLock.Unlock()
+
+	return s.sidecarSignatures[name]
+}
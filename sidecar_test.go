@@ -0,0 +1,120 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func Test_SignatureSidecar_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-sidecar-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(suite, err)
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.Nil(suite, err)
+
+	keysDir := path.Join(tmpDir, "keys")
+	assert.Nil(suite, os.Mkdir(keysDir, 0700))
+	assert.Nil(suite, ioutil.WriteFile(path.Join(keysDir, "ed25519.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	sign := func(content []byte) string {
+		digest := sha256.Sum256(content)
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, digest[:]))
+	}
+
+	content := []byte("sidecar part content")
+
+	// parts are served at any path not under /missing, their sidecars
+	// alongside; the URL of each request is recorded
+	var lock sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requested = append(requested, r.URL.String())
+		lock.Unlock()
+
+		if path.Ext(r.URL.Path) == sidecarSuffix {
+			if path.Dir(r.URL.Path) == "/missing" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(sign(content) + "\n"))
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	fetch := func(name string, source string, signatures []string) error {
+		lock.Lock()
+		requested = nil
+		lock.Unlock()
+
+		destinationDir := path.Join(tmpDir, name)
+		assert.Nil(suite, os.MkdirAll(destinationDir, 0700))
+
+		part := horizonpkg.DockerImagePart{
+			ID:         "part",
+			Bytes:      int64(len(content)),
+			Sha256sum:  fmt.Sprintf("%x", sha256.Sum256(content)),
+			Signatures: signatures,
+			Sources:    []horizonpkg.PartSource{{URL: source, SignatureSidecar: true}},
+		}
+		_, err := fetchAndVerify(context.Background(), &http.Client{}, nil, server.URL, horizonpkg.DockerImageParts{"part": part}, destinationDir, "", keysDir, newFetchSession(Options{}))
+		return err
+	}
+
+	suite.Run("sidecar URL is the source's with a suffixed path", func(t *testing.T) {
+		for pURL, expected := range map[string]string{
+			"https://example.com/parts/part.tgz":               "https://example.com/parts/part.tgz.sig",
+			"https://example.com/parts/part.tgz?token=abc&x=1": "https://example.com/parts/part.tgz.sig?token=abc&x=1",
+			"https://example.com/parts/a%2Fb":                  "https://example.com/parts/a%2Fb.sig",
+			"file:///srv/parts/part.tgz":                       "file:///srv/parts/part.tgz.sig",
+		} {
+			sidecar, err := sidecarURLOf(pURL)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, sidecar, pURL)
+		}
+
+		_, err := sidecarURLOf("http://[::1")
+		assert.NotNil(t, err)
+	})
+
+	suite.Run("part is verified with its sidecar alone", func(t *testing.T) {
+		assert.Nil(t, fetch("sidecar-only", "/parts/part", nil))
+		assert.Contains(t, requested, "/parts/part.sig")
+	})
+
+	suite.Run("missing sidecar falls back to the signatures in the meta", func(t *testing.T) {
+		assert.Nil(t, fetch("fallback", "/missing/part", []string{sign(content)}))
+		assert.Contains(t, requested, "/missing/part.sig")
+
+		assert.NotNil(t, fetch("fallback-bad", "/missing/part", []string{sign([]byte("other content"))}))
+	})
+
+	suite.Run("sidecar of a source with a query keeps it", func(t *testing.T) {
+		assert.Nil(t, fetch("query", "/parts/part?token=abc", nil))
+		assert.Contains(t, requested, "/parts/part?token=abc")
+		assert.Contains(t, requested, "/parts/part.sig?token=abc")
+	})
+}
@@ -0,0 +1,191 @@
+package fetch
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// keyCache holds the public keys parsed from key files so each file is read
+// and parsed once per fetch however many signatures are verified with it. A
+// new cache is used for each fetch so keys rotated between fetches are used.
+type keyCache struct {
+	lock sync.Mutex
+
+	// by path; nil if the file has no usable key
+	keys map[string]crypto.PublicKey
+}
+
+func newKeyCache() *keyCache {
+	return &keyCache{
+		keys: make(map[string]crypto.PublicKey),
+	}
+}
+
+// load returns the ed25519 and RSA public keys among the PEM-encoded keys in
+// primarySigningKeys and the .pem files in userKeysDir, in that order. Keys
+// of other types and unreadable files are skipped.
+func (c *keyCache) load(primarySigningKeys []string, userKeysDir string) ([]ed25519.PublicKey, []*rsa.PublicKey) {
+	files := append([]string(nil), primarySigningKeys...)
+
+	if userKeysDir != "" {
+		matches, _ := filepath.Glob(filepath.Join(userKeysDir, "*.pem"))
+		files = append(files, matches...)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var edKeys []ed25519.PublicKey
+	var rsaKeys []*rsa.PublicKey
+	for _, file := range files {
+		key, cached := c.keys[file]
+		if !cached {
+			key = loadPublicKey(file)
+			c.keys[file] = key
+		}
+
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			edKeys = append(edKeys, k)
+		case *rsa.PublicKey:
+			rsaKeys = append(rsaKeys, k)
+		}
+	}
+
+	return edKeys, rsaKeys
+}
+
+// loadPublicKey returns the PEM-encoded public key in file, nil if there is
+// none that can be parsed
+func loadPublicKey(file string) crypto.PublicKey {
+	key, _ := readPublicKey(file)
+	return key
+}
+
+// readPublicKey returns the PEM-encoded public key in file or an error saying
+// why there is none that can be parsed
+func readPublicKey(file string) (crypto.PublicKey, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("PEM block of type %v is not a PKIX or PKCS #1 public key", block.Type)
+}
+
+// validate returns a PkgSigningKeyError if any of primarySigningKeys or of
+// the .pem files in userKeysDir, if given, doesn't hold an ed25519 or RSA
+// public key, or if there are no keys and signatures are verified. Parsed
+// keys are cached for the fetch.
+func (c *keyCache) validate(primarySigningKeys []string, userKeysDir string, skipVerification bool) error {
+	files := append([]string(nil), primarySigningKeys...)
+
+	if userKeysDir != "" {
+		info, err := os.Stat(userKeysDir)
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("%v is not a directory", userKeysDir)
+		}
+		if err != nil {
+			return fetcherrors.PkgSigningKeyError{fmt.Sprintf("User keys directory %v can't be read", userKeysDir), err}
+		}
+
+		matches, err := filepath.Glob(filepath.Join(userKeysDir, "*.pem"))
+		if err != nil {
+			return fetcherrors.PkgSigningKeyError{fmt.Sprintf("User keys directory %v can't be read", userKeysDir), err}
+		}
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 && !skipVerification {
+		return fetcherrors.PkgSigningKeyError{"No signing keys are configured", errors.New("neither a primary signing key nor a user keys directory with keys was given")}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, file := range files {
+		key, err := readPublicKey(file)
+		if err == nil {
+			switch key.(type) {
+			case ed25519.PublicKey, *rsa.PublicKey:
+			default:
+				err = fmt.Errorf("key of type %T is neither ed25519 nor RSA", key)
+			}
+		}
+		if err != nil {
+			return fetcherrors.PkgSigningKeyError{fmt.Sprintf("Signing key %v can't be loaded", file), err}
+		}
+
+		c.keys[file] = key
+	}
+
+	return nil
+}
+
+// primarySigningKeys returns the paths of the session's primary signing keys:
+// primarySigningKey, if given, followed by its PrimarySigningKeys
+func (s *fetchSession) primarySigningKeys(primarySigningKey string) []string {
+	if primarySigningKey == "" {
+		return s.opts.PrimarySigningKeys
+	}
+
+	return append([]string{primarySigningKey}, s.opts.PrimarySigningKeys...)
+}
+
+// verifyEd25519 reports whether the base64-encoded signature is an ed25519
+// signature of the SHA-256 digest by any of the given keys
+func verifyEd25519(keys []ed25519.PublicKey, signature string, digest []byte) bool {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, digest, sig) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyRSAPSS reports whether the base64-encoded signature is an RSA-PSS
+// signature of the SHA-256 digest by any of the given keys
+func verifyRSAPSS(keys []*rsa.PublicKey, signature string, digest []byte) (bool, error) {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, err
+	}
+
+	for _, key := range keys {
+		if rsa.VerifyPSS(key, crypto.SHA256, digest, sig, nil) == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
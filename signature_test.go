@@ -0,0 +1,354 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_Ed25519Signature_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-ed25519-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(suite, err)
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.Nil(suite, err)
+
+	keysDir := path.Join(tmpDir, "keys")
+	assert.Nil(suite, os.Mkdir(keysDir, 0700))
+	assert.Nil(suite, ioutil.WriteFile(path.Join(keysDir, "ed25519.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	sign := func(content []byte) string {
+		digest := sha256.Sum256(content)
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, digest[:]))
+	}
+
+	session := newFetchSession(Options{})
+
+	suite.Run("part with ed25519 signature is verified", func(t *testing.T) {
+		content := []byte("part content")
+		partPath := path.Join(tmpDir, "part")
+		assert.Nil(t, ioutil.WriteFile(partPath, content, 0600))
+
+		sum := fmt.Sprintf("%x", sha256.Sum256(content))
+
+		assert.Nil(t, verifyPkgPart(context.Background(), "", keysDir, partPath, sum, nil, []string{sign(content)}, session))
+		assert.NotNil(t, verifyPkgPart(context.Background(), "", keysDir, partPath, sum, nil, []string{sign([]byte("other content"))}, session))
+	})
+
+	suite.Run("Pkg meta with ed25519 signature is verified", func(t *testing.T) {
+		rawBody, err := json.Marshal(horizonpkg.Pkg{
+			ID: "pkg",
+			Meta: &horizonpkg.Meta{
+				SpecVersion: "0.1.0",
+				Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"part": "image:latest"}},
+			},
+			Parts: horizonpkg.DockerImageParts{"part": {ID: "part"}},
+		})
+		assert.Nil(t, err)
+
+		pkg, err := parsePkgMeta(context.Background(), rawBody, "", keysDir, "test", sign(rawBody), session)
+		assert.Nil(t, err)
+		assert.Equal(t, "pkg", pkg.ID)
+	})
+
+	suite.Run("parts fetched are those of the verified Pkg meta", func(t *testing.T) {
+		signed := func(id string, sum string) (*horizonpkg.Pkg, *fetchSession) {
+			rawBody, err := json.Marshal(horizonpkg.Pkg{
+				ID: id,
+				Meta: &horizonpkg.Meta{
+					SpecVersion: "0.1.0",
+					Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"part": "image:latest"}},
+				},
+				Parts: horizonpkg.DockerImageParts{"part": {ID: "part", Sha256sum: sum, Sources: []horizonpkg.PartSource{{URL: "https://signed/part"}}}},
+			})
+			assert.Nil(t, err)
+
+			session := newFetchSession(Options{})
+			pkg, err := parsePkgMeta(context.Background(), rawBody, "", keysDir, "test", sign(rawBody), session)
+			assert.Nil(t, err)
+			return pkg, session
+		}
+
+		pkg, session := signed("pkg", "signedsum")
+
+		// tampering with the parsed Pkg after verification has no effect
+		pkg.Parts["part"] = horizonpkg.DockerImagePart{ID: "part", Sha256sum: "substituted", Sources: []horizonpkg.PartSource{{URL: "https://attacker/part"}}}
+		pkg.Parts["extra"] = horizonpkg.DockerImagePart{ID: "extra"}
+
+		parts, err := precheckPkgParts(pkg, nil, session)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(parts))
+		assert.Equal(t, "signedsum", parts["part"].Sha256sum)
+		assert.Equal(t, "https://signed/part", parts["part"].Sources[0].URL)
+
+		// nor does changing the returned parts change later prechecks
+		parts["part"].Sources[0].URL = "https://attacker/part"
+		parts, err = precheckPkgParts(pkg, nil, session)
+		assert.Nil(t, err)
+		assert.Equal(t, "https://signed/part", parts["part"].Sources[0].URL)
+
+		// a Pkg from other verified meta can't be paired with the session
+		other, _ := signed("other", "othersum")
+		_, err = precheckPkgParts(other, nil, session)
+		assert.NotNil(t, err)
+
+		// nor can a Pkg without verified meta
+		_, err = precheckPkgParts(pkg, nil, newFetchSession(Options{}))
+		assert.NotNil(t, err)
+	})
+	suite.Run("signature verification is only skipped when explicitly disabled", func(t *testing.T) {
+		rawBody, err := json.Marshal(horizonpkg.Pkg{
+			ID: "pkg",
+			Meta: &horizonpkg.Meta{
+				SpecVersion: "0.1.0",
+				Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"part": "image:latest"}},
+			},
+			Parts: horizonpkg.DockerImageParts{"part": {ID: "part"}},
+		})
+		assert.Nil(t, err)
+
+		content := []byte("unsigned part content")
+		partPath := path.Join(tmpDir, "unsigned")
+		assert.Nil(t, ioutil.WriteFile(partPath, content, 0600))
+		sum := fmt.Sprintf("%x", sha256.Sum256(content))
+
+		assert.NotNil(t, session.requireSignature(""))
+		_, err = parsePkgMeta(context.Background(), rawBody, "", keysDir, "test", "bogus", session)
+		assert.NotNil(t, err)
+		assert.NotNil(t, verifyPkgPart(context.Background(), "", keysDir, partPath, sum, nil, nil, session))
+
+		insecure := newFetchSession(Options{InsecureSkipSignatureVerification: true})
+		assert.Nil(t, insecure.requireSignature(""))
+		pkg, err := parsePkgMeta(context.Background(), rawBody, "", keysDir, "test", "", insecure)
+		assert.Nil(t, err)
+		assert.Equal(t, "pkg", pkg.ID)
+		assert.Nil(t, verifyPkgPart(context.Background(), "", keysDir, partPath, sum, nil, nil, insecure))
+
+		// hashes are still checked
+		assert.NotNil(t, verifyPkgPart(context.Background(), "", keysDir, partPath, fmt.Sprintf("%x", sha256.Sum256([]byte("other"))), nil, nil, insecure))
+	})
+	suite.Run("detached signatures supplied out of band are used", func(t *testing.T) {
+		rawBody, err := json.Marshal(horizonpkg.Pkg{
+			ID: "pkg",
+			Meta: &horizonpkg.Meta{
+				SpecVersion: "0.1.0",
+				Provides:    horizonpkg.DockerPartsProvides{horizonpkg.DOCKER, horizonpkg.DockerImagePartNames{"part": "image:latest"}},
+			},
+			Parts: horizonpkg.DockerImageParts{"part": {ID: "part"}},
+		})
+		assert.Nil(t, err)
+
+		// the meta's detached signature stands in for the pkgURLSignature
+		detached := newFetchSession(Options{MetaSignatures: []string{sign(rawBody)}})
+		assert.Nil(t, detached.requireSignature(""))
+		_, err = parsePkgMeta(context.Background(), rawBody, "", keysDir, "test", "", detached)
+		assert.Nil(t, err)
+
+		// or augments it
+		_, err = parsePkgMeta(context.Background(), rawBody, "", keysDir, "test", "bogus", detached)
+		assert.Nil(t, err)
+
+		_, err = parsePkgMeta(context.Background(), rawBody, "", keysDir, "test", "", newFetchSession(Options{MetaSignatures: []string{"bogus"}}))
+		assert.NotNil(t, err)
+
+		// a part's detached signatures are preferred to those in the meta
+		part := horizonpkg.DockerImagePart{ID: "part", Signatures: []string{"in-meta"}}
+		assert.Equal(t, []string{"in-meta"}, newFetchSession(Options{}).partSignatures("part", part))
+		assert.Equal(t, []string{"detached"}, newFetchSession(Options{PartSignatures: map[string][]string{"part": {"detached"}}}).partSignatures("part", part))
+		assert.Equal(t, []string{"in-meta"}, newFetchSession(Options{PartSignatures: map[string][]string{"other": {"detached"}}}).partSignatures("part", part))
+	})
+
+	suite.Run("signatures in sidecars of part sources are tried", func(t *testing.T) {
+		content := []byte("sidecar part content")
+		var sidecars int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if path.Ext(r.URL.Path) == ".sig" {
+				sidecars++
+				w.Write([]byte(sign(content) + "\n"))
+				return
+			}
+			w.Write(content)
+		}))
+		defer server.Close()
+
+		part := func(sidecar bool) horizonpkg.DockerImagePart {
+			return horizonpkg.DockerImagePart{
+				ID:         "part",
+				Bytes:      int64(len(content)),
+				Sha256sum:  fmt.Sprintf("%x", sha256.Sum256(content)),
+				Signatures: []string{sign([]byte("other content"))},
+				Sources:    []horizonpkg.PartSource{{URL: "/part", SignatureSidecar: sidecar}},
+			}
+		}
+
+		fetch := func(name string, sidecar bool) error {
+			destinationDir := path.Join(tmpDir, name)
+			assert.Nil(t, os.MkdirAll(destinationDir, 0700))
+			_, err := fetchAndVerify(context.Background(), &http.Client{}, nil, server.URL, horizonpkg.DockerImageParts{"part": part(sidecar)}, destinationDir, "", keysDir, newFetchSession(Options{}))
+			return err
+		}
+
+		assert.Nil(t, fetch("sidecar", true))
+		assert.Equal(t, 1, sidecars)
+
+		// a part already on disk is verified with its sidecar too
+		assert.Nil(t, fetch("sidecar", true))
+		assert.Equal(t, 2, sidecars)
+
+		assert.NotNil(t, fetch("no-sidecar", false))
+		assert.Equal(t, 2, sidecars)
+	})
+
+	suite.Run("verification stops when the context is canceled", func(t *testing.T) {
+		content := []byte("part content")
+		partPath := path.Join(tmpDir, "canceled")
+		assert.Nil(t, ioutil.WriteFile(partPath, content, 0600))
+		sum := fmt.Sprintf("%x", sha256.Sum256(content))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := verifyPkgPart(ctx, "", keysDir, partPath, sum, nil, []string{sign([]byte("other content")), sign(content)}, session)
+		assert.Equal(t, context.Canceled, err)
+
+		// the part isn't treated as having failed verification
+		_, statErr := os.Stat(partPath)
+		assert.Nil(t, statErr)
+	})
+	suite.Run("keys are parsed once per fetch", func(t *testing.T) {
+		rotatedDir := path.Join(tmpDir, "rotated")
+		assert.Nil(t, os.Mkdir(rotatedDir, 0700))
+		keyPath := path.Join(rotatedDir, "ed25519.pem")
+		assert.Nil(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+		content := []byte("part content")
+		partPath := path.Join(tmpDir, "rotated-part")
+		assert.Nil(t, ioutil.WriteFile(partPath, content, 0600))
+		sum := fmt.Sprintf("%x", sha256.Sum256(content))
+
+		session := newFetchSession(Options{})
+		assert.Nil(t, verifyPkgPart(context.Background(), "", rotatedDir, partPath, sum, nil, []string{sign(content)}, session))
+
+		// the cached key is used for the rest of the fetch
+		assert.Nil(t, ioutil.WriteFile(keyPath, []byte("not a key"), 0600))
+		assert.Nil(t, verifyPkgPart(context.Background(), "", rotatedDir, partPath, sum, nil, []string{sign(content)}, session))
+
+		// but not by later fetches
+		assert.NotNil(t, verifyPkgPart(context.Background(), "", rotatedDir, partPath, sum, nil, []string{sign(content)}, newFetchSession(Options{})))
+	})
+
+	suite.Run("content signed with any of several primary keys is verified", func(t *testing.T) {
+		newPublicKey, newPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+		assert.Nil(t, err)
+		newDer, err := x509.MarshalPKIXPublicKey(newPublicKey)
+		assert.Nil(t, err)
+
+		oldKeyPath := path.Join(tmpDir, "old.pem")
+		assert.Nil(t, ioutil.WriteFile(oldKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+		newKeyPath := path.Join(tmpDir, "new.pem")
+		assert.Nil(t, ioutil.WriteFile(newKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: newDer}), 0600))
+
+		content := []byte("part content")
+		partPath := path.Join(tmpDir, "rotating-part")
+		assert.Nil(t, ioutil.WriteFile(partPath, content, 0600))
+		sum := fmt.Sprintf("%x", sha256.Sum256(content))
+
+		digest := sha256.Sum256(content)
+		newSignature := base64.StdEncoding.EncodeToString(ed25519.Sign(newPrivateKey, digest[:]))
+
+		session := newFetchSession(Options{PrimarySigningKeys: []string{newKeyPath}})
+		assert.Nil(t, verifyPkgPart(context.Background(), oldKeyPath, "", partPath, sum, nil, []string{sign(content)}, session))
+		assert.Nil(t, verifyPkgPart(context.Background(), oldKeyPath, "", partPath, sum, nil, []string{newSignature}, session))
+
+		// without the new key only the old signature verifies
+		assert.NotNil(t, verifyPkgPart(context.Background(), oldKeyPath, "", partPath, sum, nil, []string{newSignature}, newFetchSession(Options{})))
+	})
+}
+
+func Test_SigningKeyValidation_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-keys-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(suite, err)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.Nil(suite, err)
+
+	keyPath := path.Join(tmpDir, "key.pem")
+	assert.Nil(suite, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	malformedPath := path.Join(tmpDir, "malformed.pem")
+	assert.Nil(suite, ioutil.WriteFile(malformedPath, []byte("not a key"), 0600))
+
+	keysDir := path.Join(tmpDir, "keys")
+	assert.Nil(suite, os.Mkdir(keysDir, 0700))
+
+	validate := func(primarySigningKey string, userKeysDir string, skipVerification bool) error {
+		session := newFetchSession(Options{})
+		return session.keys.validate(session.primarySigningKeys(primarySigningKey), userKeysDir, skipVerification)
+	}
+
+	suite.Run("usable keys are valid", func(t *testing.T) {
+		assert.Nil(t, validate(keyPath, "", false))
+		assert.Nil(t, validate(keyPath, keysDir, false))
+		assert.Nil(t, validate("", "", true))
+	})
+
+	suite.Run("missing or malformed keys aren't", func(t *testing.T) {
+		for _, keys := range [][]string{
+			{path.Join(tmpDir, "missing.pem"), ""},
+			{malformedPath, ""},
+			{keyPath, path.Join(tmpDir, "missing")},
+			{keyPath, keyPath},
+			{"", ""},
+		} {
+			assert.IsType(t, fetcherrors.PkgSigningKeyError{}, validate(keys[0], keys[1], false), keys)
+		}
+
+		assert.Nil(t, ioutil.WriteFile(path.Join(keysDir, "malformed.pem"), []byte("not a key"), 0600))
+		assert.IsType(t, fetcherrors.PkgSigningKeyError{}, validate(keyPath, keysDir, false))
+	})
+
+	suite.Run("fetch with an unusable key requests nothing", func(t *testing.T) {
+		requested := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+		}))
+		defer server.Close()
+
+		pkgURL, err := url.Parse(server.URL + "/pkg.json")
+		assert.Nil(t, err)
+
+		destinationDir := path.Join(tmpDir, "dest")
+		factory := func(overrideTimeoutS *uint) *http.Client { return &http.Client{} }
+		_, err = PkgFetchWithOptionsContext(context.Background(), factory, *pkgURL, "signature", destinationDir, malformedPath, "", nil, Options{ValidateSigningKeys: true})
+		assert.IsType(t, fetcherrors.PkgSigningKeyError{}, err)
+		assert.False(t, requested)
+
+		_, err = os.Stat(destinationDir)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,171 @@
+package fetch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keys of the credentials of an AWS SigV4-signed source
+const (
+	awsAccessKeyID     = "aws_access_key_id"
+	awsSecretAccessKey = "aws_secret_access_key"
+	awsSessionToken    = "aws_session_token"
+	awsRegion          = "aws_region"
+	awsService         = "aws_service"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+
+	// the sha256 of the empty body of GET requests
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// awsCredentials holds the credentials used to sign requests with AWS
+// Signature Version 4
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+	service         string
+}
+
+// sigV4Credentials returns the AWS credentials in creds, if it has any. If no
+// service is configured, requests are signed for S3.
+func sigV4Credentials(creds map[string]string) (*awsCredentials, bool) {
+	if creds[awsAccessKeyID] == "" || creds[awsSecretAccessKey] == "" || creds[awsRegion] == "" {
+		return nil, false
+	}
+
+	service := creds[awsService]
+	if service == "" {
+		service = "s3"
+	}
+
+	return &awsCredentials{
+		accessKeyID:     creds[awsAccessKeyID],
+		secretAccessKey: creds[awsSecretAccessKey],
+		sessionToken:    creds[awsSessionToken],
+		region:          creds[awsRegion],
+		service:         service,
+	}, true
+}
+
+// sigV4HeaderPrefix is the prefix of the headers set on a request signed
+// with AWS SigV4, besides Authorization
+const sigV4HeaderPrefix = "X-Amz-"
+
+// stripSigV4Headers removes the headers of an AWS SigV4 signature, including
+// any session token, from header
+func stripSigV4Headers(header http.Header) {
+	header.Del("Authorization")
+	for name := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), sigV4HeaderPrefix) {
+			header.Del(name)
+		}
+	}
+}
+
+// signSigV4 signs req, which must have no body, with AWS Signature Version 4
+// as of now
+func signSigV4(req *http.Request, creds *awsCredentials, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	date := now.Format("20060102")
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	}
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": host}
+	for _, name := range []string{"X-Amz-Content-Sha256", "X-Amz-Date", "X-Amz-Security-Token"} {
+		if value := req.Header.Get(name); value != "" {
+			headers[strings.ToLower(name)] = value
+		}
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += fmt.Sprintf("%v:%v\n", name, strings.TrimSpace(headers[name]))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, creds.region, creds.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(canonicalRequest))),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), date)
+	for _, part := range []string{creds.region, creds.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := fmt.Sprintf("%x", hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%v Credential=%v/%v, SignedHeaders=%v, Signature=%v", sigV4Algorithm, creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the SigV4 canonical form of query: its parameters
+// URI-encoded and sorted by name and then value
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, sigV4Escape(name)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// sigV4Escape URI-encodes s as SigV4 requires: all but unreserved characters
+// are percent-encoded
+func sigV4Escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func hmacSHA256(key []byte, content string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(content))
+	return mac.Sum(nil)
+}
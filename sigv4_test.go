@@ -0,0 +1,117 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SigV4_Suite(suite *testing.T) {
+	// from the AWS Signature Version 4 test suite
+	exampleCreds := map[string]string{
+		awsAccessKeyID:     "AKIDEXAMPLE",
+		awsSecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		awsRegion:          "us-east-1",
+		awsService:         "service",
+	}
+	exampleTime := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	suite.Run("request is signed per the AWS test suite", func(t *testing.T) {
+		creds, ok := sigV4Credentials(exampleCreds)
+		assert.True(t, ok)
+
+		req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+		assert.Nil(t, err)
+
+		signSigV4(req, creds, exampleTime)
+		assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+		assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+	})
+
+	suite.Run("query parameters are signed in canonical order", func(t *testing.T) {
+		creds, _ := sigV4Credentials(exampleCreds)
+
+		req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/?Param2=value2&Param1=value1", nil)
+		assert.Nil(t, err)
+
+		signSigV4(req, creds, exampleTime)
+		assert.True(t, strings.HasSuffix(req.Header.Get("Authorization"), "Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"))
+	})
+
+	suite.Run("S3 requests sign the payload hash and session token", func(t *testing.T) {
+		creds, ok := sigV4Credentials(map[string]string{
+			awsAccessKeyID:     "AKIDEXAMPLE",
+			awsSecretAccessKey: "secret",
+			awsRegion:          "us-east-1",
+			awsSessionToken:    "token",
+		})
+		assert.True(t, ok)
+		assert.Equal(t, "s3", creds.service)
+
+		req, err := http.NewRequest(http.MethodGet, "https://bucket.s3.amazonaws.com/pkg/part", nil)
+		assert.Nil(t, err)
+
+		signSigV4(req, creds, exampleTime)
+		assert.Equal(t, emptyPayloadHash, req.Header.Get("X-Amz-Content-Sha256"))
+		assert.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+		assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,")
+	})
+
+	suite.Run("signature isn't sent to redirect target on another host", func(t *testing.T) {
+		var targetHeader http.Header
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			targetHeader = r.Header
+		}))
+		defer target.Close()
+
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target.URL+"/blob", http.StatusFound)
+		}))
+		defer origin.Close()
+
+		authCreds := map[string]map[string]string{origin.URL: {
+			awsAccessKeyID:     "AKIDEXAMPLE",
+			awsSecretAccessKey: "secret",
+			awsRegion:          "us-east-1",
+			awsSessionToken:    "TOKEN",
+		}}
+		session := newFetchSession(Options{})
+
+		req, err := authenticatedRequest(context.Background(), origin.URL+"/part", authCreds, session)
+		assert.Nil(t, err)
+		assert.Equal(t, "TOKEN", req.Header.Get("X-Amz-Security-Token"))
+
+		response, err := withRedirectCredentials(&http.Client{}, authCreds, session).Do(req)
+		assert.Nil(t, err)
+		response.Body.Close()
+
+		if assert.NotNil(t, targetHeader) {
+			assert.Equal(t, "", targetHeader.Get("Authorization"))
+			for name := range targetHeader {
+				assert.False(t, strings.HasPrefix(name, "X-Amz-"), name)
+			}
+		}
+	})
+
+	suite.Run("incomplete AWS credentials aren't used", func(t *testing.T) {
+		_, ok := sigV4Credentials(map[string]string{awsAccessKeyID: "AKIDEXAMPLE", awsSecretAccessKey: "secret"})
+		assert.False(t, ok)
+	})
+
+	suite.Run("matching source is signed instead of given Basic auth", func(t *testing.T) {
+		creds := map[string]string{"username": "user", "password": "pass"}
+		for k, v := range exampleCreds {
+			creds[k] = v
+		}
+
+		req, err := authenticatedRequest(context.Background(), "https://example.amazonaws.com/part", map[string]map[string]string{"https://example.amazonaws.com": creds}, newFetchSession(Options{}))
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "))
+	})
+}
@@ -0,0 +1,76 @@
+package fetch
+
+import (
+	"os"
+	"path"
+)
+
+// stagedSuffix is the suffix of the file a staged part is copied to in the
+// destination before it is renamed into place
+const stagedSuffix = ".staged"
+
+// stagingDir returns the directory the parts of the Pkg fetched into
+// destinationDir are staged in, or "" if they are downloaded into
+// destinationDir directly
+func (s *fetchSession) stagingDir(destinationDir string) string {
+	if s.opts.StagingDir == "" || s.opts.Destination != nil || s.verifyOnly {
+		return ""
+	}
+
+	stagingDir := path.Join(s.opts.StagingDir, path.Base(destinationDir))
+	if stagingDir == path.Clean(destinationDir) {
+		return ""
+	}
+	return stagingDir
+}
+
+// stages reports whether the part of expectedBytes at partPath in the
+// destination is downloaded into the staging directory: it is unless a file
+// of its size is already in place, which is checked where it is
+func (s *fetchSession) stages(partPath string, expectedBytes int64) bool {
+	info, err := s.fs.Stat(partPath)
+	return err != nil || info.Size() != expectedBytes
+}
+
+// unstage moves the verified part file stagedPath, and any fetch manifest
+// alongside it, to partPath, replacing what is there. It is renamed if it can
+// be; otherwise it is copied beside partPath, renamed into place and removed
+// from staging so that partPath is never partially written.
+func (s *fetchSession) unstage(stagedPath string, partPath string) error {
+	if s.conditionalRequests() {
+		if err := s.moveFile(stagedPath+fetchManifestSuffix, partPath+fetchManifestSuffix); err != nil && !os.IsNotExist(err) {
+			s.log.Errorf("Failed to move fetch manifest of staged part %v. Error: %v", stagedPath, err)
+		}
+	}
+
+	if err := s.moveFile(stagedPath, partPath); err != nil {
+		return err
+	}
+
+	if sum := s.verifiedSum(stagedPath); sum != "" {
+		s.recordVerifiedSum(partPath, sum)
+	}
+	return nil
+}
+
+// moveFile renames src to dst or, if they're on different filesystems,
+// copies src to dst and removes it
+func (s *fetchSession) moveFile(src string, dst string) error {
+	if err := s.fs.Rename(src, dst); err == nil {
+		return nil
+	} else if _, statErr := s.fs.Stat(src); statErr != nil {
+		return err
+	}
+
+	copyPath := dst + stagedSuffix
+	if err := linkOrCopy(s.fs, src, copyPath, s.fileMode()); err != nil {
+		return err
+	}
+
+	if err := s.fs.Rename(copyPath, dst); err != nil {
+		s.fs.Remove(copyPath)
+		return err
+	}
+
+	return s.fs.Remove(src)
+}
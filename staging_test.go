@@ -0,0 +1,118 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+// crossDeviceFileSystem fails renames between directories as a rename across
+// filesystems would
+type crossDeviceFileSystem struct {
+	OSFileSystem
+}
+
+func (f crossDeviceFileSystem) Rename(oldpath string, newpath string) error {
+	if path.Dir(oldpath) != path.Dir(newpath) {
+		return &os.LinkError{"rename", oldpath, newpath, syscall.EXDEV}
+	}
+	return f.OSFileSystem.Rename(oldpath, newpath)
+}
+
+func (f crossDeviceFileSystem) Link(oldname string, newname string) error {
+	return &os.LinkError{"link", oldname, newname, syscall.EXDEV}
+}
+
+func Test_Staging_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-staging-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content" + r.URL.Path))
+	}))
+	defer server.Close()
+
+	part := func(name string, content string) horizonpkg.DockerImagePart {
+		return horizonpkg.DockerImagePart{
+			ID:        name,
+			Bytes:     int64(len(content)),
+			Sha256sum: fmt.Sprintf("%x", sha256.Sum256([]byte(content))),
+			Sources:   []horizonpkg.PartSource{{URL: "/" + name}},
+		}
+	}
+
+	fetch := func(name string, opts Options, parts horizonpkg.DockerImageParts) (string, string, *fetchSession, error) {
+		pkgDir := path.Join(tmpDir, "dest", name)
+		assert.Nil(suite, os.MkdirAll(pkgDir, 0700))
+
+		opts.StagingDir = path.Join(tmpDir, "staging")
+		opts.InsecureSkipSignatureVerification = true
+		session := newFetchSession(opts)
+
+		_, err := fetchAndVerify(context.Background(), &http.Client{}, nil, server.URL, parts, pkgDir, "", "", session)
+		return pkgDir, path.Join(opts.StagingDir, name), session, err
+	}
+
+	suite.Run("parts are verified in staging and moved to the destination", func(t *testing.T) {
+		for _, opts := range []Options{{}, {ResumeDownloads: true}, {FileSystem: crossDeviceFileSystem{}}} {
+			name := fmt.Sprintf("moved-%v-%T", opts.ResumeDownloads, opts.FileSystem)
+			pkgDir, stagingDir, session, err := fetch(name, opts, horizonpkg.DockerImageParts{
+				"a": part("a", "content/a"),
+				"b": part("b", "content/b"),
+			})
+			assert.Nil(t, err)
+
+			for _, name := range []string{"a", "b"} {
+				content, err := ioutil.ReadFile(path.Join(pkgDir, name))
+				assert.Nil(t, err)
+				assert.Equal(t, "content/"+name, string(content))
+			}
+			assert.Equal(t, map[string]string{"a": part("a", "content/a").Sha256sum, "b": part("b", "content/b").Sha256sum}, session.fetchedSums)
+
+			// staging is left empty
+			_, err = os.Stat(stagingDir)
+			assert.True(t, os.IsNotExist(err), name)
+		}
+	})
+
+	suite.Run("part that fails verification never reaches the destination", func(t *testing.T) {
+		pkgDir, _, _, err := fetch("corrupt", Options{}, horizonpkg.DockerImageParts{
+			"a": part("a", "content/a"),
+			"b": part("b", "something else"),
+		})
+		assert.NotNil(t, err)
+
+		// a may not be verified once b fails
+		_, err = os.Stat(path.Join(pkgDir, "b"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	suite.Run("part already in the destination isn't staged", func(t *testing.T) {
+		pkgDir := path.Join(tmpDir, "dest", "present")
+		assert.Nil(t, os.MkdirAll(pkgDir, 0700))
+		assert.Nil(t, ioutil.WriteFile(path.Join(pkgDir, "a"), []byte("content/a"), 0600))
+
+		session := newFetchSession(Options{StagingDir: path.Join(tmpDir, "staging")})
+		assert.False(t, session.stages(path.Join(pkgDir, "a"), int64(len("content/a"))))
+		assert.True(t, session.stages(path.Join(pkgDir, "a"), 1))
+		assert.True(t, session.stages(path.Join(pkgDir, "b"), 1))
+	})
+
+	suite.Run("staging in the destination is no staging", func(t *testing.T) {
+		assert.Equal(t, "", newFetchSession(Options{}).stagingDir("/var/pkgs/pkg"))
+		assert.Equal(t, "", newFetchSession(Options{StagingDir: "/var/pkgs/"}).stagingDir("/var/pkgs/pkg"))
+		assert.Equal(t, "/tmp/staging/pkg", newFetchSession(Options{StagingDir: "/tmp/staging"}).stagingDir("/var/pkgs/pkg"))
+	})
+}
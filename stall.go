@@ -0,0 +1,55 @@
+package fetch
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// errStalled is returned by a stallReader's Read once the reader has been
+// aborted for receiving no bytes within its idle window
+var errStalled = errors.New("no bytes received within idle window")
+
+// stallReader aborts reads from a response body if no bytes arrive within an
+// idle window. The window is reset on each successful Read.
+type stallReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+func newStallReader(body io.ReadCloser, timeout time.Duration) *stallReader {
+	reader := &stallReader{
+		body:    body,
+		timeout: timeout,
+	}
+
+	// closing the body unblocks a pending Read
+	reader.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&reader.stalled, 1)
+		body.Close()
+	})
+
+	return reader
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+
+	if atomic.LoadInt32(&r.stalled) == 1 {
+		return n, errStalled
+	}
+
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+
+	return n, err
+}
+
+// Stop ends the stall detection, it must be called when done reading
+func (r *stallReader) Stop() {
+	r.timer.Stop()
+}
@@ -0,0 +1,49 @@
+// +build unit
+
+package fetch
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func Test_StallReader_Suite(suite *testing.T) {
+
+	suite.Run("reader is aborted when no bytes arrive in idle window", func(t *testing.T) {
+		pipeReader, pipeWriter := io.Pipe()
+
+		go func() {
+			pipeWriter.Write([]byte("some bytes"))
+			// never write again, never close
+		}()
+
+		reader := newStallReader(pipeReader, 50*time.Millisecond)
+		defer reader.Stop()
+
+		read, err := io.Copy(ioutil.Discard, reader)
+		assert.Equal(t, errStalled, err)
+		assert.EqualValues(t, 10, read)
+	})
+
+	suite.Run("reader receiving bytes within idle window completes", func(t *testing.T) {
+		pipeReader, pipeWriter := io.Pipe()
+
+		go func() {
+			for ix := 0; ix < 5; ix++ {
+				time.Sleep(20 * time.Millisecond)
+				pipeWriter.Write([]byte("x"))
+			}
+			pipeWriter.Close()
+		}()
+
+		reader := newStallReader(pipeReader, 50*time.Millisecond)
+		defer reader.Stop()
+
+		read, err := io.Copy(ioutil.Discard, reader)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 5, read)
+	})
+}
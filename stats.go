@@ -0,0 +1,31 @@
+package fetch
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// countingReader counts the bytes read from a part source into its session's
+// downloaded bytes, failing reads once they exceed its BytesBudget
+type countingReader struct {
+	reader  io.Reader
+	session *fetchSession
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.session.overBudget() {
+		return 0, errBudgetExceeded
+	}
+
+	n, err := r.reader.Read(p)
+	atomic.AddInt64(&r.session.downloadedBytes, int64(n))
+	if err == nil && r.session.overBudget() {
+		err = errBudgetExceeded
+	}
+	return n, err
+}
+
+// addReused records bytes of part content satisfied without downloading them
+func (s *fetchSession) addReused(bytes int64) {
+	atomic.AddInt64(&s.reusedBytes, bytes)
+}
@@ -0,0 +1,155 @@
+package fetch
+
+import (
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"net/url"
+	"sync"
+)
+
+// PartResult is the outcome of fetching and verifying one part of a Pkg,
+// delivered by a FetchStream as soon as the part is done
+type PartResult struct {
+	// ID is the part's name
+	ID string
+
+	// Path is the absolute path of the fetched and verified part; empty if
+	// it failed
+	Path string
+
+	// Sha256sum is the hex-encoded sha256 of the part's content as computed
+	// when it was verified; empty if it failed
+	Sha256sum string
+
+	// Err is the error failing the part, nil if it was fetched and verified
+	Err error
+}
+
+// FetchStream is a fetch started by Fetcher.FetchStream that delivers the
+// outcome of each part as it completes, e.g. so that a pipeline can process
+// the parts fetched first while the others are still downloading.
+type FetchStream struct {
+	parts chan PartResult
+
+	// outcomes not yet received, forwarded to parts in order
+	lock     sync.Mutex
+	queue    []PartResult
+	queued   chan struct{}
+	finished bool
+
+	done   chan struct{}
+	result *FetchResult
+	err    error
+}
+
+// Parts returns the channel on which the outcome of each part is delivered
+// as it's fetched and verified, or fails, in the order they complete. The
+// channel is closed once the fetch is complete; a fetch that fails before
+// any part is fetched, e.g. because its meta can't be verified, delivers
+// nothing. Outcomes are queued until they're received so a slow consumer
+// doesn't slow the fetch.
+func (s *FetchStream) Parts() <-chan PartResult {
+	return s.parts
+}
+
+// Result waits for the fetch to complete and returns its result or error,
+// as Fetch would. Outcomes of parts not yet received from Parts are
+// discarded, so Result should be called once Parts is closed by callers
+// that receive them.
+func (s *FetchStream) Result() (*FetchResult, error) {
+	for range s.parts {
+	}
+
+	<-s.done
+	return s.result, s.err
+}
+
+// add queues the outcome of a part for delivery
+func (s *FetchStream) add(result PartResult) {
+	s.lock.Lock()
+	s.queue = append(s.queue, result)
+	s.lock.Unlock()
+
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// finish records the outcome of the fetch; Parts is closed once the queued
+// outcomes of its parts are delivered
+func (s *FetchStream) finish(result *FetchResult, err error) {
+	s.lock.Lock()
+	s.result = result
+	s.err = err
+	s.finished = true
+	s.lock.Unlock()
+
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// forward delivers the queued outcomes on parts until the fetch is finished
+// and they've all been delivered
+func (s *FetchStream) forward() {
+	for {
+		s.lock.Lock()
+		if len(s.queue) > 0 {
+			next := s.queue[0]
+			s.queue = s.queue[1:]
+			s.lock.Unlock()
+
+			s.parts <- next
+			continue
+		}
+
+		if s.finished {
+			s.lock.Unlock()
+			close(s.parts)
+			close(s.done)
+			return
+		}
+		s.lock.Unlock()
+
+		<-s.queued
+	}
+}
+
+// emitPart delivers the outcome of a part of a streamed fetch
+func (s *fetchSession) emitPart(result PartResult) {
+	if s.onPart != nil {
+		s.onPart(result)
+	}
+}
+
+// FetchStream starts fetching the Pkg at pkgURL into destinationDir like
+// Fetch and returns at once; the outcome of each part is delivered by the
+// returned FetchStream as soon as it's fetched and verified, and that of the
+// whole fetch by its Result. Canceling ctx aborts the fetch.
+func (f *Fetcher) FetchStream(ctx context.Context, pkgURL url.URL, pkgURLSignature string, destinationDir string) *FetchStream {
+	stream := &FetchStream{
+		parts:  make(chan PartResult),
+		queued: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	go stream.forward()
+
+	go func() {
+		session := newFetchSession(f.opts)
+		session.onPart = stream.add
+
+		client, err := f.configureClient(session)
+		if err != nil {
+			stream.finish(nil, fetcherrors.PkgSourceError{"Failed configuring HTTP client", err})
+			return
+		}
+		defer f.release(session)
+
+		stream.finish(f.fetch(ctx, client, pkgURL, pkgURLSignature, destinationDir, session))
+	}()
+
+	return stream
+}
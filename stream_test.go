@@ -0,0 +1,79 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"errors"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func Test_FetchStream_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-stream-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	newStream := func() *FetchStream {
+		stream := &FetchStream{
+			parts:  make(chan PartResult),
+			queued: make(chan struct{}, 1),
+			done:   make(chan struct{}),
+		}
+		go stream.forward()
+		return stream
+	}
+
+	suite.Run("outcomes are delivered in order without waiting for the consumer", func(t *testing.T) {
+		stream := newStream()
+
+		failure := errors.New("failed")
+		stream.add(PartResult{ID: "a", Path: "/pkgs/pkg/a", Sha256sum: "aa"})
+		stream.add(PartResult{ID: "b", Err: failure})
+		stream.finish(nil, failure)
+
+		var ids []string
+		for part := range stream.Parts() {
+			ids = append(ids, part.ID)
+		}
+		assert.Equal(t, []string{"a", "b"}, ids)
+
+		_, err := stream.Result()
+		assert.Equal(t, failure, err)
+	})
+
+	suite.Run("result discards outcomes not received", func(t *testing.T) {
+		stream := newStream()
+		stream.add(PartResult{ID: "a"})
+		stream.finish(&FetchResult{}, nil)
+
+		result, err := stream.Result()
+		assert.Nil(t, err)
+		assert.NotNil(t, result)
+	})
+
+	suite.Run("fetch failing before its parts delivers nothing", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		pkgURL, err := url.Parse(server.URL + "/pkg.json")
+		assert.Nil(t, err)
+
+		fetcher := NewFetcher(WithOptions(Options{InsecureSkipSignatureVerification: true}))
+		stream := fetcher.FetchStream(context.Background(), *pkgURL, "", tmpDir)
+
+		_, delivered := <-stream.Parts()
+		assert.False(t, delivered)
+
+		_, err = stream.Result()
+		assert.IsType(t, fetcherrors.PkgMetaError{}, err)
+	})
+}
@@ -0,0 +1,59 @@
+package fetch
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"io"
+)
+
+// max bytes admitted by the limiter at once; io.Copy reads in chunks of this size
+const maxThrottleBurst = 32 * 1024
+
+// newBandwidthLimiter returns a limiter admitting bytesPerSecond or nil if
+// bytesPerSecond is 0 (unlimited).
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	burst := int(bytesPerSecond)
+	if bytesPerSecond > maxThrottleBurst {
+		burst = maxThrottleBurst
+	}
+
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// throttledReader limits reads from the wrapped reader to the rate permitted
+// by its limiter. The limiter may be shared by many readers so that their
+// aggregate rate is capped.
+type throttledReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := t.reader.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.WaitN(t.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+
+	return n, err
+}
+
+// throttle wraps the given reader with the session's bandwidth limiter, if
+// one is configured. Waits for the limiter are abandoned once ctx is done.
+func (s *fetchSession) throttle(ctx context.Context, reader io.Reader) io.Reader {
+	if s.limiter == nil {
+		return reader
+	}
+
+	return &throttledReader{ctx, reader, s.limiter}
+}
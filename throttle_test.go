@@ -0,0 +1,46 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Throttle_Suite(suite *testing.T) {
+
+	suite.Run("unlimited session does not wrap readers", func(t *testing.T) {
+		session := newFetchSession(Options{})
+		reader := bytes.NewReader([]byte("content"))
+
+		assert.Equal(t, io.Reader(reader), session.throttle(context.Background(), reader))
+	})
+
+	suite.Run("limiter is shared so the aggregate rate is capped", func(t *testing.T) {
+		bytesPerSecond := int64(64 * 1024)
+		session := newFetchSession(Options{BytesPerSecond: bytesPerSecond})
+
+		var group sync.WaitGroup
+		start := time.Now()
+
+		// two readers, each reading one second's worth of content; the first burst is free
+		for ix := 0; ix < 2; ix++ {
+			group.Add(1)
+			go func() {
+				defer group.Done()
+				read, err := io.Copy(ioutil.Discard, session.throttle(context.Background(), bytes.NewReader(make([]byte, bytesPerSecond))))
+				assert.Nil(t, err)
+				assert.EqualValues(t, bytesPerSecond, read)
+			}()
+		}
+
+		group.Wait()
+		assert.True(t, time.Since(start) > time.Second, "aggregate rate exceeded the configured limit")
+	})
+}
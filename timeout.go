@@ -0,0 +1,40 @@
+package fetch
+
+import (
+	"time"
+)
+
+const (
+	// defaultMinPartBytesPerSecond is the slowest throughput (100 kbit/s)
+	// assumed of a part source by DefaultPartTimeout
+	defaultMinPartBytesPerSecond = 100 * 1024 / 8
+
+	// defaultMinPartTimeout is the shortest timeout DefaultPartTimeout gives
+	// any part
+	defaultMinPartTimeout = 2 * time.Minute
+)
+
+// DefaultPartTimeout is the part timeout used if Options.PartTimeout is nil:
+// the time needed to download the part at 100 kbit/s, but never less than two
+// minutes.
+func DefaultPartTimeout(bytes int64) time.Duration {
+	timeout := time.Duration(bytes/defaultMinPartBytesPerSecond) * time.Second
+	if timeout < defaultMinPartTimeout {
+		return defaultMinPartTimeout
+	}
+
+	return timeout
+}
+
+// partTimeout returns the timeout of a fetch of a part of the given size. A
+// configured PartTimeout that isn't positive would fail the fetch at once, the
+// default is used instead.
+func (s *fetchSession) partTimeout(bytes int64) time.Duration {
+	if s.opts.PartTimeout != nil {
+		if timeout := s.opts.PartTimeout(bytes); timeout > 0 {
+			return timeout
+		}
+	}
+
+	return DefaultPartTimeout(bytes)
+}
@@ -0,0 +1,39 @@
+// +build unit
+
+package fetch
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_PartTimeout_Suite(suite *testing.T) {
+	suite.Run("default timeout is never less than the floor", func(t *testing.T) {
+		assert.Equal(t, 2*time.Minute, DefaultPartTimeout(0))
+		assert.Equal(t, 2*time.Minute, DefaultPartTimeout(1024*1024+1))
+	})
+
+	suite.Run("default timeout grows with part size", func(t *testing.T) {
+		// 100 MiB at 100 kbit/s
+		assert.Equal(t, 8192*time.Second, DefaultPartTimeout(100*1024*1024))
+	})
+
+	suite.Run("configured timeout func is used", func(t *testing.T) {
+		session := newFetchSession(Options{PartTimeout: func(bytes int64) time.Duration {
+			return time.Duration(bytes) * time.Millisecond
+		}})
+
+		assert.Equal(t, 5*time.Millisecond, session.partTimeout(5))
+	})
+
+	suite.Run("configured timeout that isn't positive falls back to the default", func(t *testing.T) {
+		session := newFetchSession(Options{PartTimeout: func(bytes int64) time.Duration {
+			return -time.Duration(bytes) * time.Second
+		}})
+
+		assert.Equal(t, 2*time.Minute, session.partTimeout(0))
+		assert.Equal(t, 2*time.Minute, session.partTimeout(5))
+		assert.Equal(t, 8192*time.Second, session.partTimeout(100*1024*1024))
+	})
+}
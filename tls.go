@@ -0,0 +1,88 @@
+package fetch
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// prefixTransport sends requests with URLs matching one of its prefixes
+// through that prefix's transport and all others through the wrapped
+// transport. The longest matching prefix wins.
+type prefixTransport struct {
+	prefixes   []string
+	transports map[string]http.RoundTripper
+	next       http.RoundTripper
+}
+
+func (t *prefixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqURL := req.URL.String()
+
+	for _, prefix := range t.prefixes {
+		if strings.HasPrefix(reqURL, prefix) {
+			return t.transports[prefix].RoundTrip(req)
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// withClientCertificates returns client with a transport that presents the
+// TLS client certificate configured in authCreds (by "client_cert" and
+// "client_key" file paths) for the matching URL prefix. The client's other TLS
+// settings, such as its RootCAs, are kept. Only *http.Transport transports
+// (including the default transport used if client.Transport is nil) can be
+// configured.
+func withClientCertificates(client *http.Client, authCreds map[string]map[string]string, session *fetchSession) (*http.Client, error) {
+	roundTripper := client.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+
+	certTransport := &prefixTransport{
+		transports: make(map[string]http.RoundTripper),
+		next:       roundTripper,
+	}
+
+	for prefix, creds := range authCreds {
+		certFile, keyFile := creds["client_cert"], creds["client_key"]
+		if certFile == "" && keyFile == "" {
+			continue
+		}
+
+		transport, ok := roundTripper.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("Unable to configure TLS client certificate for %v on HTTP client transport of type %T", prefix, roundTripper)
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load TLS client certificate %v and key %v for %v. Error: %v", certFile, keyFile, prefix, err)
+		}
+
+		withCert := transport.Clone()
+		if withCert.TLSClientConfig == nil {
+			withCert.TLSClientConfig = &tls.Config{}
+		}
+		withCert.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		session.trackTransport(withCert)
+
+		session.log.Infof(3, "Using TLS client certificate %v for requests to %v", certFile, prefix)
+		certTransport.prefixes = append(certTransport.prefixes, prefix)
+		certTransport.transports[prefix] = withCert
+	}
+
+	if len(certTransport.prefixes) == 0 {
+		return client, nil
+	}
+
+	sort.Slice(certTransport.prefixes, func(i, j int) bool {
+		return len(certTransport.prefixes[i]) > len(certTransport.prefixes[j])
+	})
+
+	configured := *client
+	configured.Transport = certTransport
+	return &configured, nil
+}
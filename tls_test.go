@@ -0,0 +1,111 @@
+// +build unit
+
+package fetch
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed client certificate and its key to dir
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fetch-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile := path.Join(dir, "client.crt")
+	keyFile := path.Join(dir, "client.key")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile
+}
+
+func Test_ClientCertificates_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-tls-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	certFile, keyFile := writeClientCert(suite, tmpDir)
+
+	var authHeader string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	session := newFetchSession(Options{})
+
+	get := func(authCreds map[string]map[string]string) error {
+		// the server's client trusts the server's certificate; that trust must be kept
+		client, err := session.configureClient(server.Client(), authCreds)
+		if err != nil {
+			return err
+		}
+
+		req, err := authenticatedRequest(context.Background(), server.URL+"/part", authCreds, session)
+		if err != nil {
+			return err
+		}
+
+		response, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return response.Body.Close()
+	}
+
+	suite.Run("client certificate is presented with basic auth for matching prefix", func(t *testing.T) {
+		authHeader = ""
+
+		err := get(map[string]map[string]string{
+			server.URL: {"username": "user", "password": "secret", "client_cert": certFile, "client_key": keyFile},
+		})
+		assert.Nil(t, err)
+		assert.NotEmpty(t, authHeader)
+	})
+
+	suite.Run("client certificate is not presented for other prefixes", func(t *testing.T) {
+		err := get(map[string]map[string]string{
+			"https://other.example.com": {"client_cert": certFile, "client_key": keyFile},
+		})
+		assert.NotNil(t, err)
+	})
+
+	suite.Run("unreadable client certificate is an error", func(t *testing.T) {
+		err := get(map[string]map[string]string{
+			server.URL: {"client_cert": path.Join(tmpDir, "missing.crt"), "client_key": keyFile},
+		})
+		assert.NotNil(t, err)
+	})
+}
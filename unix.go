@@ -0,0 +1,66 @@
+package fetch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// withUnixSockets returns client with a transport that dials the Unix domain
+// sockets of the hosts in the session's UnixSockets rather than connecting to
+// them over TCP, bypassing any proxy; other hosts are dialed as before. Only *http.Transport
+// transports (including the default transport used if client.Transport is
+// nil) can be configured.
+func withUnixSockets(client *http.Client, session *fetchSession) *http.Client {
+	if len(session.opts.UnixSockets) == 0 {
+		return client
+	}
+
+	roundTripper := client.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		session.log.Errorf("Unable to configure Unix sockets on HTTP client transport of type %T, requests will not use them", roundTripper)
+		return client
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	dial := transport.DialContext
+	if dial == nil {
+		dial = dialer.DialContext
+	}
+
+	socketed := transport.Clone()
+	socketed.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		if socket, ok := session.opts.UnixSockets[host]; ok {
+			session.log.Infof(6, "Dialing Unix socket %v for host %v", socket, host)
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+
+		return dial(ctx, network, address)
+	}
+
+	// requests over a socket don't go through any proxy
+	if proxy := socketed.Proxy; proxy != nil {
+		socketed.Proxy = func(req *http.Request) (*url.URL, error) {
+			if _, ok := session.opts.UnixSockets[req.URL.Hostname()]; ok {
+				return nil, nil
+			}
+			return proxy(req)
+		}
+	}
+
+	configured := *client
+	configured.Transport = socketed
+	return &configured
+}
@@ -0,0 +1,71 @@
+// +build unit
+
+package fetch
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_UnixSockets_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-unix-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	socket := path.Join(tmpDir, "pkg-proxy.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.Nil(suite, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + r.URL.Path))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	suite.Run("requests to a socket's host are sent over it, bypassing the proxy", func(t *testing.T) {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("Unexpected proxied request for %v", r.URL)
+		}))
+		defer proxy.Close()
+
+		proxyURL, err := url.Parse(proxy.URL)
+		assert.Nil(t, err)
+
+		session := newFetchSession(Options{ProxyURL: proxyURL, UnixSockets: map[string]string{"pkg-proxy": socket}})
+		client, err := session.configureClient(&http.Client{}, nil)
+		assert.Nil(t, err)
+
+		response, err := client.Get("http://pkg-proxy/pkgs/pkg.json")
+		assert.Nil(t, err)
+		defer response.Body.Close()
+
+		body, err := ioutil.ReadAll(response.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "pkg-proxy/pkgs/pkg.json", string(body))
+	})
+
+	suite.Run("other hosts are dialed over TCP", func(t *testing.T) {
+		tcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("tcp"))
+		}))
+		defer tcp.Close()
+
+		client := withUnixSockets(&http.Client{Transport: &http.Transport{}}, newFetchSession(Options{UnixSockets: map[string]string{"pkg-proxy": socket}}))
+
+		response, err := client.Get(tcp.URL)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+
+		body, err := ioutil.ReadAll(response.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "tcp", string(body))
+	})
+}
@@ -0,0 +1,107 @@
+// +build unit
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_UserAgent_Suite(suite *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fetch-test-useragent-")
+	assert.Nil(suite, err)
+	defer os.RemoveAll(tmpDir)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	// the User-Agent of each kind of request received, by kind
+	var lock sync.Mutex
+	var flakyRequests int
+	agents := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		kind := "part"
+		switch {
+		case r.Method == http.MethodHead:
+			kind = "head"
+		case r.URL.Path == "/pkg.json":
+			kind = "meta"
+		case r.Header.Get("Range") != "":
+			kind = "resume"
+		case r.URL.Path == "/flaky":
+			flakyRequests++
+			if flakyRequests == 1 {
+				agents["part"] = append(agents["part"], r.UserAgent())
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			kind = "retry"
+		}
+		agents[kind] = append(agents[kind], r.UserAgent())
+
+		if kind == "meta" {
+			w.Write([]byte(`{"id": "pkg"}`))
+			return
+		}
+		http.ServeContent(w, r, "part", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// requests each kind of request with a session configured with opts
+	requestAll := func(t *testing.T, name string, opts Options) {
+		lock.Lock()
+		flakyRequests = 0
+		agents = make(map[string][]string)
+		lock.Unlock()
+
+		opts.Retry = RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+		session := newFetchSession(opts)
+
+		// the meta isn't signed, only its request matters
+		fetchPkgMeta(context.Background(), &http.Client{}, nil, "", "", server.URL+"/pkg.json", "", "", false, session)
+
+		sources := []horizonpkg.PartSource{{URL: "/part"}}
+		assert.Nil(t, headPrecheckParts(context.Background(), &http.Client{}, nil, server.URL, horizonpkg.DockerImageParts{"part": {Bytes: int64(len(content)), Sources: sources}}, session))
+		assert.Nil(t, fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", path.Join(tmpDir, name+"-part"), int64(len(content)), "", sources, session))
+		assert.Nil(t, fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", path.Join(tmpDir, name+"-retried"), int64(len(content)), "", []horizonpkg.PartSource{{URL: "/flaky"}}, session))
+
+		resumed := newFetchSession(Options{UserAgent: opts.UserAgent, ResumeDownloads: true})
+		resumedPath := path.Join(tmpDir, name+"-resumed")
+		assert.Nil(t, ioutil.WriteFile(resumedPath+".part", content[:300], 0600))
+		assert.Nil(t, fetchPkgPart(context.Background(), &http.Client{}, nil, server.URL, "part", resumedPath, int64(len(content)), "", sources, resumed))
+	}
+
+	suite.Run("configured User-Agent is sent on every kind of request", func(t *testing.T) {
+		requestAll(t, "configured", Options{UserAgent: "edge-agent/2.1"})
+
+		for _, kind := range []string{"meta", "part", "retry", "resume", "head"} {
+			assert.NotEmpty(t, agents[kind], "no %v request", kind)
+			for _, agent := range agents[kind] {
+				assert.Equal(t, "edge-agent/2.1", agent, kind)
+			}
+		}
+	})
+
+	suite.Run("default User-Agent is sent if none is configured", func(t *testing.T) {
+		requestAll(t, "default", Options{})
+
+		for _, kind := range []string{"meta", "part", "retry", "resume", "head"} {
+			assert.NotEmpty(t, agents[kind], "no %v request", kind)
+			for _, agent := range agents[kind] {
+				assert.Equal(t, DefaultUserAgent, agent, kind)
+			}
+		}
+	})
+}
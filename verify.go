@@ -0,0 +1,120 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-horizon/horizon-pkg-fetch/fetcherrors"
+	"github.com/open-horizon/horizon-pkg-fetch/horizonpkg"
+	"path"
+	"sort"
+	"strings"
+)
+
+// PartVerification reports the verification of a single Pkg part on disk. Err
+// is nil if the part passed hash and signature verification.
+type PartVerification struct {
+	ID   string
+	Path string
+	Err  error
+}
+
+// VerifyReport reports the verification of a Pkg already on disk
+type VerifyReport struct {
+	Pkg   *horizonpkg.Pkg
+	Parts map[string]PartVerification
+}
+
+// Failed returns the sorted IDs of the parts that failed verification
+func (r *VerifyReport) Failed() []string {
+	var failed []string
+	for id, part := range r.Parts {
+		if part.Err != nil {
+			failed = append(failed, id)
+		}
+	}
+	sort.Strings(failed)
+
+	return failed
+}
+
+// PkgVerify verifies a Pkg previously fetched into destinationDir without any
+// network I/O: the Pkg meta file <pkgID>.json is verified with pkgSignature and
+// each of the Pkg's parts (or those selected by opts.PartIDs) is verified
+// against its hash and signatures. Files that fail verification are reported,
+// never deleted. An error is returned if the meta or any part file is missing.
+//
+// Deprecated: use PkgVerifyContext, which can be canceled.
+func PkgVerify(pkgID string, pkgSignature string, destinationDir string, primarySigningKey string, userKeysDir string, opts Options) (*VerifyReport, error) {
+	return PkgVerifyContext(context.Background(), pkgID, pkgSignature, destinationDir, primarySigningKey, userKeysDir, opts)
+}
+
+// PkgVerifyContext behaves like PkgVerify but stops verifying parts, and
+// returns ctx's error, once ctx is done.
+func PkgVerifyContext(ctx context.Context, pkgID string, pkgSignature string, destinationDir string, primarySigningKey string, userKeysDir string, opts Options) (*VerifyReport, error) {
+	session := newFetchSession(opts)
+	session.verifyOnly = true
+
+	if err := session.requireSignature(pkgSignature); err != nil {
+		return nil, err
+	}
+
+	metaPath := path.Join(destinationDir, fmt.Sprintf("%v.json", pkgID))
+	rawBody, err := readStoredMeta(metaPath, false, session)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := parsePkgMeta(ctx, rawBody, primarySigningKey, userKeysDir, metaPath, pkgSignature, session)
+	if err != nil {
+		return nil, err
+	}
+	session.pkgID = pkg.ID
+
+	parts, err := precheckPkgParts(pkg, session.opts.PartIDs, session)
+	if err != nil {
+		return nil, fetcherrors.PkgPrecheckError{"Failed to validate Pkg information before verifying", err}
+	}
+
+	pkgDestinationDir := path.Join(destinationDir, pkg.ID)
+
+	var missing []string
+	for name := range parts {
+		if _, err := session.partSize(session.partPath(pkgDestinationDir, name)); err != nil {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fetcherrors.PkgSourceError{fmt.Sprintf("Pkg parts missing from %v", pkgDestinationDir), fmt.Errorf("Missing parts: %v", strings.Join(missing, ", "))}
+	}
+
+	if err := session.trustIndex(ctx, primarySigningKey, userKeysDir, parts); err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{
+		Pkg:   pkg,
+		Parts: make(map[string]PartVerification),
+	}
+
+	for name, part := range parts {
+		partPath := session.partPath(pkgDestinationDir, name)
+
+		err := verifyPkgPart(ctx, primarySigningKey, userKeysDir, partPath, part.Sha256sum, part.Digests, session.partSignatures(name, part), session)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// the part wasn't checked
+			return nil, ctxErr
+		} else if err != nil {
+			session.log.Errorf("Part %v failed verification. Error: %v", partPath, err)
+		}
+
+		report.Parts[name] = PartVerification{
+			ID:   name,
+			Path: partPath,
+			Err:  err,
+		}
+	}
+
+	return report, nil
+}
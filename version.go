@@ -0,0 +1,9 @@
+package fetch
+
+// Version is the version of this library; it is included in the default
+// User-Agent of outbound requests.
+const Version = "0.1.0"
+
+// DefaultUserAgent is the User-Agent header value sent on requests if none is
+// configured.
+const DefaultUserAgent = "horizon-pkg-fetch/" + Version